@@ -0,0 +1,111 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/virtualfs/nodes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	clientTimeout = 30 * time.Second
+	bearerPrefix  = "Bearer "
+	// socketHost is the host of the URLs of an endpoint on a unix socket, which the dialer ignores
+	socketHost = "control"
+)
+
+// Client calls the control endpoint of a running server
+type Client struct {
+	address string
+	token   string
+	baseUrl string
+	http    *http.Client
+}
+
+// NewClient returns a client of the control endpoint at an address, which is the path of a unix socket or a TCP host
+// and port, authenticating by a token unless it's empty
+func NewClient(address string, token string) *Client {
+	client := &Client{
+		address: address,
+		token:   token,
+		baseUrl: "http://" + address,
+		http:    &http.Client{Timeout: clientTimeout},
+	}
+	if isSocketAddress(address) {
+		client.baseUrl = "http://" + socketHost
+		client.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", address)
+			},
+		}
+	} else if strings.HasPrefix(address, ":") {
+		client.baseUrl = "http://" + loopbackHost + address
+	}
+	return client
+}
+
+// NewSharedClient returns a client of the control endpoint set by the shared options
+func NewSharedClient(opts *common.SharedOptions) (client *Client, err error) {
+	if len(opts.ControlAddress) == 0 {
+		return nil, errors.New("the --control-address of the server is required")
+	}
+	var token string
+	token, err = sharedToken(opts)
+	if err != nil {
+		return
+	}
+	return NewClient(opts.ControlAddress, token), nil
+}
+
+// StartPrefetch asks the server to start prefetching the targets, returning the id of the job
+func (client *Client) StartPrefetch(targets []nodes.PrefetchTarget) (id string, err error) {
+	var body []byte
+	body, err = json.Marshal(&PrefetchRequest{Targets: targets})
+	if err != nil {
+		return
+	}
+	var response PrefetchResponse
+	err = client.Call(http.MethodPost, PrefetchPath, bytes.NewReader(body), http.StatusAccepted, &response)
+	return response.Id, err
+}
+
+// ReadPrefetchProgress reads the progress of a prefetch job
+func (client *Client) ReadPrefetchProgress(id string) (progress *nodes.PrefetchProgress, err error) {
+	progress = &nodes.PrefetchProgress{}
+	err = client.Call(http.MethodGet, PrefetchPath+"/"+id, nil, http.StatusOK, progress)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Call calls a path of the endpoint, decoding the JSON response of the expected status into the result
+func (client *Client) Call(method string, path string, body io.Reader, expectedStatus int, result interface{}) error {
+	url := client.baseUrl + path
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if len(client.token) > 0 {
+		request.Header.Set("Authorization", bearerPrefix+client.token)
+	}
+	response, err := client.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("calling the control endpoint at %v: %w", client.address, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != expectedStatus {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%v %v: %v: %v", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
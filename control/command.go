@@ -0,0 +1,193 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/urfave/cli"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultProgressInterval = time.Second
+)
+
+// PrefetchApp has a running server prefetch commitishes into its caches, through the server's control endpoint
+type PrefetchApp struct {
+}
+
+var _ common.App = &PrefetchApp{}
+
+type options struct {
+	*common.SharedOptions
+	client           *Client
+	targets          []nodes.PrefetchTarget
+	noWait           bool
+	progressInterval time.Duration
+	writer           io.Writer
+}
+
+func (app *PrefetchApp) DeclareCommand() cli.Command {
+	command := cli.Command{
+		Name:      "prefetch",
+		Usage:     "Have a running server load the trees and files of commitishes into its caches in the background",
+		ArgsUsage: "[target...]",
+		Description: `ARGS:
+    target   <repository>/<commitish>, whose files matching any --glob are prefetched, or all its files without globs
+
+   A targets file has a target per line followed by its own globs, separated by spaces, e.g. 'repo/master src/* *.md'.
+   Files are only prefetched by servers with a blob cache, otherwise only trees are.`,
+		Flags: append(common.SharedFlags("ERROR"),
+
+			cli.StringSliceFlag{
+				Name:  "glob",
+				Usage: "Prefetch only files matching this glob, or under directories matching it (e.g. src/*/main.go), for targets without globs of their own.",
+			},
+
+			cli.StringFlag{
+				Name:  "targets-file",
+				Value: "",
+				Usage: "Path to a file of targets to prefetch besides those given as arguments, - for stdin.",
+			},
+
+			cli.BoolFlag{
+				Name:  "no-wait",
+				Usage: "Exit once prefetching started, printing the id of the job, rather than reporting progress until it's done.",
+			},
+
+			cli.DurationFlag{
+				Name:  "progress-interval",
+				Value: DefaultProgressInterval,
+				Usage: "Interval for reporting the progress of prefetching.",
+			},
+		),
+	}
+	common.DeclareConfig(&command, "")
+	return command
+}
+
+func (app *PrefetchApp) ParseOptions(ctx *cli.Context) (common.Options, error) {
+	sharedOptions := common.ParseSharedOptions(ctx)
+	client, err := NewSharedClient(sharedOptions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ctx.Command.Name, err)
+	}
+
+	var targets []nodes.PrefetchTarget
+	for _, arg := range ctx.Args() {
+		target, err := parseTarget(arg)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	if targetsFile := ctx.String("targets-file"); len(targetsFile) > 0 {
+		fileTargets, err := readTargetsFile(targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fileTargets...)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s takes at least one target, as an argument or in a --targets-file. Run `%s %s --help` for more info",
+			ctx.Command.Name, ctx.App.Name, ctx.Command.Name)
+	}
+	for i := range targets {
+		if len(targets[i].Globs) == 0 {
+			targets[i].Globs = ctx.StringSlice("glob")
+		}
+	}
+
+	return &options{
+		SharedOptions:    sharedOptions,
+		client:           client,
+		targets:          targets,
+		noWait:           ctx.Bool("no-wait"),
+		progressInterval: ctx.Duration("progress-interval"),
+		writer:           ctx.App.Writer,
+	}, nil
+}
+
+// parseTarget parses <repository>/<commitish>, the commitish being all that follows the first slash
+func parseTarget(arg string) (target nodes.PrefetchTarget, err error) {
+	separator := strings.Index(arg, "/")
+	if separator <= 0 || separator == len(arg)-1 {
+		return target, fmt.Errorf("invalid target '%v', expected <repository>/<commitish>", arg)
+	}
+	target.Repository = arg[:separator]
+	target.Commitish = arg[separator+1:]
+	return
+}
+
+func readTargetsFile(targetsFile string) (targets []nodes.PrefetchTarget, err error) {
+	var reader io.Reader = os.Stdin
+	if targetsFile != "-" {
+		var file *os.File
+		file, err = os.Open(targetsFile)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var target nodes.PrefetchTarget
+		target, err = parseTarget(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", targetsFile, err)
+		}
+		target.Globs = fields[1:]
+		targets = append(targets, target)
+	}
+	return targets, scanner.Err()
+}
+
+func (app *PrefetchApp) RunUntilStopped(opts common.Options) error {
+	// the progress goes to stdout, so logs must not
+	logger.SetConsoleOutput(os.Stderr)
+	return runPrefetch(opts.(*options))
+}
+
+func runPrefetch(opts *options) (err error) {
+	var id string
+	id, err = opts.client.StartPrefetch(opts.targets)
+	if err != nil {
+		return
+	}
+	if opts.noWait {
+		fmt.Fprintln(opts.writer, id)
+		return
+	}
+
+	var progress *nodes.PrefetchProgress
+	for {
+		time.Sleep(opts.progressInterval)
+		progress, err = opts.client.ReadPrefetchProgress(id)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(opts.writer, "listed %v/%v targets, read %v/%v files (%.1f MB), %v failed\n",
+			progress.TargetsListed, progress.Targets, progress.FilesRead, progress.Files,
+			float64(progress.BytesRead)/(1024*1024), progress.Failed)
+		if progress.Done {
+			break
+		}
+	}
+	for _, message := range progress.Errors {
+		fmt.Fprintln(opts.writer, message)
+	}
+	if progress.Failed > 0 {
+		return fmt.Errorf("prefetching %v targets or files failed", progress.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
+	testutils "gitreefs/test_utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type controlTestSuite struct {
+	suite.Suite
+	clonesPath string
+	root       *nodes.Root
+	server     *Server
+}
+
+func TestControlTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/control_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(controlTestSuite))
+}
+
+func (controlSuite *controlTestSuite) SetupTest() {
+	controlSuite.clonesPath, _ = testutils.SetupLocalClones()
+	var err error
+	controlSuite.root, err = nodes.NewRoot(controlSuite.clonesPath)
+	if err != nil {
+		panic(err)
+	}
+	controlSuite.server, err = Serve(":0", "", controlSuite.root)
+	if err != nil {
+		panic(err)
+	}
+	err = git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 1024 * 1024})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (controlSuite *controlTestSuite) TearDownTest() {
+	git.SetBlobCache(nil)
+	controlSuite.server.Close()
+	os.RemoveAll(controlSuite.clonesPath)
+}
+
+func (controlSuite *controlTestSuite) TestPrefetch() {
+	client := NewClient(controlSuite.server.Address(), "")
+	id, err := client.StartPrefetch([]nodes.PrefetchTarget{
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master", Globs: []string{"src/*"}},
+	})
+	controlSuite.Nil(err)
+
+	var progress *nodes.PrefetchProgress
+	for progress == nil || !progress.Done {
+		time.Sleep(10 * time.Millisecond)
+		progress, err = client.ReadPrefetchProgress(id)
+		controlSuite.Nil(err)
+	}
+	controlSuite.Equal(1, progress.TargetsListed)
+	controlSuite.Equal(3, progress.FilesRead)
+	controlSuite.Equal(0, progress.Failed)
+
+	_, err = client.ReadPrefetchProgress("wat")
+	controlSuite.Contains(err.Error(), "404")
+	_, err = client.StartPrefetch([]nodes.PrefetchTarget{
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master", Globs: []string{"src/["}},
+	})
+	controlSuite.Contains(err.Error(), "400")
+}
+
+func (controlSuite *controlTestSuite) TestLoopbackByDefault() {
+	controlSuite.True(strings.HasPrefix(controlSuite.server.Address(), "127.0.0.1:"))
+	_, err := Serve("0.0.0.0:0", "", controlSuite.root)
+	controlSuite.NotNil(err)
+}
+
+func (controlSuite *controlTestSuite) TestToken() {
+	server, err := Serve("0.0.0.0:0", "secret", controlSuite.root)
+	controlSuite.Nil(err)
+	defer server.Close()
+	targets := []nodes.PrefetchTarget{{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master"}}
+
+	_, err = NewClient(server.Address(), "").StartPrefetch(targets)
+	controlSuite.Contains(err.Error(), "401")
+	_, err = NewClient(server.Address(), "wat").StartPrefetch(targets)
+	controlSuite.Contains(err.Error(), "401")
+	id, err := NewClient(server.Address(), "secret").StartPrefetch(targets)
+	controlSuite.Nil(err)
+
+	// the token is only accepted as a bearer token
+	for authorization, status := range map[string]int{
+		"Bearer secret": http.StatusOK,
+		"secret":        http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"Bearer secre":  http.StatusUnauthorized,
+	} {
+		request, err := http.NewRequest(http.MethodGet, "http://"+server.Address()+PrefetchPath+"/"+id, nil)
+		controlSuite.Nil(err)
+		request.Header.Set("Authorization", authorization)
+		response, err := http.DefaultClient.Do(request)
+		controlSuite.Nil(err)
+		response.Body.Close()
+		controlSuite.Equal(status, response.StatusCode, authorization)
+	}
+}
+
+func (controlSuite *controlTestSuite) TestUnixSocket() {
+	socketPath := filepath.Join(controlSuite.clonesPath, "control.sock")
+	server, err := Serve(socketPath, "", controlSuite.root)
+	controlSuite.Nil(err)
+	info, err := os.Stat(socketPath)
+	controlSuite.Nil(err)
+	controlSuite.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	client := NewClient(socketPath, "")
+	id, err := client.StartPrefetch([]nodes.PrefetchTarget{{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master"}})
+	controlSuite.Nil(err)
+	_, err = client.ReadPrefetchProgress(id)
+	controlSuite.Nil(err)
+	controlSuite.Nil(server.Close())
+	_, err = os.Stat(socketPath)
+	controlSuite.True(os.IsNotExist(err), err)
+
+	// a restarted server listens on the same socket
+	server, err = Serve(socketPath, "", controlSuite.root)
+	controlSuite.Nil(err)
+	controlSuite.Nil(server.Close())
+}
+
+func (controlSuite *controlTestSuite) TestRequestSizeLimit() {
+	globs := make([]string, maxRequestBytes/4)
+	for i := range globs {
+		globs[i] = "src"
+	}
+	_, err := NewClient(controlSuite.server.Address(), "").StartPrefetch([]nodes.PrefetchTarget{
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master", Globs: globs},
+	})
+	controlSuite.Contains(err.Error(), "400")
+}
+
+func (controlSuite *controlTestSuite) TestRunPrefetch() {
+	output := &bytes.Buffer{}
+	err := runPrefetch(&options{
+		SharedOptions: &common.SharedOptions{ControlAddress: controlSuite.server.Address()},
+		client:        NewClient(controlSuite.server.Address(), ""),
+		targets: []nodes.PrefetchTarget{
+			{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master"},
+			{Repository: testutils.LOCAL_REPO_NAME, Commitish: "wat"},
+		},
+		progressInterval: 10 * time.Millisecond,
+		writer:           output,
+	})
+	controlSuite.NotNil(err)
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	files := len(testutils.LocalFiles) + len(testutils.LocalSecondCommitFiles)
+	controlSuite.Contains(lines[len(lines)-2], fmt.Sprintf("listed 1/2 targets, read %v/%v files", files, files))
+	controlSuite.Contains(lines[len(lines)-1], "wat")
+}
+
+func (controlSuite *controlTestSuite) TestReadTargetsFile() {
+	targetsFile := filepath.Join(controlSuite.clonesPath, "targets")
+	err := os.WriteFile(targetsFile, []byte("# comment\nlocal/master src/* *.md\n\nlocal/feature/branch\n"), 0644)
+	controlSuite.Nil(err)
+	targets, err := readTargetsFile(targetsFile)
+	controlSuite.Nil(err)
+	controlSuite.Equal([]nodes.PrefetchTarget{
+		{Repository: "local", Commitish: "master", Globs: []string{"src/*", "*.md"}},
+		{Repository: "local", Commitish: "feature/branch", Globs: []string{}},
+	}, targets)
+
+	_, err = parseTarget("local")
+	controlSuite.NotNil(err)
+	_, err = parseTarget("local/")
+	controlSuite.NotNil(err)
+}
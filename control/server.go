@@ -0,0 +1,269 @@
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	PrefetchPath = "/prefetch"
+
+	// the progress of this many of the last jobs is kept, older jobs are forgotten once done
+	maxKeptJobs = 100
+	// request bodies beyond this size are rejected
+	maxRequestBytes = 1024 * 1024
+	// hosts without a host serve on this one, so only local callers reach them
+	loopbackHost = "127.0.0.1"
+)
+
+// PrefetchRequest is the body of a request to start prefetching
+type PrefetchRequest struct {
+	Targets []nodes.PrefetchTarget `json:"targets"`
+}
+
+// PrefetchResponse is the body of the response to a request to start prefetching
+type PrefetchResponse struct {
+	Id string `json:"id"`
+}
+
+// Server serves the control endpoint of a running file system, over the same nodes the file system serves:
+//
+//	POST /prefetch        starts prefetching the targets of a PrefetchRequest, responding with the id of the job
+//	GET  /prefetch/<id>   responds with the nodes.PrefetchProgress of a job
+//
+// The endpoint listens on a unix socket for an address that is a path, and on TCP otherwise, on the loopback interface
+// unless the address has a host. Given a token, requests must carry it as a bearer token, which is required off the loopback.
+type Server struct {
+	root     *nodes.Root
+	token    string
+	listener net.Listener
+	server   *http.Server
+	mux      *http.ServeMux
+	jobs     map[string]*nodes.PrefetchJob
+	jobIds   []string
+	nextId   int
+	mutex    *sync.Mutex
+}
+
+// Serve serves the control endpoint at an address in the background, until closed
+func Serve(address string, token string, root *nodes.Root) (server *Server, err error) {
+	server = &Server{
+		root:  root,
+		token: token,
+		mux:   http.NewServeMux(),
+		jobs:  make(map[string]*nodes.PrefetchJob),
+		mutex: &sync.Mutex{},
+	}
+	server.listener, err = listen(address)
+	if err != nil {
+		return nil, err
+	}
+	tcpAddress, isTcp := server.listener.Addr().(*net.TCPAddr)
+	if isTcp && !tcpAddress.IP.IsLoopback() && len(token) == 0 {
+		_ = server.listener.Close()
+		return nil, fmt.Errorf("serving the control endpoint at %v off the loopback interface requires a token", address)
+	}
+	server.Handle(PrefetchPath, server.startPrefetch)
+	server.Handle(PrefetchPath+"/", server.prefetchProgress)
+	server.server = &http.Server{Handler: server.mux}
+	go func() {
+		serveErr := server.server.Serve(server.listener)
+		if serveErr != http.ErrServerClosed {
+			logger.Error("control endpoint at %v stopped: %v", address, serveErr)
+		}
+	}()
+	logger.Info("control endpoint running at %v", server.listener.Addr())
+	return
+}
+
+// ServeShared serves the control endpoint set by the shared options, returning a nil server if none is set
+func ServeShared(opts *common.SharedOptions, root *nodes.Root) (server *Server, err error) {
+	if len(opts.ControlAddress) == 0 {
+		return nil, nil
+	}
+	var token string
+	token, err = sharedToken(opts)
+	if err != nil {
+		return
+	}
+	return Serve(opts.ControlAddress, token, root)
+}
+
+func sharedToken(opts *common.SharedOptions) (token string, err error) {
+	if len(opts.ControlTokenFile) == 0 {
+		return "", nil
+	}
+	return ReadToken(opts.ControlTokenFile)
+}
+
+// isSocketAddress tells whether an address is the path of a unix socket, rather than a TCP host and port
+func isSocketAddress(address string) bool {
+	return strings.Contains(address, "/")
+}
+
+// listen listens on a unix socket only the user may connect to, replacing a stale socket,
+// or on TCP with the loopback host if the address has none
+func listen(address string) (listener net.Listener, err error) {
+	if isSocketAddress(address) {
+		if info, statErr := os.Lstat(address); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(address)
+		}
+		listener, err = net.Listen("unix", address)
+		if err == nil {
+			err = os.Chmod(address, 0600)
+		}
+	} else {
+		var host, port string
+		host, port, err = net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid control address %v: %w", address, err)
+		}
+		if len(host) == 0 {
+			host = loopbackHost
+		}
+		listener, err = net.Listen("tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %v: %w", address, err)
+	}
+	return
+}
+
+// ReadToken reads a token from a file, ignoring surrounding whitespace
+func ReadToken(tokenFile string) (token string, err error) {
+	var contents []byte
+	contents, err = ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return
+	}
+	token = strings.TrimSpace(string(contents))
+	if len(token) == 0 {
+		return "", errors.New("control token file " + tokenFile + " is empty")
+	}
+	return
+}
+
+// Handle serves a path of the endpoint, behind the token check and the request size limit
+func (server *Server) Handle(path string, handler http.HandlerFunc) {
+	server.mux.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+		if !server.authorized(request) {
+			http.Error(writer, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		request.Body = http.MaxBytesReader(writer, request.Body, maxRequestBytes)
+		handler(writer, request)
+	})
+}
+
+func (server *Server) authorized(request *http.Request) bool {
+	if len(server.token) == 0 {
+		return true
+	}
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return false
+	}
+	given := header[len(bearerPrefix):]
+	return subtle.ConstantTimeCompare([]byte(given), []byte(server.token)) == 1
+}
+
+// Address is the address the endpoint listens on
+func (server *Server) Address() string {
+	return server.listener.Addr().String()
+}
+
+// Close stops serving, and removes the unix socket it listened on so the next server can listen on it
+func (server *Server) Close() error {
+	err := server.server.Close()
+	socketAddress, isSocket := server.listener.Addr().(*net.UnixAddr)
+	if isSocket {
+		removeErr := os.Remove(socketAddress.Name)
+		if err == nil && removeErr != nil && !os.IsNotExist(removeErr) {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+func (server *Server) startPrefetch(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "prefetching is started by POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var body PrefetchRequest
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("invalid prefetch request: %v", err), http.StatusBadRequest)
+		return
+	}
+	job, err := nodes.StartPrefetch(server.root, body.Targets)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := server.addJob(job)
+	logger.Info("Prefetch %v of %v targets started", id, len(body.Targets))
+	WriteJson(writer, http.StatusAccepted, &PrefetchResponse{Id: id})
+}
+
+func (server *Server) prefetchProgress(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "progress is read by GET", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(request.URL.Path, PrefetchPath+"/")
+	server.mutex.Lock()
+	job, found := server.jobs[id]
+	server.mutex.Unlock()
+	if !found {
+		http.Error(writer, fmt.Sprintf("no prefetch %v", id), http.StatusNotFound)
+		return
+	}
+	progress := job.Progress()
+	WriteJson(writer, http.StatusOK, &progress)
+}
+
+// addJob keeps a job by a new id, forgetting the oldest done jobs beyond maxKeptJobs
+func (server *Server) addJob(job *nodes.PrefetchJob) (id string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.nextId++
+	id = strconv.Itoa(server.nextId)
+	server.jobs[id] = job
+	server.jobIds = append(server.jobIds, id)
+
+	kept := server.jobIds[:0]
+	excess := len(server.jobIds) - maxKeptJobs
+	for _, jobId := range server.jobIds {
+		if excess > 0 && server.jobs[jobId].Progress().Done {
+			delete(server.jobs, jobId)
+			excess--
+			continue
+		}
+		kept = append(kept, jobId)
+	}
+	server.jobIds = kept
+	return
+}
+
+// WriteJson writes a response of a status with a value as its JSON body
+func WriteJson(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(value)
+	if err != nil {
+		logger.Info("control endpoint failed writing a response: %v", err)
+	}
+}
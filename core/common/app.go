@@ -5,37 +5,185 @@ import (
 	"github.com/urfave/cli"
 	"gitreefs/core/logger"
 	"os"
+	"time"
 )
 
+const (
+	DefaultMaxFileSizeMB       int64 = 6
+	DefaultLogFile                   = "logs/gitreefs-%v-%v.log"
+	DefaultGitBackend                = "go-git"
+	DefaultMaxConcurrentClones       = 4
+	DefaultCloneTimeout              = 10 * time.Minute
+	DefaultFetchMinInterval          = 10 * time.Second
+	DefaultFetchTimeout              = time.Minute
+
+	DefaultMaxConcurrentGitWork           = 16
+	DefaultMaxConcurrentRepositoryGitWork = 4
+
+	DefaultGitObjectCacheMB int64 = 96
+)
+
+// App is a command of the gitreefs executable
 type App interface {
-	DeclareCli() *cli.App
+	DeclareCommand() cli.Command
 	ParseOptions(*cli.Context) (opts Options, err error)
 	RunUntilStopped(opts Options) error
 }
 
-func RunApp(app App) {
-	cliApp := app.DeclareCli()
+// SharedFlags are the options declared by every command
+func SharedFlags(defaultLogLevel string) []cli.Flag {
+	return []cli.Flag{
+
+		cli.StringFlag{
+			Name:  "log-file",
+			Value: DefaultLogFile,
+			Usage: "Output logs file path format.",
+		},
+
+		cli.StringFlag{
+			Name:  "log-level",
+			Value: defaultLogLevel,
+			Usage: "Set log level.",
+		},
+
+		cli.StringFlag{
+			Name:  "clones-path",
+			Value: "",
+			Usage: "Path to a directory containing git clones.",
+		},
+
+		cli.IntFlag{
+			Name:  "max-file-size-mb",
+			Value: int(DefaultMaxFileSizeMB),
+			Usage: "Size limit of file contents loaded to memory.",
+		},
+
+		cli.StringFlag{
+			Name:  "git-backend",
+			Value: DefaultGitBackend,
+			Usage: "Reader of git objects, go-git or cat-file (a long lived git cat-file process, requiring git).",
+		},
+
+		cli.StringFlag{
+			Name:  "clone-url-template",
+			Value: "",
+			Usage: "Clone repositories missing from the clones path on first access from this URL, with {name} replaced by the repository name (e.g. file:///srv/mirrors/{name}.git).",
+		},
+
+		cli.IntFlag{
+			Name:  "max-concurrent-clones",
+			Value: DefaultMaxConcurrentClones,
+			Usage: "Number of repositories cloned at the same time, others wait for their turn.",
+		},
+
+		cli.DurationFlag{
+			Name:  "clone-timeout",
+			Value: DefaultCloneTimeout,
+			Usage: "Fail clones not done within this duration, including waiting for their turn.",
+		},
+
+		cli.BoolFlag{
+			Name:  "clone-no-wait",
+			Usage: "Fail lookups of a repository being cloned with EAGAIN, rather than waiting for the clone.",
+		},
+
+		cli.BoolFlag{
+			Name:  "fetch-on-miss",
+			Usage: "Fetch commits looked up by a sha missing from their clone from its origin, e.g. commits pushed after the clone.",
+		},
+
+		cli.DurationFlag{
+			Name:  "fetch-min-interval",
+			Value: DefaultFetchMinInterval,
+			Usage: "Least time between fetches on miss of a repository, lookups of missing shas meanwhile aren't found.",
+		},
+
+		cli.DurationFlag{
+			Name:  "fetch-timeout",
+			Value: DefaultFetchTimeout,
+			Usage: "Fail fetches on miss not done within this duration.",
+		},
 
-	var internalErr error
-	cliApp.Action = func(context *cli.Context) {
-		internalErr = runWithContext(context, app)
+		cli.BoolFlag{
+			Name:  "fetch-missing-blobs",
+			Usage: "Fetch blobs missing from partial clones from their promisor remote when read, rather than failing reads with ENODATA.",
+		},
+
+		cli.IntFlag{
+			Name:  "max-concurrent-git-work",
+			Value: DefaultMaxConcurrentGitWork,
+			Usage: "Number of trees and blobs read at the same time across all repositories, lookups go before prefetching, 0 is unlimited.",
+		},
+
+		cli.IntFlag{
+			Name:  "max-concurrent-repository-git-work",
+			Value: DefaultMaxConcurrentRepositoryGitWork,
+			Usage: "Number of trees and blobs read at the same time per repository, 0 is unlimited.",
+		},
+
+		cli.IntFlag{
+			Name:  "blob-cache-size-mb",
+			Usage: "Size of the cache of recently read file contents, 0 disables it.",
+		},
+
+		cli.IntFlag{
+			Name:  "read-ahead-max-blob-kb",
+			Usage: "Read files up to this size of a listed directory into the blob cache in the background, 0 disables read-ahead.",
+		},
+
+		cli.BoolFlag{
+			Name:  "git-keep-descriptors",
+			Usage: "Keep the pack files of a repository open once read by the go-git backend, rather than opening them for every read.",
+		},
+
+		cli.IntFlag{
+			Name:  "git-max-open-descriptors",
+			Usage: "Keep up to this many pack files of a repository open once read by the go-git backend, closing the oldest beyond it.",
+		},
+
+		cli.IntFlag{
+			Name:  "git-object-cache-mb",
+			Value: int(DefaultGitObjectCacheMB),
+			Usage: "Size of the cache of decoded objects of each repository read by the go-git backend, 0 disables it.",
+		},
+
+		cli.IntFlag{
+			Name:  "max-open-pack-files",
+			Usage: "Number of pack files kept open across all repositories, those of the least recently read repositories are closed first, 0 is unlimited.",
+		},
+
+		cli.StringFlag{
+			Name:  "control-address",
+			Value: "",
+			Usage: "Address of the HTTP control endpoint of a server, a port on the loopback interface (e.g. :7070), host:port or the path of a unix socket, which servers only serve if given.",
+		},
+
+		cli.StringFlag{
+			Name:  "control-token-file",
+			Value: "",
+			Usage: "Path to a file of the bearer token callers of the control endpoint must present, required to serve it off the loopback interface.",
+		},
 	}
+}
 
-	runErr := cliApp.Run(os.Args)
-	exitError := false
-	if runErr != nil {
-		logger.Error("%v: %v", cliApp.Name, runErr)
-		exitError = true
+// Command runs an app as a command, once its options are parsed and logging is set up
+func Command(app App) cli.Command {
+	command := app.DeclareCommand()
+	command.Action = func(ctx *cli.Context) error {
+		return runWithContext(ctx, app)
 	}
-	if internalErr != nil {
-		logger.Error("%v: %v", cliApp.Name, internalErr)
-		exitError = true
+	return command
+}
+
+func RunApp(cliApp *cli.App) {
+	err := cliApp.Run(os.Args)
+	if err != nil {
+		logger.Error("%v: %v", cliApp.Name, err)
 	}
 	logger.CloseLoggers()
-	if exitError {
+	if err != nil {
 		os.Exit(1)
 	}
-	return
 }
 
 func runWithContext(ctx *cli.Context, app App) error {
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxRepositoryNameLength = 255
+)
+
+var (
+	ErrInvalidRepositoryName = errors.New("invalid repository name")
+	ErrOutsideClonesPath     = errors.New("repository is outside of the clones path")
+)
+
+// ValidateRepositoryName accepts only plain directory names, so a client supplied name can't point outside the clones path
+// or be taken as an option by git
+func ValidateRepositoryName(name string) error {
+	if len(name) == 0 || len(name) > maxRepositoryNameLength ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "-") ||
+		strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: '%v'", ErrInvalidRepositoryName, name)
+	}
+	for _, char := range name {
+		if char < ' ' || char == 0x7f {
+			return fmt.Errorf("%w: '%v'", ErrInvalidRepositoryName, name)
+		}
+	}
+	return nil
+}
+
+// ResolveClonePath returns the path of the clone of a repository by its name, with symbolic links resolved.
+// Clones linked to from the clones path must still reside under it.
+func ResolveClonePath(clonesPath string, name string) (clonePath string, err error) {
+	err = ValidateRepositoryName(name)
+	if err != nil {
+		return
+	}
+	root, err := filepath.Abs(clonesPath)
+	if err != nil {
+		return
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return
+	}
+	clonePath, err = filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(clonePath, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: '%v' resolves to %v", ErrOutsideClonesPath, name, clonePath)
+	}
+	info, err := os.Stat(clonePath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("clone of '%v' is actually a file at %v", name, clonePath)
+	}
+	return
+}
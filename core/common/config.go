@@ -0,0 +1,414 @@
+package common
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli"
+	"gitreefs/core/logger"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ConfigFlagName = "config"
+	EnvVarPrefix   = "GITREEFS_"
+)
+
+// SettingKind is the type of a config setting, and how it is validated
+type SettingKind int
+
+const (
+	StringSetting    SettingKind = iota
+	PathSetting                  // a non empty path
+	DirectorySetting             // an existing directory
+	FileSetting                  // an existing file
+	CountSetting                 // a non negative integer
+	PortSetting                  // a TCP port
+	BoolSetting                  // true or false
+	DurationSetting              // a non negative duration, e.g. 10m
+	ModeSetting                  // octal permission bits, e.g. 0444
+	LogLevelSetting              // DEBUG, INFO or ERROR
+)
+
+// ConfigSchema is the schema shared by all commands, with the shared settings at the top level
+// and the settings of the fuse (mount) and nfs (serve-nfs) commands under their sections.
+// Settings are named after the commands' flags, so a config file can set any flag.
+var ConfigSchema = map[string]map[string]SettingKind{
+	"": {
+		"log-file":         StringSetting,
+		"log-level":        LogLevelSetting,
+		"clones-path":      DirectorySetting,
+		"max-file-size-mb": CountSetting,
+		"git-backend":      StringSetting,
+
+		"clone-url-template":    StringSetting,
+		"max-concurrent-clones": CountSetting,
+		"clone-timeout":         DurationSetting,
+		"clone-no-wait":         BoolSetting,
+
+		"fetch-on-miss":      BoolSetting,
+		"fetch-min-interval": DurationSetting,
+		"fetch-timeout":      DurationSetting,
+
+		"fetch-missing-blobs": BoolSetting,
+
+		"max-concurrent-git-work":            CountSetting,
+		"max-concurrent-repository-git-work": CountSetting,
+
+		"blob-cache-size-mb":     CountSetting,
+		"read-ahead-max-blob-kb": CountSetting,
+
+		"git-keep-descriptors":     BoolSetting,
+		"git-max-open-descriptors": CountSetting,
+		"git-object-cache-mb":      CountSetting,
+		"max-open-pack-files":      CountSetting,
+
+		"control-address":    StringSetting,
+		"control-token-file": FileSetting,
+	},
+	"fuse": {
+		"mount-point":                   PathSetting,
+		"access-policy":                 FileSetting,
+		"access-policy-reload-interval": DurationSetting,
+		"uid":                           CountSetting,
+		"gid":                           CountSetting,
+		"file-mode":                     ModeSetting,
+		"dir-mode":                      ModeSetting,
+		"allow-other":                   BoolSetting,
+		"default-permissions":           BoolSetting,
+	},
+	"nfs": {
+		"storage-path":                 PathSetting,
+		"host":                         StringSetting,
+		"port":                         PortSetting,
+		"export-root":                  StringSetting,
+		"access-policy":                FileSetting,
+		"handles-cache-size":           CountSetting,
+		"handle-expiry-days":           CountSetting,
+		"handles-maintenance-interval": DurationSetting,
+	},
+}
+
+// Config holds the validated settings of a config file, as flag values
+type Config struct {
+	settingsBySection map[string]map[string]string
+}
+
+// EnvVarOf returns the environment variable overriding a setting, e.g. GITREEFS_LOG_LEVEL for log-level
+func EnvVarOf(name string) string {
+	return EnvVarPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// LoadConfig reads a YAML (.yaml, .yml) or TOML (.toml) config file, and validates it by the schema
+func LoadConfig(configPath string) (config *Config, err error) {
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config at %v: %w", configPath, err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &values)
+	case ".toml":
+		_, err = toml.Decode(string(contents), &values)
+	default:
+		return nil, fmt.Errorf("config at %v must be a .yaml, .yml or .toml file", configPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config at %v: %w", configPath, err)
+	}
+
+	config, err = ValidateConfig(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config at %v: %w", configPath, err)
+	}
+	return
+}
+
+// ValidateConfig checks parsed config values against the schema, rejecting unknown settings
+func ValidateConfig(values map[string]interface{}) (config *Config, err error) {
+	config = &Config{
+		settingsBySection: map[string]map[string]string{"": {}},
+	}
+	for name, value := range values {
+		_, isSection := ConfigSchema[name]
+		if !isSection || len(name) == 0 {
+			config.settingsBySection[""][name], err = validateSetting("", name, value)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sectionValues, isMap := toStringMap(value)
+		if !isMap {
+			return nil, fmt.Errorf("%v must be a section of settings", name)
+		}
+		config.settingsBySection[name] = make(map[string]string)
+		for settingName, settingValue := range sectionValues {
+			config.settingsBySection[name][settingName], err = validateSetting(name, settingName, settingValue)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// Settings returns the settings of a section, overriding the shared ones
+func (config *Config) Settings(section string) map[string]string {
+	settings := make(map[string]string)
+	for name, value := range config.settingsBySection[""] {
+		settings[name] = value
+	}
+	for name, value := range config.settingsBySection[section] {
+		settings[name] = value
+	}
+	return settings
+}
+
+// toStringMap converts a section as parsed by yaml (map[interface{}]interface{}) or toml (map[string]interface{})
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			converted[fmt.Sprint(key)] = value
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return int64(typed), true
+	case int64:
+		return typed, true
+	case uint64:
+		return int64(typed), true
+	case string:
+		parsed, err := strconv.ParseInt(typed, 10, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+func settingName(section string, name string) string {
+	if len(section) == 0 {
+		return name
+	}
+	return section + "." + name
+}
+
+// settingKind returns the kind of a setting of a section
+func settingKind(section string, name string) (kind SettingKind, known bool) {
+	kind, known = ConfigSchema[section][name]
+	if !known {
+		// shared settings may be overridden per section
+		kind, known = ConfigSchema[""][name]
+	}
+	return
+}
+
+// validateSetting checks a value by the kind of its setting, returning it as a flag value
+func validateSetting(section string, name string, value interface{}) (flagValue string, err error) {
+	kind, known := settingKind(section, name)
+	if !known {
+		return "", fmt.Errorf("unknown setting %v", settingName(section, name))
+	}
+	invalid := func(expected string) error {
+		return fmt.Errorf("setting %v must be %v, got '%v'", settingName(section, name), expected, value)
+	}
+
+	switch kind {
+	case CountSetting, PortSetting:
+		number, isInt := toInt(value)
+		if !isInt || number < 0 {
+			return "", invalid("a non negative integer")
+		}
+		if kind == PortSetting && (number < 1 || number > 65535) {
+			return "", invalid("a port between 1 and 65535")
+		}
+		return strconv.FormatInt(number, 10), nil
+	case BoolSetting:
+		flag, isBool := value.(bool)
+		if !isBool {
+			return "", invalid("true or false")
+		}
+		return strconv.FormatBool(flag), nil
+	case ModeSetting:
+		// yaml parses a leading 0 as octal, so modes are either numbers or octal strings
+		var mode int64
+		switch typed := value.(type) {
+		case int:
+			mode = int64(typed)
+		case int64:
+			mode = typed
+		case string:
+			parsed, parseErr := strconv.ParseUint(typed, 8, 32)
+			if parseErr != nil {
+				return "", invalid("octal permission bits")
+			}
+			mode = int64(parsed)
+		default:
+			return "", invalid("octal permission bits")
+		}
+		if mode < 0 || os.FileMode(mode)&^os.ModePerm != 0 {
+			return "", invalid("octal permission bits")
+		}
+		return fmt.Sprintf("%#o", mode), nil
+	}
+
+	text, isString := value.(string)
+	if !isString {
+		return "", invalid("a string")
+	}
+	switch kind {
+	case PathSetting:
+		if len(text) == 0 {
+			return "", invalid("a path")
+		}
+	case DirectorySetting:
+		err = ValidateDirectory(text, false)
+		if err != nil {
+			return "", fmt.Errorf("setting %v: %w", settingName(section, name), err)
+		}
+	case FileSetting:
+		info, statErr := os.Stat(text)
+		if statErr != nil || info.IsDir() {
+			return "", invalid("an existing file")
+		}
+	case DurationSetting:
+		duration, parseErr := time.ParseDuration(text)
+		if parseErr != nil || duration < 0 {
+			return "", invalid("a non negative duration such as 10m")
+		}
+	case LogLevelSetting:
+		if !logger.IsValidLevel(text) {
+			return "", invalid("one of DEBUG, INFO or ERROR")
+		}
+	}
+	return text, nil
+}
+
+// DeclareConfig adds a --config flag to a command, and lets each flag be set by an environment variable or the config file.
+// Flags given on the command line take precedence over environment variables, which take precedence over the file.
+// Environment variables are validated by the schema as config files are.
+func DeclareConfig(command *cli.Command, section string) {
+	for i, flag := range command.Flags {
+		command.Flags[i] = withEnvVar(flag)
+	}
+	command.Flags = append(command.Flags, cli.StringFlag{
+		Name:   ConfigFlagName,
+		EnvVar: EnvVarOf(ConfigFlagName),
+		Usage:  "Path to a YAML or TOML config file, setting any of the other options by name.",
+	})
+
+	flags := command.Flags
+	before := command.Before
+	command.Before = func(ctx *cli.Context) error {
+		err := validateEnvVars(flags, section)
+		if err != nil {
+			return err
+		}
+		err = applyConfig(ctx, flags, section)
+		if err != nil {
+			return err
+		}
+		if before != nil {
+			return before(ctx)
+		}
+		return nil
+	}
+}
+
+func withEnvVar(flag cli.Flag) cli.Flag {
+	envVar := EnvVarOf(flag.GetName())
+	switch typed := flag.(type) {
+	case cli.StringFlag:
+		typed.EnvVar = envVar
+		return typed
+	case cli.IntFlag:
+		typed.EnvVar = envVar
+		return typed
+	case cli.BoolFlag:
+		typed.EnvVar = envVar
+		return typed
+	case cli.DurationFlag:
+		typed.EnvVar = envVar
+		return typed
+	}
+	return flag
+}
+
+// validateEnvVars checks the values of the environment variables setting a command's flags, skipping empty ones as flags do
+func validateEnvVars(flags []cli.Flag, section string) error {
+	for _, flag := range flags {
+		name := flag.GetName()
+		kind, known := settingKind(section, name)
+		envVar := EnvVarOf(name)
+		text := os.Getenv(envVar)
+		if !known || len(text) == 0 {
+			continue
+		}
+		var value interface{} = text
+		if kind == BoolSetting {
+			flagValue, parseErr := strconv.ParseBool(text)
+			if parseErr == nil {
+				value = flagValue
+			}
+		}
+		_, err := validateSetting(section, name, value)
+		if err != nil {
+			return fmt.Errorf("invalid environment variable %v: %w", envVar, err)
+		}
+	}
+	return nil
+}
+
+func applyConfig(ctx *cli.Context, flags []cli.Flag, section string) error {
+	configPath := ctx.String(ConfigFlagName)
+	if len(configPath) == 0 {
+		return nil
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	settings := config.Settings(section)
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !hasFlag(flags, name) || ctx.IsSet(name) {
+			continue
+		}
+		err = ctx.Set(name, settings[name])
+		if err != nil {
+			return fmt.Errorf("invalid config at %v: setting %v: %w", configPath, name, err)
+		}
+	}
+	return nil
+}
+
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		if flag.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir string, name string, contents string) string {
+	configPath := path.Join(dir, name)
+	err := ioutil.WriteFile(configPath, []byte(contents), 0644)
+	assert.Nil(t, err)
+	return configPath
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlPath := writeConfig(t, dir, "gitreefs.yaml", `
+log-level: INFO
+clones-path: `+dir+`
+max-file-size-mb: 10
+fuse:
+  mount-point: /mnt/git
+  file-mode: 0440
+  allow-other: true
+  log-level: DEBUG
+nfs:
+  port: 2050
+  handles-maintenance-interval: 10m
+`)
+	tomlPath := writeConfig(t, dir, "gitreefs.toml", `
+log-level = "INFO"
+clones-path = "`+dir+`"
+max-file-size-mb = 10
+
+[fuse]
+mount-point = "/mnt/git"
+file-mode = "0440"
+allow-other = true
+log-level = "DEBUG"
+
+[nfs]
+port = 2050
+handles-maintenance-interval = "10m"
+`)
+
+	for _, configPath := range []string{yamlPath, tomlPath} {
+		config, err := LoadConfig(configPath)
+		assert.Nil(t, err, configPath)
+		assert.Equal(t, map[string]string{
+			"log-level":        "DEBUG",
+			"clones-path":      dir,
+			"max-file-size-mb": "10",
+			"mount-point":      "/mnt/git",
+			"file-mode":        "0440",
+			"allow-other":      "true",
+		}, config.Settings("fuse"), configPath)
+		assert.Equal(t, map[string]string{
+			"log-level":                    "INFO",
+			"clones-path":                  dir,
+			"max-file-size-mb":             "10",
+			"port":                         "2050",
+			"handles-maintenance-interval": "10m",
+		}, config.Settings("nfs"), configPath)
+	}
+}
+
+func TestLoadInvalidConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadConfig(path.Join(dir, "missing.yaml"))
+	assert.NotNil(t, err)
+	_, err = LoadConfig(writeConfig(t, dir, "gitreefs.json", `{}`))
+	assert.NotNil(t, err)
+
+	for i, contents := range []string{
+		"log-level: [",
+		"wat: 1",
+		"fuse:\n  wat: 1",
+		"fuse: 1",
+		"log-level: TRACE",
+		"clones-path: " + path.Join(dir, "missing"),
+		"max-file-size-mb: -1",
+		"max-file-size-mb: ten",
+		"nfs:\n  port: 70000",
+		"nfs:\n  handles-maintenance-interval: soon",
+		"nfs:\n  access-policy: " + path.Join(dir, "missing.json"),
+		"fuse:\n  file-mode: 01777",
+		"fuse:\n  file-mode: '999'",
+		"fuse:\n  allow-other: yes please",
+		"fuse:\n  mount-point: ''",
+	} {
+		configPath := writeConfig(t, dir, "invalid.yaml", contents)
+		_, err = LoadConfig(configPath)
+		assert.NotNil(t, err, "loaded invalid config #%v: %v", i, contents)
+	}
+}
+
+func TestEnvVarOf(t *testing.T) {
+	assert.Equal(t, "GITREEFS_LOG_LEVEL", EnvVarOf("log-level"))
+	assert.Equal(t, "GITREEFS_CONFIG", EnvVarOf(ConfigFlagName))
+}
+
+func TestDeclareConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	configPath := writeConfig(t, dir, "gitreefs.yaml", `
+log-level: INFO
+log-file: from-file.log
+nfs:
+  port: 2050
+  host: 127.0.0.1
+`)
+
+	run := func(args ...string) (values map[string]string) {
+		command := cli.Command{
+			Name: "serve",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "log-level", Value: "ERROR"},
+				cli.StringFlag{Name: "log-file", Value: "default.log"},
+				cli.StringFlag{Name: "host", Value: ""},
+				cli.IntFlag{Name: "port", Value: 2049},
+			},
+			Action: func(ctx *cli.Context) error {
+				values = map[string]string{
+					"log-level": ctx.String("log-level"),
+					"log-file":  ctx.String("log-file"),
+					"host":      ctx.String("host"),
+					"port":      ctx.String("port"),
+				}
+				return nil
+			},
+		}
+		DeclareConfig(&command, "nfs")
+		app := &cli.App{Name: "test", Commands: []cli.Command{command}}
+		err := app.Run(append([]string{"test", "serve"}, args...))
+		assert.Nil(t, err)
+		return
+	}
+
+	assert.Equal(t, map[string]string{"log-level": "ERROR", "log-file": "default.log", "host": "", "port": "2049"}, run())
+	assert.Equal(t, map[string]string{"log-level": "INFO", "log-file": "from-file.log", "host": "127.0.0.1", "port": "2050"},
+		run("--config", configPath))
+
+	os.Setenv(EnvVarOf("log-file"), "from-env.log")
+	defer os.Unsetenv(EnvVarOf("log-file"))
+	os.Setenv(EnvVarOf(ConfigFlagName), configPath)
+	defer os.Unsetenv(EnvVarOf(ConfigFlagName))
+	assert.Equal(t, map[string]string{"log-level": "DEBUG", "log-file": "from-env.log", "host": "127.0.0.1", "port": "2051"},
+		run("--log-level", "DEBUG", "--port", "2051"))
+}
+
+func TestDeclareConfigValidatesEnvVars(t *testing.T) {
+	run := func() error {
+		command := cli.Command{
+			Name: "serve",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "log-level", Value: "ERROR"},
+				cli.StringFlag{Name: "clones-path"},
+				cli.BoolFlag{Name: "fetch-on-miss"},
+				cli.IntFlag{Name: "port", Value: 2049},
+			},
+			Action: func(ctx *cli.Context) error {
+				return nil
+			},
+		}
+		DeclareConfig(&command, "nfs")
+		app := &cli.App{Name: "test", Commands: []cli.Command{command}}
+		return app.Run([]string{"test", "serve"})
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, valid := range [][]string{
+		{"log-level", "INFO"},
+		{"clones-path", dir},
+		{"fetch-on-miss", "true"},
+		{"port", "2050"},
+	} {
+		os.Setenv(EnvVarOf(valid[0]), valid[1])
+		assert.Nil(t, run(), "%v=%v", valid[0], valid[1])
+		os.Unsetenv(EnvVarOf(valid[0]))
+	}
+	for _, invalid := range [][]string{
+		{"log-level", "LOUD"},
+		{"clones-path", path.Join(dir, "missing")},
+		{"fetch-on-miss", "sometimes"},
+		{"port", "70000"},
+	} {
+		os.Setenv(EnvVarOf(invalid[0]), invalid[1])
+		assert.NotNil(t, run(), "%v=%v", invalid[0], invalid[1])
+		os.Unsetenv(EnvVarOf(invalid[0]))
+	}
+}
@@ -1,6 +1,112 @@
 package common
 
+import (
+	"github.com/urfave/cli"
+	"time"
+)
+
 type Options interface {
 	LogFile() string
 	LogLevel() string
 }
+
+// SharedOptions are the options of the SharedFlags
+type SharedOptions struct {
+	logFile       string
+	logLevel      string
+	ClonesPath    string
+	MaxFileSizeMB int64
+	GitBackend    string
+
+	CloneURLTemplate    string
+	MaxConcurrentClones int
+	CloneTimeout        time.Duration
+	CloneNoWait         bool
+
+	FetchOnMiss      bool
+	FetchMinInterval time.Duration
+	FetchTimeout     time.Duration
+
+	FetchMissingBlobs bool
+
+	MaxConcurrentGitWork           int
+	MaxConcurrentRepositoryGitWork int
+
+	BlobCacheSizeMB    int64
+	ReadAheadMaxBlobKB int64
+
+	GitKeepDescriptors    bool
+	GitMaxOpenDescriptors int
+	GitObjectCacheMB      int64
+	MaxOpenPackFiles      int
+
+	ControlAddress   string
+	ControlTokenFile string
+}
+
+var _ Options = &SharedOptions{}
+
+func ParseSharedOptions(ctx *cli.Context) *SharedOptions {
+	return &SharedOptions{
+		logFile:       ctx.String("log-file"),
+		logLevel:      ctx.String("log-level"),
+		ClonesPath:    ctx.String("clones-path"),
+		MaxFileSizeMB: int64(ctx.Int("max-file-size-mb")),
+		GitBackend:    ctx.String("git-backend"),
+
+		CloneURLTemplate:    ctx.String("clone-url-template"),
+		MaxConcurrentClones: ctx.Int("max-concurrent-clones"),
+		CloneTimeout:        ctx.Duration("clone-timeout"),
+		CloneNoWait:         ctx.Bool("clone-no-wait"),
+
+		FetchOnMiss:      ctx.Bool("fetch-on-miss"),
+		FetchMinInterval: ctx.Duration("fetch-min-interval"),
+		FetchTimeout:     ctx.Duration("fetch-timeout"),
+
+		FetchMissingBlobs: ctx.Bool("fetch-missing-blobs"),
+
+		MaxConcurrentGitWork:           ctx.Int("max-concurrent-git-work"),
+		MaxConcurrentRepositoryGitWork: ctx.Int("max-concurrent-repository-git-work"),
+
+		BlobCacheSizeMB:    int64(ctx.Int("blob-cache-size-mb")),
+		ReadAheadMaxBlobKB: int64(ctx.Int("read-ahead-max-blob-kb")),
+
+		GitKeepDescriptors:    ctx.Bool("git-keep-descriptors"),
+		GitMaxOpenDescriptors: ctx.Int("git-max-open-descriptors"),
+		GitObjectCacheMB:      int64(ctx.Int("git-object-cache-mb")),
+		MaxOpenPackFiles:      ctx.Int("max-open-pack-files"),
+
+		ControlAddress:   ctx.String("control-address"),
+		ControlTokenFile: ctx.String("control-token-file"),
+	}
+}
+
+// NewSharedOptions returns the options of the given clones path, with the other shared options at their defaults
+func NewSharedOptions(clonesPath string, logLevel string) *SharedOptions {
+	return &SharedOptions{
+		logFile:       DefaultLogFile,
+		logLevel:      logLevel,
+		ClonesPath:    clonesPath,
+		MaxFileSizeMB: DefaultMaxFileSizeMB,
+		GitBackend:    DefaultGitBackend,
+
+		MaxConcurrentClones: DefaultMaxConcurrentClones,
+		CloneTimeout:        DefaultCloneTimeout,
+
+		FetchMinInterval: DefaultFetchMinInterval,
+		FetchTimeout:     DefaultFetchTimeout,
+
+		MaxConcurrentGitWork:           DefaultMaxConcurrentGitWork,
+		MaxConcurrentRepositoryGitWork: DefaultMaxConcurrentRepositoryGitWork,
+
+		GitObjectCacheMB: DefaultGitObjectCacheMB,
+	}
+}
+
+func (opts *SharedOptions) LogFile() string {
+	return opts.logFile
+}
+
+func (opts *SharedOptions) LogLevel() string {
+	return opts.logLevel
+}
@@ -0,0 +1,88 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	GoGitBackendName   = "go-git"
+	CatFileBackendName = "cat-file"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrObjectNotFound   = errors.New("object not found")
+
+	backendName  = GoGitBackendName
+	backendMutex = &sync.RWMutex{}
+)
+
+// Backend reads the objects of a repository
+type Backend interface {
+	// ResolveRevision returns the sha of the commit a revision points to, or ErrRevisionNotFound
+	ResolveRevision(revision string) (sha string, err error)
+	// ReadCommit reads the parents and the time of a commit by its sha
+	ReadCommit(sha string) (commit *CommitInfo, err error)
+	// ReadTree lists the entries of a tree by its sha, with their names as paths and sizes for blobs,
+	// marking blobs missing from a partial clone rather than fetching them
+	ReadTree(treeSha string) (entries []TreeFile, err error)
+	// StatObject describes an object by its sha, or by <commit sha>:<path> for a file of a commit
+	StatObject(object string) (info *ObjectInfo, err error)
+	// OpenBlob reads the contents of a blob by its sha
+	OpenBlob(sha string) (reader io.ReadCloser, err error)
+	// Refresh picks up objects added to the repository since it was opened
+	Refresh() error
+	Close() error
+}
+
+// TreeFile is an entry of a tree
+type TreeFile struct {
+	Path string
+	Sha  string
+	Mode filemode.FileMode
+	Size int64
+	// Missing marks a blob missing from a partial clone, whose size isn't known until it's read
+	Missing bool
+}
+
+// CommitInfo is what the history of a commit needs of it, the time being its committer's
+type CommitInfo struct {
+	Sha     string
+	Tree    string
+	Parents []string
+	Time    time.Time
+}
+
+type ObjectInfo struct {
+	Sha  string
+	Type string
+	Size int64
+}
+
+// SetBackend chooses the backend of the providers opened from then on, by its name
+func SetBackend(name string) error {
+	switch name {
+	case GoGitBackendName, CatFileBackendName:
+	default:
+		return fmt.Errorf("unknown git backend '%v', expected %v or %v", name, GoGitBackendName, CatFileBackendName)
+	}
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+	backendName = name
+	return nil
+}
+
+func openBackend(clonePath string) (Backend, error) {
+	backendMutex.RLock()
+	name := backendName
+	backendMutex.RUnlock()
+	if name == CatFileBackendName {
+		return NewCatFileBackend(clonePath)
+	}
+	return NewGoGitBackend(clonePath)
+}
@@ -0,0 +1,242 @@
+package git_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// backendTestSuite runs the same tests over each backend of a local clone
+type backendTestSuite struct {
+	suite.Suite
+	newBackend func(clonePath string) (git.Backend, error)
+	clonesPath string
+	commits    []string
+	backend    git.Backend
+	provider   *git.RepositoryProvider
+}
+
+func TestGoGitBackend(t *testing.T) {
+	logger.InitLoggers("logs/backend_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, &backendTestSuite{newBackend: git.NewGoGitBackend})
+}
+
+func TestCatFileBackend(t *testing.T) {
+	logger.InitLoggers("logs/backend_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, &backendTestSuite{newBackend: git.NewCatFileBackend})
+}
+
+// find returns the entry at a path of a tree, or nil if there's none
+func find(root *git.Entry, entryPath string) *git.Entry {
+	entry, _ := root.Find(entryPath)
+	return entry
+}
+
+func (backendSuite *backendTestSuite) SetupTest() {
+	backendSuite.clonesPath, backendSuite.commits = testutils.SetupLocalClones()
+	var err error
+	backendSuite.backend, err = backendSuite.newBackend(path.Join(backendSuite.clonesPath, testutils.LOCAL_REPO_NAME))
+	if err != nil {
+		panic(err)
+	}
+	backendSuite.provider = git.NewBackendProvider(backendSuite.backend)
+}
+
+func (backendSuite *backendTestSuite) TearDownTest() {
+	backendSuite.provider.Close()
+	git.SetMaxFileSizeMB(common.DefaultMaxFileSizeMB)
+	os.RemoveAll(backendSuite.clonesPath)
+}
+
+func (backendSuite *backendTestSuite) TestResolveRevision() {
+	for revision, expected := range map[string]string{
+		"v1":                        backendSuite.commits[0],
+		"master":                    backendSuite.commits[1],
+		backendSuite.commits[0]:     backendSuite.commits[0],
+		backendSuite.commits[1][:7]: backendSuite.commits[1],
+		"refs/heads/master":         backendSuite.commits[1],
+	} {
+		sha, err := backendSuite.backend.ResolveRevision(revision)
+		backendSuite.Nil(err, revision)
+		backendSuite.Equal(expected, sha, revision)
+	}
+
+	for _, revision := range []string{"wat", "0000000", "v1\nmaster"} {
+		_, err := backendSuite.backend.ResolveRevision(revision)
+		backendSuite.True(errors.Is(err, git.ErrRevisionNotFound), "resolve %q: %v", revision, err)
+		canResolve, err := backendSuite.provider.CanResolve(revision)
+		backendSuite.Nil(err)
+		backendSuite.False(canResolve)
+	}
+}
+
+func (backendSuite *backendTestSuite) TestListTree() {
+	first, err := backendSuite.provider.ListTree(backendSuite.commits[0])
+	backendSuite.Nil(err)
+	backendSuite.Equal(7, first.Count())
+	for filePath, contents := range testutils.LocalFiles {
+		backendSuite.False(find(first, filePath).IsDir(), filePath)
+		backendSuite.EqualValues(len(contents), find(first, filePath).Size(), filePath)
+	}
+
+	root, err := backendSuite.provider.ListTree("master")
+	backendSuite.Nil(err)
+	backendSuite.Equal(9, root.Count())
+	backendSuite.True(find(root, "docs").IsDir())
+	backendSuite.EqualValues(len(testutils.LocalSecondCommitFiles["docs/guide.md"]), find(root, "docs/guide.md").Size())
+
+	_, err = backendSuite.provider.ListTree("wat")
+	backendSuite.NotNil(err)
+}
+
+func (backendSuite *backendTestSuite) TestReadTree() {
+	commit, err := backendSuite.backend.ReadCommit(backendSuite.commits[0])
+	backendSuite.Nil(err)
+	entries, err := backendSuite.backend.ReadTree(commit.Tree)
+	backendSuite.Nil(err)
+	backendSuite.Len(entries, 2)
+	backendSuite.Equal("README.md", entries[0].Path)
+	backendSuite.EqualValues(len(testutils.LocalFiles["README.md"]), entries[0].Size)
+	backendSuite.Equal("src", entries[1].Path)
+	backendSuite.Equal(filemode.Dir, entries[1].Mode)
+
+	info, err := backendSuite.backend.StatObject(backendSuite.commits[0] + ":src")
+	backendSuite.Nil(err)
+	backendSuite.Equal("tree", info.Type)
+	backendSuite.Equal(entries[1].Sha, info.Sha)
+
+	_, err = backendSuite.backend.ReadTree(backendSuite.commits[0])
+	backendSuite.NotNil(err)
+}
+
+func (backendSuite *backendTestSuite) TestSharedSubtrees() {
+	first, err := backendSuite.provider.ListTree("v1")
+	backendSuite.Nil(err)
+	second, err := backendSuite.provider.ListTree("master")
+	backendSuite.Nil(err)
+	// src is the same tree in both commits, so its entries are built once
+	backendSuite.Same(find(first, "src"), find(second, "src"))
+	backendSuite.Same(find(first, "src/pkg/util.go"), find(second, "src/pkg/util.go"))
+	backendSuite.Nil(find(first, "docs"))
+
+	subtree, err := backendSuite.provider.ListTree("master:src")
+	backendSuite.Nil(err)
+	backendSuite.Same(find(first, "src/pkg"), find(subtree, "pkg"))
+}
+
+func (backendSuite *backendTestSuite) TestFileContents() {
+	for filePath, expected := range testutils.LocalFiles {
+		contents, err := backendSuite.provider.FileContents("v1", filePath)
+		backendSuite.Nil(err, filePath)
+		backendSuite.Equal(expected, contents, filePath)
+	}
+
+	for _, filePath := range []string{"docs/guide.md", "src", "wat"} {
+		_, err := backendSuite.provider.FileContents("v1", filePath)
+		backendSuite.NotNil(err, filePath)
+	}
+
+	git.SetMaxFileSizeMB(0)
+	_, err := backendSuite.provider.FileContents("v1", "README.md")
+	backendSuite.True(errors.Is(err, git.ErrFileTooLarge))
+}
+
+func (backendSuite *backendTestSuite) TestObjects() {
+	info, err := backendSuite.backend.StatObject(backendSuite.commits[1])
+	backendSuite.Nil(err)
+	backendSuite.Equal("commit", info.Type)
+
+	info, err = backendSuite.backend.StatObject(backendSuite.commits[0] + ":src/main.go")
+	backendSuite.Nil(err)
+	backendSuite.Equal("blob", info.Type)
+	backendSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size)
+
+	reader, err := backendSuite.backend.OpenBlob(info.Sha)
+	backendSuite.Nil(err)
+	contents, err := ioutil.ReadAll(reader)
+	backendSuite.Nil(err)
+	backendSuite.Nil(reader.Close())
+	backendSuite.Equal(testutils.LocalFiles["src/main.go"], string(contents))
+
+	for _, object := range []string{backendSuite.commits[0] + ":wat", "0000000000000000000000000000000000000000"} {
+		_, err = backendSuite.backend.StatObject(object)
+		backendSuite.True(errors.Is(err, git.ErrObjectNotFound), "stat %v: %v", object, err)
+	}
+	_, err = backendSuite.backend.OpenBlob("0000000000000000000000000000000000000000")
+	backendSuite.True(errors.Is(err, git.ErrObjectNotFound))
+}
+
+func TestMemoryBackend(t *testing.T) {
+	backend := git.NewMemoryBackend()
+	first := backend.Commit(testutils.LocalFiles, "v1")
+	second := backend.Commit(map[string]string{"README.md": "# local\n", "docs/guide.md": "guide\n"}, "master")
+	assert.NotEqual(t, first, second)
+	provider := git.NewBackendProvider(backend)
+
+	sha, err := provider.ResolveCommit("v1")
+	assert.Nil(t, err)
+	assert.Equal(t, first, sha)
+	sha, err = provider.ResolveCommit(second[:git.ShortShaLength])
+	assert.Nil(t, err)
+	assert.Equal(t, second, sha)
+	canResolve, err := provider.CanResolve("wat")
+	assert.Nil(t, err)
+	assert.False(t, canResolve)
+
+	root, err := provider.ListTree("v1")
+	assert.Nil(t, err)
+	assert.Equal(t, 7, root.Count())
+	for filePath, expected := range testutils.LocalFiles {
+		contents, err := provider.FileContents("v1", filePath)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, contents, filePath)
+	}
+	_, err = provider.FileContents("master", "src/main.go")
+	assert.True(t, errors.Is(err, git.ErrObjectNotFound))
+
+	// blobs and trees have their git shas
+	info, err := backend.StatObject(first + ":README.md")
+	assert.Nil(t, err)
+	assert.Equal(t, "5804c29a261afc38dac6dd306a4c5d22020bc0af", info.Sha)
+	clonesPath, commits := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	commit, err := backend.ReadCommit(first)
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.RevParse(path.Join(clonesPath, testutils.LOCAL_REPO_NAME), commits[0]+"^{tree}"), commit.Tree)
+	info, err = backend.StatObject(first + ":src/pkg")
+	assert.Nil(t, err)
+	assert.Equal(t, "tree", info.Type)
+	assert.Equal(t, testutils.RevParse(path.Join(clonesPath, testutils.LOCAL_REPO_NAME), commits[0]+":src/pkg"), info.Sha)
+
+	backend.SetRef("master", first)
+	sha, err = provider.ResolveCommit("master")
+	assert.Nil(t, err)
+	assert.Equal(t, first, sha)
+}
+
+func TestSetBackend(t *testing.T) {
+	defer git.SetBackend(git.GoGitBackendName)
+	assert.NotNil(t, git.SetBackend("wat"))
+
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	assert.Nil(t, git.SetBackend(git.CatFileBackendName))
+	provider, err := git.NewRepositoryProvider(path.Join(clonesPath, testutils.LOCAL_REPO_NAME))
+	assert.Nil(t, err)
+	defer provider.Close()
+	assert.Equal(t, "*git.catFileBackend", fmt.Sprintf("%T", provider.Backend()))
+
+	provider, err = git.NewRepositoryProvider(path.Join(clonesPath, "wat"))
+	assert.Nil(t, err)
+	assert.Nil(t, provider)
+}
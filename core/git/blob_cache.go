@@ -0,0 +1,344 @@
+package git
+
+import (
+	"errors"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"gitreefs/core/logger"
+	"math"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// the stats of the blob cache are logged at this interval, if they changed since last logged
+	blobCacheStatsInterval = time.Minute
+	// readAheadWorkers read directories ahead across all repositories, with up to readAheadQueueSize directories
+	// waiting for them, beyond which listed directories aren't read ahead
+	readAheadWorkers   = 4
+	readAheadQueueSize = 1024
+	// maxReadAheadDirs bounds the directories each repository remembers as read ahead, forgetting the least recent first
+	maxReadAheadDirs = 16 * 1024
+)
+
+var (
+	blobs          *blobCache
+	readAhead      *ReadAhead
+	blobCacheMutex = &sync.RWMutex{}
+
+	readAheadQueue       = make(chan *readAheadDir, readAheadQueueSize)
+	readAheadWorkersOnce = &sync.Once{}
+)
+
+// readAheadDir is a directory of a commitish waiting to be read ahead
+type readAheadDir struct {
+	provider  *RepositoryProvider
+	commitish string
+	dirPath   string
+}
+
+// BlobCache keeps the contents of recently read files in memory, up to a total size
+type BlobCache struct {
+	MaxSizeBytes int64
+}
+
+// ReadAhead reads the small files of a directory into the blob cache in the background once it's listed
+// or any of its files is read, so reading its other files doesn't wait for decompressing them
+type ReadAhead struct {
+	// MaxBlobSizeBytes is the size of the largest file read ahead
+	MaxBlobSizeBytes int64
+}
+
+// BlobCacheStats counts the reads served by the blob cache, and how many of the blobs read ahead were read afterwards
+type BlobCacheStats struct {
+	Hits   int64
+	Misses int64
+	// ReadAhead is the number of blobs read ahead or prefetched, and ReadAheadHits the number of them which were read since
+	ReadAhead     int64
+	ReadAheadHits int64
+	Evicted       int64
+}
+
+// HitRatio is the part of the reads served by the cache
+func (stats BlobCacheStats) HitRatio() float64 {
+	return ratio(stats.Hits, stats.Hits+stats.Misses)
+}
+
+// ReadAheadHitRatio is the part of the blobs read ahead which were read since
+func (stats BlobCacheStats) ReadAheadHitRatio() float64 {
+	return ratio(stats.ReadAheadHits, stats.ReadAhead)
+}
+
+func ratio(part int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+type blobCache struct {
+	maxSize int64
+	size    int64
+	blobs   *simplelru.LRU
+	stats   BlobCacheStats
+	logged  BlobCacheStats
+	stop    chan struct{}
+	mutex   *sync.Mutex
+}
+
+type cachedBlob struct {
+	contents string
+	// readAhead is set for a blob read ahead and not read since
+	readAhead bool
+}
+
+// SetBlobCache enables caching read file contents by the given config, or disables it along with read-ahead for a nil config
+func SetBlobCache(config *BlobCache) (err error) {
+	var cache *blobCache
+	if config != nil {
+		cache, err = newBlobCache(config.MaxSizeBytes)
+		if err != nil {
+			return
+		}
+	}
+	blobCacheMutex.Lock()
+	defer blobCacheMutex.Unlock()
+	if blobs != nil {
+		close(blobs.stop)
+	}
+	blobs = cache
+	if cache == nil {
+		readAhead = nil
+		return nil
+	}
+	go cache.logStats()
+	return nil
+}
+
+// SetReadAhead enables reading directories ahead by the given config, or disables it for a nil config.
+// Blobs are read ahead into the blob cache, which must be enabled first.
+func SetReadAhead(config *ReadAhead) error {
+	blobCacheMutex.Lock()
+	defer blobCacheMutex.Unlock()
+	if config != nil && blobs == nil {
+		return errors.New("read-ahead requires the blob cache")
+	}
+	readAhead = config
+	return nil
+}
+
+// CurrentBlobCacheStats returns the stats of the blob cache, which are all zero if it's disabled
+func CurrentBlobCacheStats() BlobCacheStats {
+	cache, _ := blobCacheConfig()
+	if cache == nil {
+		return BlobCacheStats{}
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.stats
+}
+
+func blobCacheConfig() (*blobCache, *ReadAhead) {
+	blobCacheMutex.RLock()
+	defer blobCacheMutex.RUnlock()
+	return blobs, readAhead
+}
+
+func newBlobCache(maxSize int64) (cache *blobCache, err error) {
+	if maxSize <= 0 {
+		return nil, errors.New("blob cache size must be positive")
+	}
+	cache = &blobCache{
+		maxSize: maxSize,
+		stop:    make(chan struct{}),
+		mutex:   &sync.Mutex{},
+	}
+	// bounded by the size of the blobs rather than by their number
+	cache.blobs, err = simplelru.NewLRU(math.MaxInt32, func(_ interface{}, value interface{}) {
+		cache.size -= int64(len(value.(*cachedBlob).contents))
+		cache.stats.Evicted++
+	})
+	return
+}
+
+// get returns the contents of a blob if cached, counting the read as a hit or a miss
+func (cache *blobCache) get(sha string) (contents string, found bool) {
+	if cache == nil {
+		return "", false
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	wrapped, found := cache.blobs.Get(sha)
+	if !found {
+		cache.stats.Misses++
+		return "", false
+	}
+	cache.stats.Hits++
+	blob := wrapped.(*cachedBlob)
+	if blob.readAhead {
+		blob.readAhead = false
+		cache.stats.ReadAheadHits++
+	}
+	return blob.contents, true
+}
+
+func (cache *blobCache) contains(sha string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.blobs.Contains(sha)
+}
+
+// add caches the contents of a blob, evicting the least recently read blobs to fit it
+func (cache *blobCache) add(sha string, contents string, isReadAhead bool) {
+	if cache == nil || int64(len(contents)) > cache.maxSize {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.blobs.Contains(sha) {
+		return
+	}
+	cache.blobs.Add(sha, &cachedBlob{contents: contents, readAhead: isReadAhead})
+	cache.size += int64(len(contents))
+	if isReadAhead {
+		cache.stats.ReadAhead++
+	}
+	for cache.size > cache.maxSize {
+		cache.blobs.RemoveOldest()
+	}
+}
+
+func (cache *blobCache) logStats() {
+	ticker := time.NewTicker(blobCacheStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.stop:
+			return
+		case <-ticker.C:
+		}
+		cache.mutex.Lock()
+		stats := cache.stats
+		changed := stats != cache.logged
+		cache.logged = stats
+		cache.mutex.Unlock()
+		if changed {
+			logger.Info("blob cache: %v hits, %v misses (%.2f hit ratio), %v of %v blobs read ahead were read (%.2f), %v evicted",
+				stats.Hits, stats.Misses, stats.HitRatio(),
+				stats.ReadAheadHits, stats.ReadAhead, stats.ReadAheadHitRatio(), stats.Evicted)
+		}
+	}
+}
+
+// ReadAhead reads the small files of a directory of a commitish into the blob cache in the background,
+// once per directory unless read-ahead is disabled. Directories listed while the read-ahead workers are behind
+// are dropped, and read ahead only if they're listed again.
+func (provider *RepositoryProvider) ReadAhead(commitish string, dirPath string) {
+	_, config := blobCacheConfig()
+	if config == nil {
+		return
+	}
+	key := commitish + ":" + dirPath
+	dir := &readAheadDir{provider: provider, commitish: commitish, dirPath: dirPath}
+	if provider.readAheadDirs.intern(key, dir) != dir {
+		return
+	}
+	readAheadWorkersOnce.Do(startReadAheadWorkers)
+	select {
+	case provider.readAheadQueue <- dir:
+	default:
+		provider.readAheadDirs.remove(key)
+		logger.Debug("ReadAhead for %v :: %v dropped, as %v directories are waiting", commitish, dirPath, cap(provider.readAheadQueue))
+	}
+}
+
+func startReadAheadWorkers() {
+	for i := 0; i < readAheadWorkers; i++ {
+		go func(queue chan *readAheadDir) {
+			for dir := range queue {
+				cache, config := blobCacheConfig()
+				if config == nil {
+					continue
+				}
+				err := dir.provider.readAhead(cache, config, dir.commitish, dir.dirPath)
+				if err != nil {
+					logger.Info("ReadAhead for %v :: %v failed: %v", dir.commitish, dir.dirPath, err)
+				}
+			}
+		}(readAheadQueue)
+	}
+}
+
+// readAhead reads the blobs of a directory with the prefetch priority one by one, so lookups aren't kept waiting.
+// Blobs missing from a partial clone aren't read ahead, as that would fetch them before they're ever read.
+func (provider *RepositoryProvider) readAhead(cache *blobCache, config *ReadAhead, commitish string, dirPath string) (err error) {
+	var sha, treePath, treeSha string
+	sha, treePath, err = provider.resolveTree(commitish)
+	if err != nil {
+		return
+	}
+	treeSha, err = provider.treeSha(sha, path.Join(treePath, dirPath))
+	if err != nil {
+		return
+	}
+	var entries []TreeFile
+	provider.work(PrefetchPriority, func() {
+		entries, err = provider.backend.ReadTree(treeSha)
+	})
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		mode := entry.Mode
+		if !mode.IsFile() || mode.IsMalformed() || !mode.IsRegular() || entry.Missing ||
+			entry.Size > config.MaxBlobSizeBytes || cache.contains(entry.Sha) {
+			continue
+		}
+		var contents string
+		provider.work(PrefetchPriority, func() {
+			contents, err = provider.readBlob(entry.Sha)
+		})
+		if errors.Is(err, ErrBlobMissing) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		cache.add(entry.Sha, contents, true)
+	}
+	return nil
+}
+
+// PrefetchFile reads a file of a commitish into the blob cache with the prefetch priority, returning its size.
+// Files already cached or too large to load aren't read, and neither are any when the blob cache is disabled.
+func (provider *RepositoryProvider) PrefetchFile(commitish string, filePath string) (size int64, err error) {
+	cache, _ := blobCacheConfig()
+	if cache == nil {
+		return
+	}
+	var sha, treePath string
+	sha, treePath, err = provider.resolveTree(commitish)
+	if err != nil {
+		return
+	}
+	var info *ObjectInfo
+	info, err = provider.backend.StatObject(sha + ":" + path.Join(treePath, filePath))
+	if err != nil || info.Size >= MaxFileSizeBytes || cache.contains(info.Sha) {
+		return
+	}
+	var contents string
+	provider.work(PrefetchPriority, func() {
+		contents, err = provider.readBlob(info.Sha)
+	})
+	if err != nil {
+		return
+	}
+	cache.add(info.Sha, contents, true)
+	return info.Size, nil
+}
+
+// BlobCacheEnabled tells whether file contents are cached, and so whether files can be prefetched
+func BlobCacheEnabled() bool {
+	cache, _ := blobCacheConfig()
+	return cache != nil
+}
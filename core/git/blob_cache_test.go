@@ -0,0 +1,94 @@
+package git_test
+
+import (
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"testing"
+	"time"
+)
+
+type blobCacheTestSuite struct {
+	suite.Suite
+	provider *git.RepositoryProvider
+}
+
+func TestBlobCacheTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/blob_cache_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(blobCacheTestSuite))
+}
+
+func (cacheSuite *blobCacheTestSuite) SetupTest() {
+	backend := git.NewMemoryBackend()
+	backend.Commit(map[string]string{
+		"a.txt":         "12345",
+		"b.txt":         "67890",
+		"c.txt":         "abcde",
+		"dir/small.txt": "small",
+		"dir/other.txt": "other",
+		"dir/large.txt": "0123456789abcdef",
+	}, "master")
+	cacheSuite.provider = git.NewBackendProvider(backend)
+}
+
+func (cacheSuite *blobCacheTestSuite) TearDownTest() {
+	git.SetBlobCache(nil)
+}
+
+func (cacheSuite *blobCacheTestSuite) read(filePath string) {
+	_, err := cacheSuite.provider.FileContents("master", filePath)
+	cacheSuite.Nil(err, filePath)
+}
+
+// waitForReadAhead waits until a number of blobs were read ahead
+func (cacheSuite *blobCacheTestSuite) waitForReadAhead(count int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for git.CurrentBlobCacheStats().ReadAhead < count && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cacheSuite.Equal(count, git.CurrentBlobCacheStats().ReadAhead)
+}
+
+func (cacheSuite *blobCacheTestSuite) TestEviction() {
+	cacheSuite.Nil(git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 10}))
+	cacheSuite.read("a.txt")
+	cacheSuite.read("a.txt")
+	cacheSuite.read("b.txt")
+	cacheSuite.read("c.txt")
+	cacheSuite.read("a.txt")
+
+	contents, err := cacheSuite.provider.FileContents("master", "a.txt")
+	cacheSuite.Nil(err)
+	cacheSuite.Equal("12345", contents)
+	stats := git.CurrentBlobCacheStats()
+	cacheSuite.EqualValues(2, stats.Hits)
+	cacheSuite.EqualValues(4, stats.Misses)
+	cacheSuite.EqualValues(2, stats.Evicted)
+	cacheSuite.InDelta(1.0/3, stats.HitRatio(), 0.001)
+}
+
+func (cacheSuite *blobCacheTestSuite) TestReadAhead() {
+	cacheSuite.Nil(git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 1024}))
+	cacheSuite.Nil(git.SetReadAhead(&git.ReadAhead{MaxBlobSizeBytes: 10}))
+
+	cacheSuite.provider.ReadAhead("master", "dir")
+	cacheSuite.waitForReadAhead(2)
+	// a directory is read ahead once
+	cacheSuite.provider.ReadAhead("master", "dir")
+	cacheSuite.read("dir/small.txt")
+	cacheSuite.read("dir/small.txt")
+	cacheSuite.read("dir/large.txt")
+
+	stats := git.CurrentBlobCacheStats()
+	cacheSuite.EqualValues(2, stats.ReadAhead)
+	cacheSuite.EqualValues(1, stats.ReadAheadHits)
+	cacheSuite.Equal(0.5, stats.ReadAheadHitRatio())
+	cacheSuite.EqualValues(2, stats.Hits)
+	cacheSuite.EqualValues(1, stats.Misses)
+}
+
+func (cacheSuite *blobCacheTestSuite) TestReadAheadRequiresCache() {
+	cacheSuite.NotNil(git.SetReadAhead(&git.ReadAhead{MaxBlobSizeBytes: 10}))
+	cacheSuite.NotNil(git.SetBlobCache(&git.BlobCache{}))
+	cacheSuite.Equal(git.BlobCacheStats{}, git.CurrentBlobCacheStats())
+}
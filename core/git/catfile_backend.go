@@ -0,0 +1,362 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"gitreefs/core/logger"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catFileBackend reads the objects of a clone through long lived `git cat-file --batch` and `--batch-check`
+// processes, which are restarted on failure. Trees are read as objects, and the sizes of their blobs checked one by one.
+// In partial clones cat-file runs without fetching missing objects lazily, and they're fetched by the backend
+// only if fetching is enabled, within its timeout, as the go-git backend does.
+type catFileBackend struct {
+	clonePath  string
+	contents   *catFileProcess
+	check      *catFileProcess
+	info       *CloneInfo
+	fetchMutex *sync.Mutex
+}
+
+var _ Backend = &catFileBackend{}
+
+// catFileProcess serves one request at a time
+type catFileProcess struct {
+	clonePath string
+	mode      string
+	// noLazyFetch processes exit on objects missing from a partial clone rather than fetching them
+	noLazyFetch bool
+	command     *exec.Cmd
+	stdin       io.WriteCloser
+	stdout      *bufio.Reader
+	mutex       *sync.Mutex
+}
+
+func NewCatFileBackend(clonePath string) (backend Backend, err error) {
+	_, err = exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("the %v backend requires git: %w", CatFileBackendName, err)
+	}
+	var info *CloneInfo
+	info, err = DetectClone(clonePath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("NewCatFileBackend for %v", clonePath)
+	return &catFileBackend{
+		clonePath:  clonePath,
+		contents:   newCatFileProcess(clonePath, "--batch", info.IsPartial()),
+		check:      newCatFileProcess(clonePath, "--batch-check", info.IsPartial()),
+		info:       info,
+		fetchMutex: &sync.Mutex{},
+	}, nil
+}
+
+func newCatFileProcess(clonePath string, mode string, noLazyFetch bool) *catFileProcess {
+	return &catFileProcess{
+		clonePath:   clonePath,
+		mode:        mode,
+		noLazyFetch: noLazyFetch,
+		mutex:       &sync.Mutex{},
+	}
+}
+
+func (process *catFileProcess) startIfNeeded() (err error) {
+	if process.command != nil {
+		return
+	}
+	command := exec.Command("git", "-C", process.clonePath, "cat-file", process.mode)
+	if process.noLazyFetch {
+		command.Env = append(os.Environ(), "GIT_NO_LAZY_FETCH=1")
+	}
+	process.stdin, err = command.StdinPipe()
+	if err != nil {
+		return
+	}
+	var stdout io.ReadCloser
+	stdout, err = command.StdoutPipe()
+	if err != nil {
+		return
+	}
+	err = command.Start()
+	if err != nil {
+		return
+	}
+	process.command = command
+	process.stdout = bufio.NewReader(stdout)
+	logger.Debug("catFileProcess.startIfNeeded: started cat-file %v for %v", process.mode, process.clonePath)
+	return
+}
+
+func (process *catFileProcess) stop() error {
+	if process.command == nil {
+		return nil
+	}
+	process.stdin.Close()
+	err := process.command.Wait()
+	process.command = nil
+	return err
+}
+
+// request writes an object name and reads the header of its response, followed by the contents in --batch mode
+func (process *catFileProcess) request(object string) (info *ObjectInfo, contents []byte, err error) {
+	if strings.ContainsAny(object, "\n") {
+		return nil, nil, fmt.Errorf("%w: %q", ErrObjectNotFound, object)
+	}
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+	err = process.startIfNeeded()
+	if err != nil {
+		return
+	}
+	info, contents, err = process.exchange(object)
+	if err != nil && info == nil {
+		// the process is out of sync or dead, so it's restarted by the next request
+		process.stop()
+		if process.noLazyFetch && err == io.EOF {
+			// cat-file exits on an object it would otherwise have fetched from the promisor remote
+			return nil, nil, fmt.Errorf("%w: %v of %v", ErrBlobMissing, object, process.clonePath)
+		}
+		logger.Error("catFileProcess.request: cat-file %v of %v failed on %v: %v", process.mode, process.clonePath, object, err)
+	}
+	return
+}
+
+// requestFetching requests an object, fetching it first if it's missing from a partial clone and fetching is enabled
+func (backend *catFileBackend) requestFetching(process *catFileProcess, object string) (info *ObjectInfo, contents []byte, err error) {
+	info, contents, err = process.request(object)
+	if !errors.Is(err, ErrBlobMissing) {
+		return
+	}
+	config := fetchMissingBlobsConfig()
+	if config == nil {
+		return
+	}
+	// objects named by a path are fetched by the sha of their blob, which is resolved through trees the clone has
+	sha := object
+	if strings.Contains(object, ":") {
+		var output []byte
+		output, err = exec.Command("git", "-C", backend.clonePath, "rev-parse", "--verify", "--quiet", object).Output()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrObjectNotFound, object)
+		}
+		sha = strings.TrimSpace(string(output))
+	}
+
+	backend.fetchMutex.Lock()
+	// blobs fetched while waiting for another fetch aren't fetched again
+	info, contents, err = process.request(object)
+	if errors.Is(err, ErrBlobMissing) {
+		err = fetchBlobs(config, backend.clonePath, backend.info.PromisorRemote, []string{sha})
+		if err == nil {
+			info, contents, err = process.request(object)
+		}
+	}
+	backend.fetchMutex.Unlock()
+	return
+}
+
+func (process *catFileProcess) exchange(object string) (info *ObjectInfo, contents []byte, err error) {
+	_, err = io.WriteString(process.stdin, object+"\n")
+	if err != nil {
+		return
+	}
+	var header string
+	header, err = process.stdout.ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && (fields[1] == "missing" || fields[1] == "ambiguous") {
+		return &ObjectInfo{}, nil, fmt.Errorf("%w: %v is %v", ErrObjectNotFound, object, fields[1])
+	}
+	if len(fields) != 3 {
+		return nil, nil, fmt.Errorf("unexpected cat-file header %q", header)
+	}
+	var size int64
+	size, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+	info = &ObjectInfo{
+		Sha:  fields[0],
+		Type: fields[1],
+		Size: size,
+	}
+	if process.mode != "--batch" {
+		return
+	}
+	// the contents are followed by a line feed
+	contents = make([]byte, size+1)
+	_, err = io.ReadFull(process.stdout, contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, contents[:size], nil
+}
+
+func (backend *catFileBackend) ResolveRevision(revision string) (sha string, err error) {
+	var info *ObjectInfo
+	info, _, err = backend.check.request(revision + "^{commit}")
+	if err != nil && !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, ErrBlobMissing) {
+		return
+	}
+	if err != nil {
+		logger.Info("commitish '%v' could not be resolved: %v", revision, err)
+		return "", fmt.Errorf("%w: %v", ErrRevisionNotFound, revision)
+	}
+	return info.Sha, nil
+}
+
+func (backend *catFileBackend) ReadCommit(sha string) (commit *CommitInfo, err error) {
+	var info *ObjectInfo
+	var contents []byte
+	info, contents, err = backend.contents.request(sha)
+	if err != nil {
+		return
+	}
+	if info.Type != "commit" {
+		return nil, fmt.Errorf("%w: %v is a %v", ErrObjectNotFound, sha, info.Type)
+	}
+	commit = &CommitInfo{
+		Sha: info.Sha,
+	}
+	// the headers end at the first empty line, before the message
+	for _, line := range strings.Split(string(contents), "\n") {
+		if len(line) == 0 {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			// continuation lines of signatures
+			continue
+		}
+		switch fields[0] {
+		case "tree":
+			commit.Tree = fields[1]
+		case "parent":
+			commit.Parents = append(commit.Parents, fields[1])
+		case "committer":
+			// committer <name> <<email>> <unix time> <zone>
+			var seconds int64
+			seconds, err = strconv.ParseInt(fields[len(fields)-2], 10, 64)
+			if err != nil {
+				return
+			}
+			commit.Time = time.Unix(seconds, 0)
+		}
+	}
+	return
+}
+
+// missingBlobs lists the blobs of a tree missing from a partial clone, as cat-file exits on missing objects it's asked about
+func (backend *catFileBackend) missingBlobs(treeSha string) (missing map[string]bool, err error) {
+	command := exec.Command("git", "-C", backend.clonePath,
+		"rev-list", "--objects", "--no-object-names", "--missing=print", "--filter=tree:1", treeSha)
+	var output []byte
+	output, err = command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("rev-list of %v: %w", treeSha, err)
+	}
+	missing = make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "?") {
+			missing[line[1:]] = true
+		}
+	}
+	return
+}
+
+// ReadTree lists a tree with the sizes of its blobs, marking those missing from a partial clone rather than fetching them
+func (backend *catFileBackend) ReadTree(treeSha string) (entries []TreeFile, err error) {
+	var info *ObjectInfo
+	var contents []byte
+	info, contents, err = backend.contents.request(treeSha)
+	if err != nil {
+		return
+	}
+	if info.Type != "tree" {
+		return nil, fmt.Errorf("%w: %v is a %v", ErrObjectNotFound, treeSha, info.Type)
+	}
+	var missing map[string]bool
+	if backend.info.IsPartial() {
+		missing, err = backend.missingBlobs(treeSha)
+		if err != nil {
+			return
+		}
+	}
+	// <mode> SP <name> NUL <20 bytes of sha>, repeated
+	for len(contents) > 0 {
+		space := bytes.IndexByte(contents, ' ')
+		null := bytes.IndexByte(contents, 0)
+		if space < 0 || null < space || len(contents) < null+21 {
+			return nil, fmt.Errorf("unexpected tree %v", treeSha)
+		}
+		var entry TreeFile
+		entry.Mode, err = filemode.New(string(contents[:space]))
+		if err != nil {
+			return
+		}
+		entry.Path = string(contents[space+1 : null])
+		entry.Sha = hex.EncodeToString(contents[null+1 : null+21])
+		contents = contents[null+21:]
+		if entry.Mode.IsFile() && missing[entry.Sha] {
+			entry.Missing = true
+		} else if entry.Mode.IsFile() {
+			var blob *ObjectInfo
+			blob, _, err = backend.check.request(entry.Sha)
+			if err != nil {
+				return
+			}
+			entry.Size = blob.Size
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// StatObject checks an object, fetching it if it's missing from a partial clone and fetching is enabled
+func (backend *catFileBackend) StatObject(object string) (info *ObjectInfo, err error) {
+	info, _, err = backend.requestFetching(backend.check, object)
+	return
+}
+
+// OpenBlob reads a blob, fetching it if it's missing from a partial clone and fetching is enabled
+func (backend *catFileBackend) OpenBlob(sha string) (reader io.ReadCloser, err error) {
+	var info *ObjectInfo
+	var contents []byte
+	info, contents, err = backend.requestFetching(backend.contents, sha)
+	if err != nil {
+		return
+	}
+	if info.Type != "blob" {
+		return nil, fmt.Errorf("%w: %v is a %v", ErrObjectNotFound, sha, info.Type)
+	}
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+// Refresh does nothing, as cat-file looks for new packs whenever an object is missing
+func (backend *catFileBackend) Refresh() error {
+	return nil
+}
+
+func (backend *catFileBackend) Close() error {
+	for _, process := range []*catFileProcess{backend.contents, backend.check} {
+		process.mutex.Lock()
+		process.stop()
+		process.mutex.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	CloneNamePlaceholder = "{name}"
+
+	// failed clones are reported to lookups for a while before being retried, rather than cloned on every lookup
+	cloneRetryInterval = 30 * time.Second
+)
+
+var (
+	// ErrCloning is returned by lookups of a repository being cloned, unless they wait for the clone
+	ErrCloning      = fmt.Errorf("repository is being cloned: %w", syscall.EAGAIN)
+	ErrCloneTimeout = fmt.Errorf("clone timed out: %w", syscall.ETIMEDOUT)
+
+	autoCloner      *cloner
+	autoClonerMutex = &sync.RWMutex{}
+)
+
+// AutoClone clones repositories missing from the clones path on their first lookup,
+// from a remote URL template such as file:///srv/mirrors/{name}.git
+type AutoClone struct {
+	URLTemplate   string
+	MaxConcurrent int
+	Timeout       time.Duration
+	// Wait makes lookups block until the clone is done, rather than fail with ErrCloning
+	Wait bool
+}
+
+type cloner struct {
+	config *AutoClone
+	slots  chan struct{}
+	jobs   map[string]*cloneJob
+	mutex  *sync.Mutex
+	// running counts the started clone jobs, so replacing the cloner waits for them
+	running *sync.WaitGroup
+}
+
+type cloneJob struct {
+	done       chan struct{}
+	err        error
+	finishedAt time.Time
+}
+
+// SetAutoClone enables cloning missing repositories by the given config, or disables it for a nil config.
+// It returns once the clones started by the previous config are done.
+func SetAutoClone(config *AutoClone) error {
+	if config != nil {
+		if !strings.Contains(config.URLTemplate, CloneNamePlaceholder) {
+			return fmt.Errorf("clone url template '%v' doesn't contain %v", config.URLTemplate, CloneNamePlaceholder)
+		}
+		if config.MaxConcurrent < 1 {
+			return fmt.Errorf("at least one concurrent clone is required, got %v", config.MaxConcurrent)
+		}
+	}
+	var replaced *cloner
+	autoClonerMutex.Lock()
+	replaced = autoCloner
+	if config == nil {
+		autoCloner = nil
+	} else {
+		autoCloner = &cloner{
+			config:  config,
+			slots:   make(chan struct{}, config.MaxConcurrent),
+			jobs:    make(map[string]*cloneJob),
+			mutex:   &sync.Mutex{},
+			running: &sync.WaitGroup{},
+		}
+	}
+	autoClonerMutex.Unlock()
+	if replaced != nil {
+		replaced.running.Wait()
+	}
+	return nil
+}
+
+// EnsureClone clones a repository missing from the clones path when auto clone is enabled, otherwise it does nothing.
+// A clone is started once however many lookups ask for it, and a failed clone is retried by lookups once a while passed.
+func EnsureClone(clonesPath string, name string) error {
+	autoClonerMutex.RLock()
+	cloner := autoCloner
+	autoClonerMutex.RUnlock()
+	if cloner == nil {
+		return nil
+	}
+	err := common.ValidateRepositoryName(name)
+	if err != nil {
+		return err
+	}
+	clonePath := filepath.Join(clonesPath, name)
+	if _, err = os.Lstat(clonePath); err == nil {
+		return nil
+	}
+
+	job := cloner.startIfNeeded(clonesPath, name, clonePath)
+	if cloner.config.Wait {
+		<-job.done
+		return job.err
+	}
+	select {
+	case <-job.done:
+		return job.err
+	default:
+		return fmt.Errorf("%w: %v", ErrCloning, name)
+	}
+}
+
+func (cloner *cloner) startIfNeeded(clonesPath string, name string, clonePath string) *cloneJob {
+	cloner.mutex.Lock()
+	defer cloner.mutex.Unlock()
+	job, found := cloner.jobs[clonePath]
+	if found {
+		select {
+		case <-job.done:
+			if time.Since(job.finishedAt) < cloneRetryInterval {
+				return job
+			}
+		default:
+			return job
+		}
+	}
+	job = &cloneJob{
+		done: make(chan struct{}),
+	}
+	cloner.jobs[clonePath] = job
+	cloner.running.Add(1)
+	go func() {
+		defer cloner.running.Done()
+		job.err = cloner.clone(clonesPath, name, clonePath)
+		job.finishedAt = time.Now()
+		if job.err == nil {
+			cloner.mutex.Lock()
+			delete(cloner.jobs, clonePath)
+			cloner.mutex.Unlock()
+		} else {
+			logger.Error("cloner.startIfNeeded: %v", job.err)
+		}
+		close(job.done)
+	}()
+	return job
+}
+
+// clone clones to a hidden directory renamed once done, so partial clones are never looked up.
+// The timeout includes waiting for one of the concurrent clones to be done.
+func (cloner *cloner) clone(clonesPath string, name string, clonePath string) (err error) {
+	url := strings.ReplaceAll(cloner.config.URLTemplate, CloneNamePlaceholder, name)
+	ctx, cancel := context.WithTimeout(context.Background(), cloner.config.Timeout)
+	defer cancel()
+
+	select {
+	case cloner.slots <- struct{}{}:
+		defer func() { <-cloner.slots }()
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v waited %v for other clones", ErrCloneTimeout, name, cloner.config.Timeout)
+	}
+
+	logger.Info("cloner.clone: cloning %v from %v", name, url)
+	start := time.Now()
+	var tempPath string
+	tempPath, err = ioutil.TempDir(clonesPath, "."+name+".clone-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	output, err := exec.CommandContext(ctx, "git", "clone", "--no-checkout", "--quiet", "--", url, tempPath).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: cloning %v from %v took over %v", ErrCloneTimeout, name, url, cloner.config.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clone %v from %v: %v: %v", name, url, err, strings.TrimSpace(string(output)))
+	}
+
+	err = os.Rename(tempPath, clonePath)
+	if err != nil {
+		if _, statErr := os.Lstat(clonePath); statErr == nil {
+			// cloned meanwhile by another process
+			return nil
+		}
+		return
+	}
+	logger.Info("cloner.clone: cloned %v in %v", name, time.Since(start))
+	return nil
+}
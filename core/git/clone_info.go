@@ -0,0 +1,101 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrBlobMissing is returned for contents of files of a partial clone which weren't fetched
+	ErrBlobMissing = fmt.Errorf("blob is missing from the partial clone: %w", syscall.ENODATA)
+	// ErrBeyondShallowBoundary is returned for revisions of ancestors missing from a shallow clone
+	ErrBeyondShallowBoundary = fmt.Errorf("%w beyond the history of the shallow clone", ErrRevisionNotFound)
+)
+
+// CloneInfo tells whether a clone lacks some of its repository's objects, as shallow and partial clones do
+type CloneInfo struct {
+	// ShallowCommits are the commits of a shallow clone whose parents are missing from it
+	ShallowCommits []string
+	// PromisorRemote is the remote from which a partial clone fetches its missing objects
+	PromisorRemote string
+}
+
+func (info *CloneInfo) IsShallow() bool {
+	return info != nil && len(info.ShallowCommits) > 0
+}
+
+func (info *CloneInfo) IsPartial() bool {
+	return info != nil && len(info.PromisorRemote) > 0
+}
+
+// IsShallowCommit tells whether the parents of a commit are missing from the shallow clone
+func (info *CloneInfo) IsShallowCommit(sha string) bool {
+	if info == nil {
+		return false
+	}
+	for _, shallowSha := range info.ShallowCommits {
+		if shallowSha == sha {
+			return true
+		}
+	}
+	return false
+}
+
+// gitDir returns the .git directory of a clone, or the clone itself if it's bare
+func gitDir(clonePath string) string {
+	dotGit := filepath.Join(clonePath, ".git")
+	if info, err := os.Stat(dotGit); err == nil && info.IsDir() {
+		return dotGit
+	}
+	return clonePath
+}
+
+// DetectClone reads the shallow commits and the promisor remote of a clone, as git keeps them
+func DetectClone(clonePath string) (info *CloneInfo, err error) {
+	dir := gitDir(clonePath)
+	info = &CloneInfo{}
+
+	shallow, err := os.Open(filepath.Join(dir, "shallow"))
+	if err == nil {
+		defer shallow.Close()
+		scanner := bufio.NewScanner(shallow)
+		for scanner.Scan() {
+			if sha := strings.TrimSpace(scanner.Text()); len(sha) > 0 {
+				info.ShallowCommits = append(info.ShallowCommits, sha)
+			}
+		}
+		err = scanner.Err()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	configFile, err := os.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+	raw := config.New()
+	err = config.NewDecoder(configFile).Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading config of %v: %w", clonePath, err)
+	}
+	info.PromisorRemote = raw.Section("extensions").Option("partialclone")
+	for _, remote := range raw.Section("remote").Subsections {
+		if len(info.PromisorRemote) == 0 && strings.EqualFold(remote.Option("promisor"), "true") {
+			info.PromisorRemote = remote.Name
+		}
+	}
+	if len(info.PromisorRemote) == 0 {
+		promisorPacks, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.promisor"))
+		if len(promisorPacks) > 0 {
+			info.PromisorRemote = "origin"
+		}
+	}
+	return info, nil
+}
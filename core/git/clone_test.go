@@ -0,0 +1,154 @@
+package git_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type cloneTestSuite struct {
+	suite.Suite
+	remotesPath string
+	commits     []string
+	clonesPath  string
+	config      *git.AutoClone
+}
+
+func TestCloneTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/clone_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(cloneTestSuite))
+}
+
+func (cloneSuite *cloneTestSuite) SetupTest() {
+	cloneSuite.remotesPath, cloneSuite.commits = testutils.SetupLocalClones()
+	var err error
+	cloneSuite.clonesPath, err = ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	cloneSuite.config = &git.AutoClone{
+		URLTemplate:   "file://" + cloneSuite.remotesPath + "/{name}",
+		MaxConcurrent: 2,
+		Timeout:       time.Minute,
+		Wait:          true,
+	}
+}
+
+func (cloneSuite *cloneTestSuite) TearDownTest() {
+	// waits for the clone jobs, which lookups that don't wait leave running
+	git.SetAutoClone(nil)
+	os.RemoveAll(cloneSuite.remotesPath)
+	os.RemoveAll(cloneSuite.clonesPath)
+}
+
+func (cloneSuite *cloneTestSuite) enable() {
+	err := git.SetAutoClone(cloneSuite.config)
+	cloneSuite.Nil(err)
+}
+
+// assertClones checks only the given clones are in the clones path, with no partial clones left behind
+func (cloneSuite *cloneTestSuite) assertClones(names ...string) {
+	infos, err := ioutil.ReadDir(cloneSuite.clonesPath)
+	cloneSuite.Nil(err)
+	var actual []string
+	for _, info := range infos {
+		actual = append(actual, info.Name())
+	}
+	cloneSuite.Equal(names, actual)
+}
+
+func (cloneSuite *cloneTestSuite) TestClone() {
+	cloneSuite.enable()
+	err := git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	cloneSuite.Nil(err)
+	cloneSuite.assertClones(testutils.LOCAL_REPO_NAME)
+
+	provider, err := git.NewRepositoryProvider(path.Join(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME))
+	cloneSuite.Nil(err)
+	cloneSuite.NotNil(provider)
+	sha, err := provider.ResolveCommit("master")
+	cloneSuite.Nil(err)
+	cloneSuite.Equal(cloneSuite.commits[1], sha)
+
+	// existing clones aren't cloned again
+	err = git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	cloneSuite.Nil(err)
+}
+
+func (cloneSuite *cloneTestSuite) TestConcurrentLookups() {
+	cloneSuite.enable()
+	errs := make([]error, 8)
+	waitGroup := &sync.WaitGroup{}
+	for i := range errs {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			errs[i] = git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+		}(i)
+	}
+	waitGroup.Wait()
+	for _, err := range errs {
+		cloneSuite.Nil(err)
+	}
+	cloneSuite.assertClones(testutils.LOCAL_REPO_NAME)
+}
+
+func (cloneSuite *cloneTestSuite) TestNoWait() {
+	cloneSuite.config.Wait = false
+	cloneSuite.enable()
+	err := git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	cloneSuite.True(errors.Is(err, git.ErrCloning), "first lookup: %v", err)
+	cloneSuite.True(errors.Is(err, syscall.EAGAIN))
+
+	deadline := time.Now().Add(time.Minute)
+	for err != nil && errors.Is(err, syscall.EAGAIN) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	}
+	cloneSuite.Nil(err)
+	cloneSuite.assertClones(testutils.LOCAL_REPO_NAME)
+	// the clone is looked up before its job is done, so stop the cloner before the test returns
+	cloneSuite.Nil(git.SetAutoClone(nil))
+}
+
+func (cloneSuite *cloneTestSuite) TestFailures() {
+	cloneSuite.enable()
+	err := git.EnsureClone(cloneSuite.clonesPath, "wat")
+	cloneSuite.NotNil(err)
+	cloneSuite.False(errors.Is(err, syscall.EAGAIN))
+
+	for _, name := range []string{"..", "--upload-pack=touch"} {
+		err = git.EnsureClone(cloneSuite.clonesPath, name)
+		cloneSuite.True(errors.Is(err, common.ErrInvalidRepositoryName), name)
+	}
+
+	cloneSuite.config.Timeout = time.Nanosecond
+	cloneSuite.enable()
+	err = git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	cloneSuite.True(errors.Is(err, git.ErrCloneTimeout), "clone: %v", err)
+	cloneSuite.True(errors.Is(err, syscall.ETIMEDOUT))
+	cloneSuite.assertClones()
+}
+
+func (cloneSuite *cloneTestSuite) TestDisabled() {
+	err := git.EnsureClone(cloneSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	cloneSuite.Nil(err)
+	cloneSuite.assertClones()
+
+	for _, config := range []*git.AutoClone{
+		{URLTemplate: "file:///srv/mirrors/repo.git", MaxConcurrent: 1},
+		{URLTemplate: "file:///srv/mirrors/{name}.git", MaxConcurrent: 0},
+	} {
+		cloneSuite.NotNil(git.SetAutoClone(config), config.URLTemplate)
+	}
+}
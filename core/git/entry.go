@@ -1,74 +1,216 @@
 package git
 
-import "path"
+import (
+	"github.com/hashicorp/golang-lru/simplelru"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
 
+// Entry is an immutable file or directory of a tree. Directories keep their children sorted by name,
+// and are shared by the trees of all commits having them.
 type Entry struct {
-	Size          int64
-	IsDir         bool
-	EntriesByName map[string]*Entry
+	size      int64
+	directory *directory
 }
 
-type RootEntry struct {
-	Entry
-	EntriesByPath map[string]*Entry
+type directory struct {
+	names    []string
+	children []*Entry
+	// count is the number of entries in the directory's tree, including itself
+	count int
 }
 
-func FileEntry(parent *Entry, name string, size int64) (entry *Entry) {
-	entry = &Entry{
-		Size:          size,
-		EntriesByName: nil,
+// missingSize is the size of files whose blobs are missing from a partial clone, sparing a field for each entry
+const missingSize = -1
+
+// NewFileEntry returns a file of the given size
+func NewFileEntry(size int64) *Entry {
+	return &Entry{
+		size: size,
+	}
+}
+
+// NewMissingFileEntry returns a file whose blob is missing from a partial clone, so its size is unknown
+func NewMissingFileEntry() *Entry {
+	return &Entry{
+		size: missingSize,
 	}
-	parent.addEntry(entry, name)
-	return
 }
 
-func DirEntry(parent *Entry, name string) (entry *Entry) {
-	entry = &Entry{
-		EntriesByName: make(map[string]*Entry),
-		IsDir:         true,
+// NewDirEntry returns a directory of children by their names, sorting them in place
+func NewDirEntry(names []string, children []*Entry) *Entry {
+	dir := &directory{
+		names:    names,
+		children: children,
+		count:    1,
 	}
-	if parent != nil {
-		parent.addEntry(entry, name)
+	sort.Sort(dir)
+	for _, child := range children {
+		child.addCount(&dir.count)
+	}
+	return &Entry{
+		directory: dir,
 	}
-	return
 }
 
-func (entry *Entry) IsRoot() bool {
-	return false
+func (dir *directory) Len() int {
+	return len(dir.names)
 }
 
-func (root *RootEntry) IsRoot() bool {
-	return true
+func (dir *directory) Less(i, j int) bool {
+	return dir.names[i] < dir.names[j]
 }
 
-func (entry *Entry) addEntry(child *Entry, name string) {
-	entry.EntriesByName[name] = child
+func (dir *directory) Swap(i, j int) {
+	dir.names[i], dir.names[j] = dir.names[j], dir.names[i]
+	dir.children[i], dir.children[j] = dir.children[j], dir.children[i]
 }
 
-func ExtractBaseName(fromPath string) string {
-	return path.Base(fromPath)
+func (entry *Entry) addCount(count *int) {
+	if entry.directory == nil {
+		*count++
+	} else {
+		*count += entry.directory.count
+	}
 }
 
-func ExtractDirPath(fromPath string) (dirPath string) {
-	dirPath = path.Dir(fromPath)
-	if dirPath == "." {
-		return ""
+// Size is the size of a file, or zero for a file whose blob is missing
+func (entry *Entry) Size() int64 {
+	if entry.size == missingSize {
+		return 0
+	}
+	return entry.size
+}
+
+// IsMissing tells whether the blob of a file is missing from a partial clone, so its size is known only once read
+func (entry *Entry) IsMissing() bool {
+	return entry.size == missingSize
+}
+
+func (entry *Entry) IsDir() bool {
+	return entry.directory != nil
+}
+
+// Len is the number of children of a directory
+func (entry *Entry) Len() int {
+	if entry.directory == nil {
+		return 0
+	}
+	return len(entry.directory.names)
+}
+
+// Names are the sorted names of the children of a directory, which must not be modified
+func (entry *Entry) Names() []string {
+	if entry.directory == nil {
+		return nil
+	}
+	return entry.directory.names
+}
+
+// ChildAt returns the child at an index of the names of a directory
+func (entry *Entry) ChildAt(index int) *Entry {
+	return entry.directory.children[index]
+}
+
+// Index returns the index of a child of a directory by its name, or -1 if there's none
+func (entry *Entry) Index(name string) int {
+	if entry.directory == nil {
+		return -1
+	}
+	names := entry.directory.names
+	index := sort.SearchStrings(names, name)
+	if index == len(names) || names[index] != name {
+		return -1
 	}
+	return index
+}
+
+// Child returns a child of a directory by its name
+func (entry *Entry) Child(name string) (child *Entry, found bool) {
+	index := entry.Index(name)
+	if index < 0 {
+		return nil, false
+	}
+	return entry.directory.children[index], true
+}
+
+// Find returns the entry at a path relative to this entry
+func (entry *Entry) Find(entryPath string) (found *Entry, isFound bool) {
+	found = entry
+	if entryPath == RootEntryPath {
+		return found, true
+	}
+	for _, name := range strings.Split(entryPath, "/") {
+		found, isFound = found.Child(name)
+		if !isFound {
+			return nil, false
+		}
+	}
+	return found, true
+}
+
+// Count is the number of entries in the tree of an entry, including itself
+func (entry *Entry) Count() (count int) {
+	entry.addCount(&count)
 	return
 }
 
-func (root *RootEntry) ensurePath(fullPath string) (entry *Entry) {
+// interner keeps a single copy of each value by its key, as the same names and subtrees recur throughout the trees
+// of a repository. It keeps up to a bound of them, evicting the least recently used, as interning only spares memory.
+type interner struct {
+	values *simplelru.LRU
+	mutex  *sync.Mutex
+}
 
-	var found bool
-	entry, found = root.EntriesByPath[fullPath]
+func newInterner(size int) *interner {
+	values, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		panic(err)
+	}
+	return &interner{
+		values: values,
+		mutex:  &sync.Mutex{},
+	}
+}
+
+func (interner *interner) get(key string) (value interface{}, found bool) {
+	interner.mutex.Lock()
+	defer interner.mutex.Unlock()
+	return interner.values.Get(key)
+}
+
+// intern returns the value kept by a key, keeping the given value if there's none
+func (interner *interner) intern(key string, value interface{}) interface{} {
+	interner.mutex.Lock()
+	defer interner.mutex.Unlock()
+	existing, found := interner.values.Get(key)
 	if found {
-		return entry
+		return existing
 	}
+	interner.values.Add(key, value)
+	return value
+}
+
+func (interner *interner) remove(key string) {
+	interner.mutex.Lock()
+	defer interner.mutex.Unlock()
+	interner.values.Remove(key)
+}
 
-	dirPath := ExtractDirPath(fullPath)
-	parentEntry := root.ensurePath(dirPath)
+func (interner *interner) internName(name string) string {
+	return interner.intern(name, name).(string)
+}
+
+func ExtractBaseName(fromPath string) string {
+	return path.Base(fromPath)
+}
 
-	entry = DirEntry(parentEntry, ExtractBaseName(fullPath))
-	root.EntriesByPath[fullPath] = entry
+func ExtractDirPath(fromPath string) (dirPath string) {
+	dirPath = path.Dir(fromPath)
+	if dirPath == "." {
+		return ""
+	}
 	return
 }
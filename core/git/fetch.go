@@ -0,0 +1,178 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitreefs/core/logger"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fullShaLength = 40
+)
+
+var (
+	ErrFetchRateLimited = errors.New("fetched too recently")
+
+	fetchOnMiss      *FetchOnMiss
+	fetchOnMissMutex = &sync.RWMutex{}
+)
+
+// FetchOnMiss fetches commits missing from a clone from its origin, when they are looked up by sha
+type FetchOnMiss struct {
+	// MinInterval is the least time between fetches of a repository
+	MinInterval time.Duration
+	Timeout     time.Duration
+}
+
+// SetFetchOnMiss enables fetching missing commits by the given config, or disables it for a nil config
+func SetFetchOnMiss(config *FetchOnMiss) {
+	fetchOnMissMutex.Lock()
+	defer fetchOnMissMutex.Unlock()
+	fetchOnMiss = config
+}
+
+func fetchOnMissConfig() *FetchOnMiss {
+	fetchOnMissMutex.RLock()
+	defer fetchOnMissMutex.RUnlock()
+	return fetchOnMiss
+}
+
+// isShaLike tells whether a commitish may be a full or a short sha, rather than a ref
+func isShaLike(commitish string) bool {
+	if len(commitish) < ShortShaLength || len(commitish) > fullShaLength {
+		return false
+	}
+	for _, char := range commitish {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetcher fetches from the origin of a clone, one fetch at a time per sha with lookups of a sha in flight waiting for it
+type fetcher struct {
+	clonePath   string
+	jobsBySha   map[string]*fetchJob
+	lastFetchAt time.Time
+	mutex       *sync.Mutex
+}
+
+type fetchJob struct {
+	done chan struct{}
+	err  error
+}
+
+func newFetcher(clonePath string) *fetcher {
+	return &fetcher{
+		clonePath: clonePath,
+		jobsBySha: make(map[string]*fetchJob),
+		mutex:     &sync.Mutex{},
+	}
+}
+
+// fetch fetches a sha then refreshes the clone with the given function, before lookups waiting for it resume
+func (fetcher *fetcher) fetch(config *FetchOnMiss, sha string, refresh func() error) error {
+	fetcher.mutex.Lock()
+	job, found := fetcher.jobsBySha[sha]
+	if !found {
+		sinceLastFetch := time.Since(fetcher.lastFetchAt)
+		if sinceLastFetch < config.MinInterval {
+			fetcher.mutex.Unlock()
+			return fmt.Errorf("%w: %v fetched %v ago, %v missing", ErrFetchRateLimited, fetcher.clonePath, sinceLastFetch, sha)
+		}
+		job = &fetchJob{
+			done: make(chan struct{}),
+		}
+		fetcher.jobsBySha[sha] = job
+		fetcher.lastFetchAt = time.Now()
+		go func() {
+			job.err = fetcher.run(config, sha)
+			if job.err == nil {
+				job.err = refresh()
+			}
+			fetcher.mutex.Lock()
+			delete(fetcher.jobsBySha, sha)
+			fetcher.mutex.Unlock()
+			close(job.done)
+		}()
+	}
+	fetcher.mutex.Unlock()
+	<-job.done
+	return job.err
+}
+
+// run fetches a full sha by itself, falling back to fetching all refs which is also how short shas are fetched
+func (fetcher *fetcher) run(config *FetchOnMiss, sha string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	start := time.Now()
+
+	var output []byte
+	if len(sha) == fullShaLength {
+		output, err = exec.CommandContext(ctx, "git", "-C", fetcher.clonePath, "fetch", "--quiet", "origin", sha).CombinedOutput()
+		if err == nil {
+			logger.Info("fetcher.run: fetched %v to %v in %v", sha, fetcher.clonePath, time.Since(start))
+			return
+		}
+		logger.Info("fetcher.run: could not fetch %v to %v, fetching all refs: %v: %v", sha, fetcher.clonePath, err, strings.TrimSpace(string(output)))
+	}
+	output, err = exec.CommandContext(ctx, "git", "-C", fetcher.clonePath, "fetch", "--quiet", "origin").CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("fetching %v to %v took over %v", sha, fetcher.clonePath, config.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch %v to %v: %v: %v", sha, fetcher.clonePath, err, strings.TrimSpace(string(output)))
+	}
+	logger.Info("fetcher.run: fetched all refs to %v for %v in %v", fetcher.clonePath, sha, time.Since(start))
+	return
+}
+
+var (
+	fetchMissingBlobs      *FetchMissingBlobs
+	fetchMissingBlobsMutex = &sync.RWMutex{}
+)
+
+// FetchMissingBlobs fetches blobs missing from a partial clone from its promisor remote when they're needed,
+// rather than failing with ErrBlobMissing
+type FetchMissingBlobs struct {
+	Timeout time.Duration
+}
+
+// SetFetchMissingBlobs enables fetching missing blobs by the given config, or disables it for a nil config
+func SetFetchMissingBlobs(config *FetchMissingBlobs) {
+	fetchMissingBlobsMutex.Lock()
+	defer fetchMissingBlobsMutex.Unlock()
+	fetchMissingBlobs = config
+}
+
+func fetchMissingBlobsConfig() *FetchMissingBlobs {
+	fetchMissingBlobsMutex.RLock()
+	defer fetchMissingBlobsMutex.RUnlock()
+	return fetchMissingBlobs
+}
+
+// fetchBlobs fetches blobs from the promisor remote of a partial clone in a single fetch, as git itself does
+func fetchBlobs(config *FetchMissingBlobs, clonePath string, remote string, shas []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	start := time.Now()
+
+	command := exec.CommandContext(ctx, "git", "-C", clonePath, "-c", "fetch.negotiationAlgorithm=noop",
+		"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", "--recurse-submodules=no", "--filter=blob:none", "--stdin", remote)
+	command.Stdin = strings.NewReader(strings.Join(shas, "\n") + "\n")
+	output, err := command.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("fetching %v blobs to %v took over %v", len(shas), clonePath, config.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch %v blobs to %v: %v: %v", len(shas), clonePath, err, strings.TrimSpace(string(output)))
+	}
+	logger.Info("fetchBlobs: fetched %v blobs to %v in %v", len(shas), clonePath, time.Since(start))
+	return nil
+}
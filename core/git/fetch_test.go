@@ -0,0 +1,161 @@
+package git_test
+
+import (
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fetchTestSuite serves a clone of a local bare remote, to which commits are pushed after the clone
+type fetchTestSuite struct {
+	suite.Suite
+	remotesPath string
+	workPath    string
+	remotePath  string
+	clonesPath  string
+	provider    *git.RepositoryProvider
+}
+
+func TestFetchTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/fetch_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(fetchTestSuite))
+}
+
+func (fetchSuite *fetchTestSuite) SetupTest() {
+	fetchSuite.remotesPath, _ = testutils.SetupLocalClones()
+	fetchSuite.workPath = path.Join(fetchSuite.remotesPath, testutils.LOCAL_REPO_NAME)
+	fetchSuite.remotePath = path.Join(fetchSuite.remotesPath, "remote.git")
+	testutils.ExecCommandWithDir(fetchSuite.remotesPath, "git", "clone", "--quiet", "--bare", testutils.LOCAL_REPO_NAME, fetchSuite.remotePath)
+
+	var err error
+	fetchSuite.clonesPath, err = ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	testutils.ExecCommandWithDir(fetchSuite.clonesPath, "git", "clone", "--quiet", "--no-checkout", "file://"+fetchSuite.remotePath, testutils.LOCAL_REPO_NAME)
+	fetchSuite.provider = fetchSuite.openProvider()
+}
+
+func (fetchSuite *fetchTestSuite) TearDownTest() {
+	fetchSuite.provider.Close()
+	git.SetFetchOnMiss(nil)
+	git.SetBackend(git.GoGitBackendName)
+	os.RemoveAll(fetchSuite.remotesPath)
+	os.RemoveAll(fetchSuite.clonesPath)
+}
+
+func (fetchSuite *fetchTestSuite) openProvider() *git.RepositoryProvider {
+	provider, err := git.NewRepositoryProvider(path.Join(fetchSuite.clonesPath, testutils.LOCAL_REPO_NAME))
+	if err != nil {
+		panic(err)
+	}
+	return provider
+}
+
+// push commits a file to the remote under a ref, returning the sha of the commit
+func (fetchSuite *fetchTestSuite) push(fileName string, ref string) string {
+	sha := testutils.Commit(fetchSuite.workPath, map[string]string{fileName: fileName + "\n"}, fileName)
+	testutils.ExecCommandWithDir(fetchSuite.workPath, "git", "push", "--quiet", fetchSuite.remotePath, "HEAD:"+ref)
+	return sha
+}
+
+func (fetchSuite *fetchTestSuite) enable(minInterval time.Duration) {
+	git.SetFetchOnMiss(&git.FetchOnMiss{
+		MinInterval: minInterval,
+		Timeout:     time.Minute,
+	})
+}
+
+func (fetchSuite *fetchTestSuite) canResolve(commitish string) bool {
+	canResolve, err := fetchSuite.provider.CanResolve(commitish)
+	fetchSuite.Nil(err, commitish)
+	return canResolve
+}
+
+func (fetchSuite *fetchTestSuite) TestDisabled() {
+	sha := fetchSuite.push("pushed.txt", "refs/heads/master")
+	fetchSuite.False(fetchSuite.canResolve(sha))
+}
+
+func (fetchSuite *fetchTestSuite) TestFetchSha() {
+	// the commit isn't on any branch, so only fetching it by its sha finds it
+	sha := fetchSuite.push("hidden.txt", "refs/hidden/commit")
+	fetchSuite.enable(0)
+	fetchSuite.True(fetchSuite.canResolve(sha))
+
+	contents, err := fetchSuite.provider.FileContents(sha, "hidden.txt")
+	fetchSuite.Nil(err)
+	fetchSuite.Equal("hidden.txt\n", contents)
+	root, err := fetchSuite.provider.ListTree(sha)
+	fetchSuite.Nil(err)
+	fetchSuite.NotNil(find(root, "docs/guide.md"))
+}
+
+func (fetchSuite *fetchTestSuite) TestFetchShortSha() {
+	sha := fetchSuite.push("pushed.txt", "refs/heads/master")
+	fetchSuite.enable(0)
+	fetchSuite.True(fetchSuite.canResolve(sha[:git.ShortShaLength]))
+}
+
+func (fetchSuite *fetchTestSuite) TestCatFileBackend() {
+	fetchSuite.Nil(git.SetBackend(git.CatFileBackendName))
+	fetchSuite.provider.Close()
+	fetchSuite.provider = fetchSuite.openProvider()
+	fetchSuite.True(fetchSuite.canResolve("master"))
+
+	sha := fetchSuite.push("hidden.txt", "refs/hidden/commit")
+	fetchSuite.enable(0)
+	fetchSuite.True(fetchSuite.canResolve(sha))
+	contents, err := fetchSuite.provider.FileContents(sha, "hidden.txt")
+	fetchSuite.Nil(err)
+	fetchSuite.Equal("hidden.txt\n", contents)
+}
+
+func (fetchSuite *fetchTestSuite) TestRefsArentFetched() {
+	fetchSuite.push("pushed.txt", "refs/heads/feature")
+	fetchSuite.enable(0)
+	fetchSuite.False(fetchSuite.canResolve("feature"))
+	fetchSuite.False(fetchSuite.canResolve("origin/feature"))
+}
+
+func (fetchSuite *fetchTestSuite) TestRateLimit() {
+	fetchSuite.enable(time.Hour)
+	fetchSuite.False(fetchSuite.canResolve("0000000000000000000000000000000000000000"))
+
+	sha := fetchSuite.push("pushed.txt", "refs/heads/master")
+	fetchSuite.False(fetchSuite.canResolve(sha))
+
+	// other repositories are limited separately
+	otherProvider := fetchSuite.openProvider()
+	defer otherProvider.Close()
+	canResolve, err := otherProvider.CanResolve(sha)
+	fetchSuite.Nil(err)
+	fetchSuite.True(canResolve)
+}
+
+func (fetchSuite *fetchTestSuite) TestSingleFlight() {
+	sha := fetchSuite.push("pushed.txt", "refs/hidden/commit")
+	// lookups after the first would be rate limited, unless they wait for the fetch in flight
+	fetchSuite.enable(time.Hour)
+
+	resolved := make([]bool, 8)
+	waitGroup := &sync.WaitGroup{}
+	for i := range resolved {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			resolved[i], _ = fetchSuite.provider.CanResolve(sha)
+		}(i)
+	}
+	waitGroup.Wait()
+	for i := range resolved {
+		fetchSuite.True(resolved[i], "lookup %v", i)
+	}
+}
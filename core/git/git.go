@@ -1,157 +1,386 @@
 package git
 
 import (
+	"errors"
 	"fmt"
-	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/orcaman/concurrent-map"
 	"gitreefs/core/common"
 	"gitreefs/core/logger"
+	"io"
+	"io/ioutil"
+	"path"
+	"sync"
 )
 
 const (
-	MaxFileSizeMB    int64 = 6
-	MaxFileSizeBytes       = MaxFileSizeMB * 1024 * 1024
-	ShortShaLength         = 7
-	RootEntryPath          = ""
+	ShortShaLength = 7
+	RootEntryPath  = ""
+
+	// maxInternedTrees and maxInternedNames bound the subtrees and the names each repository keeps a single copy of
+	maxInternedTrees = 64 * 1024
+	maxInternedNames = 256 * 1024
+	// maxSubtreeBuilders bounds the goroutines building the subtrees of a repository's trees, beyond which they're built in turn
+	maxSubtreeBuilders = 64
+)
+
+var (
+	ErrFileTooLarge = errors.New("file size is too large to load to memory")
+
+	// MaxFileSizeBytes is the size of the largest file contents loaded to memory
+	MaxFileSizeBytes = common.DefaultMaxFileSizeMB * 1024 * 1024
 )
 
+// SetMaxFileSizeMB sets the size limit of loaded file contents, before any contents are loaded
+func SetMaxFileSizeMB(maxFileSizeMB int64) {
+	MaxFileSizeBytes = maxFileSizeMB * 1024 * 1024
+}
+
+// RepositoryProvider lists the trees and reads the files of a repository's commits, over a backend
 type RepositoryProvider struct {
-	repository      *git.Repository
-	shortShaMapping map[string]string
+	backend   Backend
+	clonePath string
+	fetcher   *fetcher
+	info      *CloneInfo
+	// treeBySha interns the entries of trees, so the subtrees commits share are built once
+	treeBySha *interner
+	// pendingTrees are the trees being built, so concurrent builds of the same tree wait for a single one
+	pendingTrees    cmap.ConcurrentMap
+	subtreeBuilders chan struct{}
+	names           *interner
+	workers         *limiter
+	// readAheadDirs are the directories read ahead, by their commitish and path
+	readAheadDirs *interner
+	// readAheadQueue takes the directories to read ahead, which is the queue the read-ahead workers share
+	readAheadQueue chan *readAheadDir
 }
 
+// NewRepositoryProvider opens a clone with the backend set by SetBackend, returning nil if there's no clone
 func NewRepositoryProvider(clonePath string) (provider *RepositoryProvider, err error) {
 	validateErr := common.ValidateDirectory(clonePath, false)
 	if validateErr != nil {
 		logger.Info("clone at %v doesn't exist", clonePath)
 		return nil, nil
 	}
-	provider = &RepositoryProvider{
-		shortShaMapping: make(map[string]string),
-	}
-	provider.repository, err = git.PlainOpen(clonePath)
+	var backend Backend
+	backend, err = openBackend(clonePath)
 	if err != nil {
 		return
 	}
-
-	// Manual implementation of short sha mapping, due to bug in go-git: https://github.com/go-git/go-git/issues/148
-	var iter object.CommitIter
-	iter, err = provider.repository.CommitObjects()
+	provider = NewBackendProvider(backend)
+	provider.clonePath = clonePath
+	provider.fetcher = newFetcher(clonePath)
+	provider.info, err = DetectClone(clonePath)
 	if err != nil {
-		return
+		_ = backend.Close()
+		return nil, err
 	}
-	err = iter.ForEach(func(commit *object.Commit) error {
-		sha := commit.Hash.String()
-		shortSha := sha[:ShortShaLength]
-		provider.shortShaMapping[shortSha] = sha
-		return nil
-	})
+	return provider, nil
+}
 
-	logger.Info("NewRepositoryProvider for %v with total of %v commits detected", clonePath, len(provider.shortShaMapping))
-	return
+// NewBackendProvider returns a provider over a backend, without fetching missing commits as there's no clone to fetch to
+func NewBackendProvider(backend Backend) *RepositoryProvider {
+	return &RepositoryProvider{
+		backend:         backend,
+		treeBySha:       newInterner(maxInternedTrees),
+		pendingTrees:    cmap.New(),
+		subtreeBuilders: make(chan struct{}, maxSubtreeBuilders),
+		names:           newInterner(maxInternedNames),
+		workers:         newRepositoryLimiter(),
+
+		readAheadDirs:  newInterner(maxReadAheadDirs),
+		readAheadQueue: readAheadQueue,
+	}
 }
 
-func (provider *RepositoryProvider) getCommit(commitish string) (commit *object.Commit, err error) {
+func (provider *RepositoryProvider) Backend() Backend {
+	return provider.backend
+}
 
-	if len(commitish) == ShortShaLength {
-		sha, found := provider.shortShaMapping[commitish]
-		if found {
-			commitish = sha
-		}
+func (provider *RepositoryProvider) Close() error {
+	return provider.backend.Close()
+}
+
+// resolve resolves a commitish by the revision grammar to the sha of its commit and the path of its root within it
+func (provider *RepositoryProvider) resolve(commitish string) (sha string, treePath string, err error) {
+	var revision *Revision
+	revision, err = ParseRevision(commitish)
+	if err != nil {
+		return
+	}
+	sha, err = provider.resolveRevision(revision)
+	if err != nil || revision.Path == RootEntryPath {
+		return sha, RootEntryPath, err
+	}
+	var info *ObjectInfo
+	info, err = provider.backend.StatObject(sha + ":" + revision.Path)
+	if errors.Is(err, ErrObjectNotFound) || (err == nil && info.Type != plumbing.TreeObject.String()) {
+		return "", "", fmt.Errorf("%w: no directory %v", ErrRevisionNotFound, commitish)
 	}
+	return sha, revision.Path, err
+}
 
-	var hash *plumbing.Hash
-	hash, err = provider.repository.ResolveRevision(plumbing.Revision(commitish))
+// resolveFetching resolves a ref or a sha, fetching it if it's a missing sha and fetch on miss is enabled
+func (provider *RepositoryProvider) resolveFetching(commitish string) (sha string, err error) {
+	sha, err = provider.backend.ResolveRevision(commitish)
+	if !errors.Is(err, ErrRevisionNotFound) || provider.fetcher == nil || !isShaLike(commitish) {
+		return
+	}
+	config := fetchOnMissConfig()
+	if config == nil {
+		return
+	}
+	err = provider.fetcher.fetch(config, commitish, provider.backend.Refresh)
 	if err != nil {
-		logger.Info("commitish '%v' could not be resolved: %v", commitish, err)
-		return nil, nil
+		logger.Info("RepositoryProvider.resolve: %v", err)
 	}
+	// resolved again even if rate limited, as another lookup may have just fetched it
+	return provider.backend.ResolveRevision(commitish)
+}
+
+func (provider *RepositoryProvider) CanResolve(commitish string) (canResolve bool, err error) {
+	_, _, err = provider.resolve(commitish)
+	if errors.Is(err, ErrRevisionNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
 
-	commit, err = provider.repository.CommitObject(*hash)
+// ResolveCommit returns the sha of the commit a commitish points to
+func (provider *RepositoryProvider) ResolveCommit(commitish string) (sha string, err error) {
+	sha, _, err = provider.resolveTree(commitish)
 	return
 }
 
-func (provider *RepositoryProvider) CanResolve(commitish string) (canResolve bool, err error) {
-	var commit *object.Commit
-	commit, err = provider.getCommit(commitish)
-	if err != nil {
-		return false, err
+// resolveTree resolves a commitish to the sha of its commit and the path of its root within it
+func (provider *RepositoryProvider) resolveTree(commitish string) (sha string, treePath string, err error) {
+	sha, treePath, err = provider.resolve(commitish)
+	if errors.Is(err, ErrRevisionNotFound) {
+		if errors.Is(err, ErrBeyondShallowBoundary) {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("%v not found: %w", commitish, ErrRevisionNotFound)
 	}
-	canResolve = commit != nil
 	return
 }
 
-func (provider *RepositoryProvider) ListTree(commitish string) (root *RootEntry, err error) {
+// Canonicalize resolves a commitish to the sha of its commit, followed by the path of its root if it has one.
+// Unlike the commitish, the canonical name keeps naming the same tree when refs move.
+func (provider *RepositoryProvider) Canonicalize(commitish string) (canonical string, err error) {
+	var sha, treePath string
+	sha, treePath, err = provider.resolveTree(commitish)
+	if err != nil || treePath == RootEntryPath {
+		return sha, err
+	}
+	return sha + ":" + treePath, nil
+}
 
-	var commit *object.Commit
-	commit, err = provider.getCommit(commitish)
+// ListTree lists the tree of a commitish, whose entries are shared with the trees of other commits having the same subtrees
+func (provider *RepositoryProvider) ListTree(commitish string) (root *Entry, err error) {
+	return provider.ListTreeWithPriority(commitish, InteractivePriority)
+}
+
+// ListTreeWithPriority lists the tree of a commitish as ListTree does, reading its trees with the given priority
+func (provider *RepositoryProvider) ListTreeWithPriority(commitish string, priority Priority) (root *Entry, err error) {
+
+	var sha, treePath string
+	sha, treePath, err = provider.resolveTree(commitish)
 	if err != nil {
 		return
 	}
-	if commit == nil {
-		return nil, fmt.Errorf("%v not found", commitish)
-	}
 
-	var tree *object.Tree
-	tree, err = commit.Tree()
+	var treeSha string
+	treeSha, err = provider.treeSha(sha, treePath)
 	if err != nil {
 		return
 	}
 
-	root = &RootEntry{
-		Entry: Entry{
-			Size:          0,
-			IsDir:         true,
-			EntriesByName: make(map[string]*Entry),
-		},
-		EntriesByPath: make(map[string]*Entry),
+	root, err = provider.buildTree(treeSha, priority)
+	if err != nil {
+		return
 	}
-	root.EntriesByPath[RootEntryPath] = &root.Entry
 
-	err = tree.Files().ForEach(func(file *object.File) (err error) {
-		mode := file.Mode
-		if !mode.IsFile() || mode.IsMalformed() || !mode.IsRegular() {
+	logger.Info("ListTree for %v with total of %v paths detected", commitish, root.Count())
+
+	return
+}
+
+// treeSha returns the sha of the tree at a path of a commit
+func (provider *RepositoryProvider) treeSha(sha string, treePath string) (treeSha string, err error) {
+	if treePath == RootEntryPath {
+		var commit *CommitInfo
+		commit, err = provider.backend.ReadCommit(sha)
+		if err != nil {
 			return
 		}
-		filePath := file.Name
-		fileName := ExtractBaseName(filePath)
-		parentPath := ExtractDirPath(filePath)
-		parent := root.ensurePath(parentPath)
-		fileEntry := FileEntry(parent, fileName, file.Size)
-		root.EntriesByPath[filePath] = fileEntry
+		return commit.Tree, nil
+	}
+	var info *ObjectInfo
+	info, err = provider.backend.StatObject(sha + ":" + treePath)
+	if err != nil {
 		return
+	}
+	return info.Sha, nil
+}
+
+// pendingTree is a tree being built, whose mutex is held until it's built
+type pendingTree struct {
+	tree  *Entry
+	mutex *sync.Mutex
+}
+
+// buildTree builds the entries of a tree by its sha once, reusing the entries of trees built before,
+// with concurrent builds of the same tree waiting for the first. Failures aren't kept, so the next build tries again.
+func (provider *RepositoryProvider) buildTree(treeSha string, priority Priority) (tree *Entry, err error) {
+	existing, found := provider.treeBySha.get(treeSha)
+	if found {
+		return existing.(*Entry), nil
+	}
+
+	wrapped := provider.pendingTrees.Upsert(treeSha, nil, func(found bool, existingValue interface{}, _ interface{}) interface{} {
+		if found {
+			return existingValue
+		}
+		return &pendingTree{mutex: &sync.Mutex{}}
 	})
+	pending := wrapped.(*pendingTree)
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	if pending.tree != nil {
+		return pending.tree, nil
+	}
+	// a build which was done before this one was pending is reused as well
+	existing, found = provider.treeBySha.get(treeSha)
+	if !found {
+		existing, err = provider.readTree(treeSha, priority)
+	}
+	if err == nil {
+		pending.tree = provider.treeBySha.intern(treeSha, existing).(*Entry)
+	}
+	// done builds are found interned from now on
+	provider.pendingTrees.RemoveCb(treeSha, func(_ string, value interface{}, exists bool) bool {
+		return exists && value == pending
+	})
+	return pending.tree, err
+}
 
-	logger.Info("ListTree for %v with total of %v paths detected", commitish, len(root.EntriesByPath))
+// readTree reads a tree and builds its entries, building its subtrees in parallel while there are free subtree builders,
+// each read once a worker is free. Only regular files are listed, and so are only directories which have any.
+func (provider *RepositoryProvider) readTree(treeSha string, priority Priority) (tree *Entry, err error) {
+	var entries []TreeFile
+	provider.work(priority, func() {
+		entries, err = provider.backend.ReadTree(treeSha)
+	})
+	if err != nil {
+		return
+	}
 
-	return
+	subtrees := make([]*Entry, len(entries))
+	errs := make([]error, len(entries))
+	waitGroup := &sync.WaitGroup{}
+	for i, entry := range entries {
+		if entry.Mode != filemode.Dir {
+			continue
+		}
+		select {
+		case provider.subtreeBuilders <- struct{}{}:
+			waitGroup.Add(1)
+			go func(i int, subtreeSha string) {
+				defer waitGroup.Done()
+				defer func() { <-provider.subtreeBuilders }()
+				subtrees[i], errs[i] = provider.buildTree(subtreeSha, priority)
+			}(i, entry.Sha)
+		default:
+			// building it in turn rather than waiting for a builder, which may be waiting for this tree
+			subtrees[i], errs[i] = provider.buildTree(entry.Sha, priority)
+		}
+	}
+	waitGroup.Wait()
+
+	names := make([]string, 0, len(entries))
+	children := make([]*Entry, 0, len(entries))
+	for i, entry := range entries {
+		var child *Entry
+		mode := entry.Mode
+		if mode == filemode.Dir {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			child = subtrees[i]
+			if child.Len() == 0 {
+				continue
+			}
+		} else if mode.IsFile() && !mode.IsMalformed() && mode.IsRegular() && entry.Missing {
+			child = NewMissingFileEntry()
+		} else if mode.IsFile() && !mode.IsMalformed() && mode.IsRegular() {
+			child = NewFileEntry(entry.Size)
+		} else {
+			continue
+		}
+		names = append(names, provider.names.internName(entry.Path))
+		children = append(children, child)
+	}
+	return NewDirEntry(names, children), nil
 }
 
 func (provider *RepositoryProvider) FileContents(commitish string, filePath string) (contents string, err error) {
-	var commit *object.Commit
-	commit, err = provider.getCommit(commitish)
+	return provider.FileContentsWithPriority(commitish, filePath, InteractivePriority)
+}
+
+// FileContentsWithPriority reads a file as FileContents does, once a worker of the given priority is free
+func (provider *RepositoryProvider) FileContentsWithPriority(
+	commitish string,
+	filePath string,
+	priority Priority,
+) (contents string, err error) {
+	var sha, treePath string
+	sha, treePath, err = provider.resolveTree(commitish)
 	if err != nil {
 		return
 	}
-	if commit == nil {
-		return "", fmt.Errorf("%v not found", commitish)
-	}
 
-	var file *object.File
-	file, err = commit.File(filePath)
+	var info *ObjectInfo
+	info, err = provider.backend.StatObject(sha + ":" + path.Join(treePath, filePath))
 	if err != nil {
 		return
 	}
+	if info.Type != plumbing.BlobObject.String() {
+		err = fmt.Errorf("%w: %v/%v is a %v", ErrObjectNotFound, commitish, filePath, info.Type)
+		return
+	}
 
-	if file.Size >= MaxFileSizeBytes {
-		err = fmt.Errorf("file size is too large to load to memory - %v at %v/%v", file.Size, commitish, filePath)
+	if info.Size >= MaxFileSizeBytes {
+		err = fmt.Errorf("%w - %v at %v/%v", ErrFileTooLarge, info.Size, commitish, filePath)
 		return
 	}
 
-	contents, err = file.Contents()
+	cache, _ := blobCacheConfig()
+	var found bool
+	contents, found = cache.get(info.Sha)
+	if found {
+		return
+	}
+	provider.work(priority, func() {
+		contents, err = provider.readBlob(info.Sha)
+	})
+	if err == nil {
+		cache.add(info.Sha, contents, false)
+	}
 	if err != nil {
 		logger.Info("FileContents for %v :: %v with content of size %v", commitish, filePath, len(contents))
 	}
 	return
 }
+
+func (provider *RepositoryProvider) readBlob(sha string) (contents string, err error) {
+	var reader io.ReadCloser
+	reader, err = provider.backend.OpenBlob(sha)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	var bytes []byte
+	bytes, err = ioutil.ReadAll(reader)
+	return string(bytes), err
+}
@@ -53,14 +53,19 @@ func (gitSuite *gitTestSuite) TearDownTest() {
 
 func countTreeNodes(node *Entry) uint {
 	var count uint = 1
-	if node.IsDir {
-		for _, child := range node.EntriesByName {
-			count += countTreeNodes(child)
+	if node.IsDir() {
+		for i := 0; i < node.Len(); i++ {
+			count += countTreeNodes(node.ChildAt(i))
 		}
 	}
 	return count
 }
 
+func hasPath(tree *Entry, path string) bool {
+	_, found := tree.Find(path)
+	return found
+}
+
 func lookupNode(node *Entry, path string) *Entry {
 	return lookupNodeRecursive(node, strings.Split(path, "/"))
 }
@@ -69,11 +74,11 @@ func lookupNodeRecursive(node *Entry, pathParts []string) (target *Entry) {
 	if len(pathParts) == 0 {
 		return node
 	}
-	if !node.IsDir {
+	if !node.IsDir() {
 		return nil
 	}
 	currentPart := pathParts[0]
-	child, found := node.EntriesByName[currentPart]
+	child, found := node.Child(currentPart)
 	if !found {
 		return nil
 	}
@@ -84,40 +89,40 @@ func lookupNodeRecursive(node *Entry, pathParts []string) (target *Entry) {
 func (gitSuite *gitTestSuite) TestListTreeForRegularCommit() {
 	tree, err := gitSuite.provider.ListTree("2ca742044ba451d00c6854a465fdd4280d9ad1f5")
 	gitSuite.Nil(err, "git.ListTree: %v", err)
-	gitSuite.EqualValues(209, len(tree.EntriesByPath), "tree size not as expected")
-	gitSuite.EqualValues(209, countTreeNodes(&tree.Entry), "tree size not as expected")
-	gitSuite.Contains(tree.EntriesByPath, "", "no root entry")
-	dirEntry := tree.EntriesByPath[""]
+	gitSuite.EqualValues(209, tree.Count(), "tree size not as expected")
+	gitSuite.EqualValues(209, countTreeNodes(tree), "tree size not as expected")
+	gitSuite.True(hasPath(tree, ""), "no root entry")
+	dirEntry, _ := tree.Find("")
 	gitSuite.NotNil(dirEntry)
-	gitSuite.True(dirEntry.IsDir)
-	gitSuite.EqualValues(0, dirEntry.Size)
-	gitSuite.Equal(4, len(dirEntry.EntriesByName))
+	gitSuite.True(dirEntry.IsDir())
+	gitSuite.EqualValues(0, dirEntry.Size())
+	gitSuite.Equal(4, dirEntry.Len())
 
-	gitSuite.Contains(tree.EntriesByPath, "src", "no src dir")
-	dirEntry = lookupNode(&tree.Entry, "src")
+	gitSuite.True(hasPath(tree, "src"), "no src dir")
+	dirEntry = lookupNode(tree, "src")
 	gitSuite.NotNil(dirEntry)
-	gitSuite.True(dirEntry.IsDir)
-	gitSuite.EqualValues(0, dirEntry.Size)
-	gitSuite.Equal(1, len(dirEntry.EntriesByName))
+	gitSuite.True(dirEntry.IsDir())
+	gitSuite.EqualValues(0, dirEntry.Size())
+	gitSuite.Equal(1, dirEntry.Len())
 
-	gitSuite.Contains(tree.EntriesByPath, "src/main/java/com/dchealth/service/common", "no common dir")
-	dirEntry = lookupNode(&tree.Entry, "src/main/java/com/dchealth/service/common")
+	gitSuite.True(hasPath(tree, "src/main/java/com/dchealth/service/common"), "no common dir")
+	dirEntry = lookupNode(tree, "src/main/java/com/dchealth/service/common")
 	gitSuite.NotNil(dirEntry)
-	gitSuite.True(dirEntry.IsDir)
-	gitSuite.EqualValues(0, dirEntry.Size)
-	gitSuite.Equal(7, len(dirEntry.EntriesByName))
+	gitSuite.True(dirEntry.IsDir())
+	gitSuite.EqualValues(0, dirEntry.Size())
+	gitSuite.Equal(7, dirEntry.Len())
 
-	gitSuite.Contains(tree.EntriesByPath, "src/main/java/com/dchealth/service/common/YunUserService.java", "no java file")
-	fileEntry := lookupNode(&tree.Entry, "src/main/java/com/dchealth/service/common/YunUserService.java")
+	gitSuite.True(hasPath(tree, "src/main/java/com/dchealth/service/common/YunUserService.java"), "no java file")
+	fileEntry := lookupNode(tree, "src/main/java/com/dchealth/service/common/YunUserService.java")
 	gitSuite.NotNil(dirEntry)
-	gitSuite.False(fileEntry.IsDir)
-	gitSuite.EqualValues(28092, fileEntry.Size)
-	gitSuite.Nil(fileEntry.EntriesByName)
-
-	gitSuite.NotContains(tree.EntriesByPath, "foo", "found fake dir")
-	gitSuite.NotContains(tree.EntriesByPath, "foo/bar", "found fake dir")
-	gitSuite.Nil(lookupNode(&tree.Entry, "foo"))
-	gitSuite.Nil(lookupNode(&tree.Entry, "foo/bar"))
+	gitSuite.False(fileEntry.IsDir())
+	gitSuite.EqualValues(28092, fileEntry.Size())
+	gitSuite.Nil(fileEntry.Names())
+
+	gitSuite.False(hasPath(tree, "foo"), "found fake dir")
+	gitSuite.False(hasPath(tree, "foo/bar"), "found fake dir")
+	gitSuite.Nil(lookupNode(tree, "foo"))
+	gitSuite.Nil(lookupNode(tree, "foo/bar"))
 }
 
 func (gitSuite *gitTestSuite) TestListTreeForNonExisting() {
@@ -130,21 +135,21 @@ func (gitSuite *gitTestSuite) TestListTreeForNonExisting() {
 func (gitSuite *gitTestSuite) TestListTreeForShortSha() {
 	tree, err := gitSuite.provider.ListTree("2ca7420")
 	gitSuite.Nil(err, "git.ListTree: %v", err)
-	gitSuite.EqualValues(209, countTreeNodes(&tree.Entry), "tree size not as expected")
+	gitSuite.EqualValues(209, countTreeNodes(tree), "tree size not as expected")
 }
 
 func (gitSuite *gitTestSuite) TestListTreeForMainBranchName() {
 	tree, err := gitSuite.provider.ListTree("master")
 	gitSuite.Nil(err, "git.ListTree: %v", err)
-	gitSuite.EqualValues(211, len(tree.EntriesByPath), "tree size not as expected")
-	gitSuite.EqualValues(211, countTreeNodes(&tree.Entry), "tree size not as expected")
+	gitSuite.EqualValues(211, tree.Count(), "tree size not as expected")
+	gitSuite.EqualValues(211, countTreeNodes(tree), "tree size not as expected")
 }
 
 func (gitSuite *gitTestSuite) TestListTreeForBranchName() {
 	tree, err := gitSuite.provider.ListTree("remotes/origin/lfx")
 	gitSuite.Nil(err, "git.ListTree: %v", err)
-	gitSuite.EqualValues(209, len(tree.EntriesByPath), "tree size not as expected")
-	gitSuite.EqualValues(209, countTreeNodes(&tree.Entry), "tree size not as expected")
+	gitSuite.EqualValues(209, tree.Count(), "tree size not as expected")
+	gitSuite.EqualValues(209, countTreeNodes(tree), "tree size not as expected")
 }
 
 func (gitSuite *gitTestSuite) TestFileContents() {
@@ -0,0 +1,388 @@
+package git
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"gitreefs/core/logger"
+	"io"
+	"strings"
+	"sync"
+)
+
+// goGitBackend reads the objects of a clone with go-git, reopening it on refresh as go-git doesn't look for new packs
+type goGitBackend struct {
+	clonePath  string
+	info       *CloneInfo
+	opened     *goGitClone
+	mutex      *sync.RWMutex
+	fetchMutex *sync.Mutex
+}
+
+type goGitClone struct {
+	repository      *git.Repository
+	storer          *lockedStorer
+	shortShaMapping map[string]string
+}
+
+var _ Backend = &goGitBackend{}
+
+func NewGoGitBackend(clonePath string) (backend Backend, err error) {
+	goGit := &goGitBackend{
+		clonePath:  clonePath,
+		mutex:      &sync.RWMutex{},
+		fetchMutex: &sync.Mutex{},
+	}
+	goGit.info, err = DetectClone(clonePath)
+	if err != nil {
+		return
+	}
+	goGit.opened, err = openGoGitClone(clonePath, nil)
+	if err != nil {
+		return
+	}
+	return goGit, nil
+}
+
+// openGoGitClone opens a clone, mapping the short shas of its commits unless given the mapping of a previous opening.
+// Its storage is tracked among the open pack files once opened.
+func openGoGitClone(clonePath string, shortShaMapping map[string]string) (clone *goGitClone, err error) {
+	clone = &goGitClone{
+		storer:          openGoGitStorage(clonePath),
+		shortShaMapping: shortShaMapping,
+	}
+	clone.repository, err = git.Open(clone.storer, nil)
+	if err != nil {
+		clone.storer.closeAll()
+		return nil, err
+	}
+	if shortShaMapping == nil {
+		err = clone.mapShortShas()
+	}
+	clone.storer.closeAll()
+	if err != nil {
+		return nil, err
+	}
+	openPacks.track(clone.storer)
+	return clone, nil
+}
+
+func (clone *goGitClone) mapShortShas() (err error) {
+	clone.shortShaMapping = make(map[string]string)
+
+	// Manual implementation of short sha mapping, due to bug in go-git: https://github.com/go-git/go-git/issues/148
+	var iter object.CommitIter
+	iter, err = clone.repository.CommitObjects()
+	if err != nil {
+		return
+	}
+	err = iter.ForEach(func(commit *object.Commit) error {
+		sha := commit.Hash.String()
+		shortSha := sha[:ShortShaLength]
+		clone.shortShaMapping[shortSha] = sha
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	logger.Info("openGoGitClone for %v with total of %v commits detected", clone.storer.clonePath, len(clone.shortShaMapping))
+	return nil
+}
+
+// lockedStorer serializes reading objects, as the packfile indexes of go-git's filesystem storage
+// aren't safe for concurrent use. Decoding the objects read is left to run in parallel.
+type lockedStorer struct {
+	storage.Storer
+	files     *filesystem.Storage
+	clonePath string
+	mutex     *sync.Mutex
+	// packs are the pack files open through the storage and element its place among the storages by recency,
+	// both guarded by the mutex of openPacks
+	packs   map[*countedFile]struct{}
+	element *list.Element
+}
+
+func (storer *lockedStorer) EncodedObject(
+	objectType plumbing.ObjectType,
+	hash plumbing.Hash,
+) (plumbing.EncodedObject, error) {
+	defer storer.read()
+	storer.mutex.Lock()
+	defer storer.mutex.Unlock()
+	return storer.Storer.EncodedObject(objectType, hash)
+}
+
+func (storer *lockedStorer) HasEncodedObject(hash plumbing.Hash) error {
+	defer storer.read()
+	storer.mutex.Lock()
+	defer storer.mutex.Unlock()
+	return storer.Storer.HasEncodedObject(hash)
+}
+
+func (storer *lockedStorer) EncodedObjectSize(hash plumbing.Hash) (size int64, err error) {
+	defer storer.read()
+	storer.mutex.Lock()
+	defer storer.mutex.Unlock()
+	return objectSize(storer.Storer, hash)
+}
+
+func (backend *goGitBackend) current() *goGitClone {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+	return backend.opened
+}
+
+func (backend *goGitBackend) Refresh() error {
+	return backend.reopen(true)
+}
+
+// reopen opens the clone again, remapping short shas only if commits may have been added
+func (backend *goGitBackend) reopen(withCommits bool) error {
+	var shortShaMapping map[string]string
+	if !withCommits {
+		shortShaMapping = backend.current().shortShaMapping
+	}
+	clone, err := openGoGitClone(backend.clonePath, shortShaMapping)
+	if err != nil {
+		return err
+	}
+	backend.mutex.Lock()
+	previous := backend.opened
+	backend.opened = clone
+	backend.mutex.Unlock()
+	openPacks.untrack(previous.storer)
+	return nil
+}
+
+func (backend *goGitBackend) ResolveRevision(revision string) (sha string, err error) {
+	clone := backend.current()
+	if len(revision) == ShortShaLength {
+		fullSha, found := clone.shortShaMapping[revision]
+		if found {
+			revision = fullSha
+		}
+	}
+
+	var hash *plumbing.Hash
+	hash, err = clone.repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		logger.Info("commitish '%v' could not be resolved: %v", revision, err)
+		return "", fmt.Errorf("%w: %v", ErrRevisionNotFound, revision)
+	}
+
+	var commit *object.Commit
+	commit, err = clone.repository.CommitObject(*hash)
+	if err != nil {
+		return
+	}
+	return commit.Hash.String(), nil
+}
+
+func (backend *goGitBackend) ReadCommit(sha string) (info *CommitInfo, err error) {
+	var commit *object.Commit
+	commit, err = backend.current().repository.CommitObject(plumbing.NewHash(sha))
+	if err == plumbing.ErrObjectNotFound {
+		return nil, fmt.Errorf("%w: commit %v", ErrObjectNotFound, sha)
+	}
+	if err != nil {
+		return
+	}
+	info = &CommitInfo{
+		Sha:  sha,
+		Tree: commit.TreeHash.String(),
+		Time: commit.Committer.When,
+	}
+	for _, parent := range commit.ParentHashes {
+		info.Parents = append(info.Parents, parent.String())
+	}
+	return
+}
+
+// objectSize reads the size of an object from its header, without decoding it
+func objectSize(storer storage.Storer, hash plumbing.Hash) (size int64, err error) {
+	sizer, canSize := storer.(interface {
+		EncodedObjectSize(plumbing.Hash) (int64, error)
+	})
+	if canSize {
+		return sizer.EncodedObjectSize(hash)
+	}
+	var encoded plumbing.EncodedObject
+	encoded, err = storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return
+	}
+	return encoded.Size(), nil
+}
+
+// fetchMissing fetches blobs missing from a partial clone if enabled, otherwise it fails with ErrBlobMissing
+func (backend *goGitBackend) fetchMissing(shas []string) (err error) {
+	config := fetchMissingBlobsConfig()
+	if !backend.info.IsPartial() {
+		return fmt.Errorf("%w: %v objects of %v, which isn't a partial clone", ErrObjectNotFound, len(shas), backend.clonePath)
+	}
+	if config == nil {
+		return fmt.Errorf("%w: %v blobs of %v", ErrBlobMissing, len(shas), backend.clonePath)
+	}
+
+	backend.fetchMutex.Lock()
+	defer backend.fetchMutex.Unlock()
+	// blobs fetched while waiting for another fetch aren't fetched again
+	repository := backend.current().repository
+	var stillMissing []string
+	for _, sha := range shas {
+		_, err = objectSize(repository.Storer, plumbing.NewHash(sha))
+		if err == plumbing.ErrObjectNotFound {
+			stillMissing = append(stillMissing, sha)
+		}
+	}
+	if len(stillMissing) == 0 {
+		return nil
+	}
+	err = fetchBlobs(config, backend.clonePath, backend.info.PromisorRemote, stillMissing)
+	if err != nil {
+		return
+	}
+	return backend.reopen(false)
+}
+
+// blobSize reads the size of a blob, fetching it if it's missing
+func (backend *goGitBackend) blobSize(hash plumbing.Hash) (size int64, err error) {
+	size, err = objectSize(backend.current().repository.Storer, hash)
+	if err != plumbing.ErrObjectNotFound {
+		return
+	}
+	err = backend.fetchMissing([]string{hash.String()})
+	if err != nil {
+		return
+	}
+	return objectSize(backend.current().repository.Storer, hash)
+}
+
+// ReadTree lists a tree with the sizes of its blobs. Blobs missing from a partial clone are marked rather than fetched,
+// as a listing shouldn't download the contents of every file in it, and they're fetched only once read.
+func (backend *goGitBackend) ReadTree(treeSha string) (entries []TreeFile, err error) {
+	repository := backend.current().repository
+	var tree *object.Tree
+	tree, err = repository.TreeObject(plumbing.NewHash(treeSha))
+	if err == plumbing.ErrObjectNotFound {
+		return nil, fmt.Errorf("%w: tree %v", ErrObjectNotFound, treeSha)
+	}
+	if err != nil {
+		return
+	}
+	entries = make([]TreeFile, len(tree.Entries))
+	for i, entry := range tree.Entries {
+		entries[i] = TreeFile{
+			Path: entry.Name,
+			Sha:  entry.Hash.String(),
+			Mode: entry.Mode,
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		err = backend.readSize(repository.Storer, &entries[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// readSize reads the size of the blob of a file, or marks it as missing if it's missing from a partial clone
+func (backend *goGitBackend) readSize(storer storage.Storer, file *TreeFile) (err error) {
+	file.Size, err = objectSize(storer, plumbing.NewHash(file.Sha))
+	if err != plumbing.ErrObjectNotFound {
+		return
+	}
+	if !backend.info.IsPartial() {
+		return fmt.Errorf("%w: blob %v of %v, which isn't a partial clone", ErrObjectNotFound, file.Sha, backend.clonePath)
+	}
+	file.Missing = true
+	return nil
+}
+
+func (backend *goGitBackend) StatObject(objectName string) (info *ObjectInfo, err error) {
+	repository := backend.current().repository
+	separator := strings.Index(objectName, ":")
+	if separator < 0 {
+		hash := plumbing.NewHash(objectName)
+		var encoded plumbing.EncodedObject
+		encoded, err = repository.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if err == plumbing.ErrObjectNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, objectName)
+		}
+		if err != nil {
+			return
+		}
+		return &ObjectInfo{
+			Sha:  encoded.Hash().String(),
+			Type: encoded.Type().String(),
+			Size: encoded.Size(),
+		}, nil
+	}
+
+	var commit *object.Commit
+	commit, err = repository.CommitObject(plumbing.NewHash(objectName[:separator]))
+	if err != nil {
+		return
+	}
+	var tree *object.Tree
+	tree, err = commit.Tree()
+	if err != nil {
+		return
+	}
+	var entry *object.TreeEntry
+	entry, err = tree.FindEntry(objectName[separator+1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrObjectNotFound, objectName, err)
+	}
+	info = &ObjectInfo{
+		Sha: entry.Hash.String(),
+	}
+	switch entry.Mode {
+	case filemode.Dir:
+		info.Type = plumbing.TreeObject.String()
+	case filemode.Submodule:
+		info.Type = plumbing.CommitObject.String()
+		return
+	default:
+		info.Type = plumbing.BlobObject.String()
+	}
+	info.Size, err = backend.blobSize(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (backend *goGitBackend) OpenBlob(sha string) (reader io.ReadCloser, err error) {
+	hash := plumbing.NewHash(sha)
+	var blob *object.Blob
+	blob, err = backend.current().repository.BlobObject(hash)
+	if err == plumbing.ErrObjectNotFound {
+		err = backend.fetchMissing([]string{sha})
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, sha)
+		}
+		if err != nil {
+			return
+		}
+		blob, err = backend.current().repository.BlobObject(hash)
+	}
+	if err != nil {
+		return
+	}
+	return blob.Reader()
+}
+
+func (backend *goGitBackend) Close() error {
+	openPacks.untrack(backend.current().storer)
+	return nil
+}
@@ -0,0 +1,256 @@
+package git
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	packFileExtension = ".pack"
+)
+
+// GoGitStorage configures the storage of the clones the go-git backend opens
+type GoGitStorage struct {
+	// KeepDescriptors keeps the pack files of a clone open once read, rather than opening them for every read
+	KeepDescriptors bool
+	// MaxOpenDescriptors keeps up to this many pack files of a clone open, closing the oldest beyond it
+	MaxOpenDescriptors int
+	// ObjectCacheSizeBytes is the size of the cache of decoded objects of each clone, 0 disables it
+	ObjectCacheSizeBytes int64
+	// MaxOpenPackFiles bounds the pack files open across all clones, closing those of the least recently read
+	// clones first. Zero leaves them unbounded.
+	MaxOpenPackFiles int
+}
+
+var (
+	goGitStorage      *GoGitStorage
+	goGitStorageMutex = &sync.RWMutex{}
+	openPacks         = &openPackFiles{
+		storers: list.New(),
+		mutex:   &sync.Mutex{},
+	}
+)
+
+func init() {
+	_ = SetGoGitStorage(&GoGitStorage{
+		ObjectCacheSizeBytes: common.DefaultGitObjectCacheMB * 1024 * 1024,
+	})
+}
+
+// SetGoGitStorage sets the storage of clones opened afterwards, while the bound of pack files open across
+// all clones applies at once
+func SetGoGitStorage(config *GoGitStorage) error {
+	if config.MaxOpenDescriptors < 0 || config.ObjectCacheSizeBytes < 0 || config.MaxOpenPackFiles < 0 {
+		return fmt.Errorf("go-git storage limits can't be negative, got %v descriptors, %v cache bytes and %v pack files",
+			config.MaxOpenDescriptors, config.ObjectCacheSizeBytes, config.MaxOpenPackFiles)
+	}
+	goGitStorageMutex.Lock()
+	goGitStorage = config
+	goGitStorageMutex.Unlock()
+	openPacks.setMax(config.MaxOpenPackFiles)
+	return nil
+}
+
+func currentGoGitStorage() *GoGitStorage {
+	goGitStorageMutex.RLock()
+	defer goGitStorageMutex.RUnlock()
+	return goGitStorage
+}
+
+// OpenPackFiles is the number of pack files open across all clones
+func OpenPackFiles() int {
+	openPacks.mutex.Lock()
+	defer openPacks.mutex.Unlock()
+	return openPacks.open
+}
+
+// openGoGitStorage opens the filesystem storage of a clone under the current config, counting the pack files open through it
+func openGoGitStorage(clonePath string) *lockedStorer {
+	config := currentGoGitStorage()
+	storer := &lockedStorer{
+		clonePath: clonePath,
+		mutex:     &sync.Mutex{},
+	}
+	fs := &packCountingFs{
+		Filesystem: osfs.New(gitDir(clonePath)),
+		storer:     storer,
+	}
+	objects := cache.NewObjectLRU(cache.FileSize(config.ObjectCacheSizeBytes))
+	storer.files = filesystem.NewStorageWithOptions(fs, objects, filesystem.Options{
+		KeepDescriptors:    config.KeepDescriptors,
+		MaxOpenDescriptors: config.MaxOpenDescriptors,
+	})
+	storer.Storer = storer.files
+	return storer
+}
+
+// read marks a storage as the most recently read, then closes the pack files of the least recently read ones over the bound.
+// Storages read after they're untracked close their pack files right away.
+func (storer *lockedStorer) read() {
+	if !openPacks.touch(storer) {
+		storer.release()
+		return
+	}
+	openPacks.releaseOverMax()
+}
+
+// release closes the pack files the storage keeps open, which it opens again on its next read
+func (storer *lockedStorer) release() {
+	storer.mutex.Lock()
+	defer storer.mutex.Unlock()
+	err := storer.files.Close()
+	if err != nil {
+		logger.Info("failed closing pack files of %v: %v", storer.clonePath, err)
+	}
+}
+
+// closeAll closes every pack file open through the storage, including those go-git's object iterators leave open
+// when keeping descriptors, so it must not be read meanwhile
+func (storer *lockedStorer) closeAll() {
+	storer.release()
+	openPacks.mutex.Lock()
+	leaked := make([]*countedFile, 0, len(storer.packs))
+	for file := range storer.packs {
+		leaked = append(leaked, file)
+	}
+	openPacks.mutex.Unlock()
+	for _, file := range leaked {
+		_ = file.Close()
+	}
+}
+
+// openPackFiles counts the pack files open through the storages of all clones, which it orders by when they were last read
+type openPackFiles struct {
+	max  int
+	open int
+	// storers are the tracked storages, the most recently read first
+	storers *list.List
+	mutex   *sync.Mutex
+}
+
+func (packs *openPackFiles) setMax(max int) {
+	packs.mutex.Lock()
+	packs.max = max
+	packs.mutex.Unlock()
+	packs.releaseOverMax()
+}
+
+// track tracks the storage of an opened clone as the most recently read
+func (packs *openPackFiles) track(storer *lockedStorer) {
+	packs.mutex.Lock()
+	defer packs.mutex.Unlock()
+	storer.element = packs.storers.PushFront(storer)
+}
+
+// untrack stops tracking the storage of a clone no longer read, closing its pack files
+func (packs *openPackFiles) untrack(storer *lockedStorer) {
+	packs.mutex.Lock()
+	if storer.element != nil {
+		packs.storers.Remove(storer.element)
+		storer.element = nil
+	}
+	packs.mutex.Unlock()
+	storer.release()
+}
+
+// touch marks a storage as the most recently read, telling whether it's tracked
+func (packs *openPackFiles) touch(storer *lockedStorer) bool {
+	packs.mutex.Lock()
+	defer packs.mutex.Unlock()
+	if storer.element == nil {
+		return false
+	}
+	packs.storers.MoveToFront(storer.element)
+	return true
+}
+
+func (packs *openPackFiles) opened(storer *lockedStorer, file *countedFile) {
+	packs.mutex.Lock()
+	defer packs.mutex.Unlock()
+	if storer.packs == nil {
+		storer.packs = make(map[*countedFile]struct{})
+	}
+	storer.packs[file] = struct{}{}
+	packs.open++
+}
+
+func (packs *openPackFiles) closed(storer *lockedStorer, file *countedFile) {
+	packs.mutex.Lock()
+	defer packs.mutex.Unlock()
+	delete(storer.packs, file)
+	packs.open--
+}
+
+func (packs *openPackFiles) overMax() bool {
+	packs.mutex.Lock()
+	defer packs.mutex.Unlock()
+	return packs.max > 0 && packs.open > packs.max
+}
+
+// releaseOverMax closes the pack files of the least recently read storages while more than the max are open.
+// Pack files in the middle of a read stay open until it's done, so each storage is released at most once.
+func (packs *openPackFiles) releaseOverMax() {
+	if !packs.overMax() {
+		return
+	}
+	packs.mutex.Lock()
+	var candidates []*lockedStorer
+	for element := packs.storers.Back(); element != nil; element = element.Prev() {
+		storer := element.Value.(*lockedStorer)
+		if len(storer.packs) > 0 {
+			candidates = append(candidates, storer)
+		}
+	}
+	packs.mutex.Unlock()
+	for _, storer := range candidates {
+		logger.Debug("releasing the pack files of %v, as too many are open", storer.clonePath)
+		storer.release()
+		if !packs.overMax() {
+			return
+		}
+	}
+}
+
+// packCountingFs is the file system of a clone's storage, counting the pack files open through it
+type packCountingFs struct {
+	billy.Filesystem
+	storer *lockedStorer
+}
+
+func (fs *packCountingFs) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *packCountingFs) OpenFile(filename string, flag int, perm os.FileMode) (file billy.File, err error) {
+	file, err = fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || !strings.HasSuffix(filename, packFileExtension) {
+		return
+	}
+	counted := &countedFile{File: file, storer: fs.storer}
+	openPacks.opened(fs.storer, counted)
+	return counted, nil
+}
+
+// countedFile is a pack file counted as open until first closed
+type countedFile struct {
+	billy.File
+	storer *lockedStorer
+	closed int32
+}
+
+func (file *countedFile) Close() error {
+	if atomic.CompareAndSwapInt32(&file.closed, 0, 1) {
+		openPacks.closed(file.storer, file)
+	}
+	return file.File.Close()
+}
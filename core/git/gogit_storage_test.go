@@ -0,0 +1,105 @@
+package git_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func restoreGoGitStorage() {
+	_ = git.SetGoGitStorage(&git.GoGitStorage{
+		ObjectCacheSizeBytes: common.DefaultGitObjectCacheMB * 1024 * 1024,
+	})
+}
+
+// openPackedClones opens providers of local clones, each with all its objects in a single pack file
+func openPackedClones(t *testing.T, count int) (providers []*git.RepositoryProvider, clonePaths []string, clean func()) {
+	var clonesPaths []string
+	for i := 0; i < count; i++ {
+		clonesPath, _ := testutils.SetupLocalClones()
+		clonesPaths = append(clonesPaths, clonesPath)
+		clonePath := path.Join(clonesPath, testutils.LOCAL_REPO_NAME)
+		testutils.ExecCommandWithDir(clonePath, "git", "repack", "-q", "-a", "-d")
+		provider, err := git.NewRepositoryProvider(clonePath)
+		assert.Nil(t, err)
+		providers = append(providers, provider)
+		clonePaths = append(clonePaths, clonePath)
+	}
+	return providers, clonePaths, func() {
+		for i, provider := range providers {
+			_ = provider.Close()
+			_ = os.RemoveAll(clonesPaths[i])
+		}
+	}
+}
+
+// openPacksOf counts the pack files of each clone the process has open descriptors of
+func openPacksOf(t *testing.T, clonePaths []string) []int {
+	fdDir, err := os.Open("/proc/self/fd")
+	assert.Nil(t, err)
+	defer fdDir.Close()
+	fds, err := fdDir.Readdirnames(-1)
+	assert.Nil(t, err)
+	packs := make([]int, len(clonePaths))
+	for _, fd := range fds {
+		target, _ := os.Readlink(path.Join("/proc/self/fd", fd))
+		for i, clonePath := range clonePaths {
+			if strings.HasPrefix(target, clonePath+"/") && strings.HasSuffix(target, ".pack") {
+				packs[i]++
+			}
+		}
+	}
+	return packs
+}
+
+func readReadme(t *testing.T, provider *git.RepositoryProvider) {
+	contents, err := provider.FileContents("master", "README.md")
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.LocalFiles["README.md"], contents)
+}
+
+func TestPackFilesClosedAfterRead(t *testing.T) {
+	logger.InitLoggers("logs/gogit_storage_test-%v-%v.log", "ERROR", "-")
+	providers, clonePaths, clean := openPackedClones(t, 1)
+	defer clean()
+
+	readReadme(t, providers[0])
+	assert.Equal(t, 0, git.OpenPackFiles())
+	assert.Equal(t, []int{0}, openPacksOf(t, clonePaths))
+}
+
+func TestLeastRecentlyReadReleasePackFiles(t *testing.T) {
+	logger.InitLoggers("logs/gogit_storage_test-%v-%v.log", "ERROR", "-")
+	assert.Nil(t, git.SetGoGitStorage(&git.GoGitStorage{KeepDescriptors: true, MaxOpenPackFiles: 2}))
+	defer restoreGoGitStorage()
+	providers, clonePaths, clean := openPackedClones(t, 3)
+	defer clean()
+	// mapping short shas on opening leaves no pack files open
+	assert.Equal(t, 0, git.OpenPackFiles())
+	assert.Equal(t, []int{0, 0, 0}, openPacksOf(t, clonePaths))
+
+	readReadme(t, providers[0])
+	readReadme(t, providers[1])
+	assert.Equal(t, []int{1, 1, 0}, openPacksOf(t, clonePaths))
+	readReadme(t, providers[2])
+	assert.Equal(t, []int{0, 1, 1}, openPacksOf(t, clonePaths))
+	readReadme(t, providers[1])
+	readReadme(t, providers[0])
+	assert.Equal(t, []int{1, 1, 0}, openPacksOf(t, clonePaths))
+	assert.Equal(t, 2, git.OpenPackFiles())
+
+	assert.Nil(t, providers[0].Backend().Refresh())
+	assert.Equal(t, 1, git.OpenPackFiles())
+	assert.Nil(t, providers[1].Close())
+	assert.Equal(t, 0, git.OpenPackFiles())
+	readReadme(t, providers[0])
+	assert.Equal(t, 1, git.OpenPackFiles())
+
+	assert.NotNil(t, git.SetGoGitStorage(&git.GoGitStorage{MaxOpenPackFiles: -1}))
+}
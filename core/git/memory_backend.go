@@ -0,0 +1,251 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend holds the commits of a repository in memory, so tests can build repositories without disk or network.
+// Blobs and trees have their git shas, but commits don't as they have no author or message.
+type MemoryBackend struct {
+	blobs   map[string]string
+	trees   map[string][]TreeFile
+	history map[string]*CommitInfo
+	refs    map[string]string
+	mutex   *sync.RWMutex
+}
+
+var _ Backend = &MemoryBackend{}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		blobs:   make(map[string]string),
+		trees:   make(map[string][]TreeFile),
+		history: make(map[string]*CommitInfo),
+		refs:    make(map[string]string),
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+// Commit adds a commit of the given contents by file path, pointing the given refs at it.
+// Its parent is the commit the first ref pointed at, as committing on a branch does.
+func (backend *MemoryBackend) Commit(files map[string]string, refs ...string) (sha string) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	tree := make([]TreeFile, len(paths))
+	description := &bytes.Buffer{}
+	// commits of the same files are still distinct
+	fmt.Fprintf(description, "commit %v\n", len(backend.history))
+	for i, filePath := range paths {
+		contents := files[filePath]
+		blobSha := plumbing.ComputeHash(plumbing.BlobObject, []byte(contents)).String()
+		backend.blobs[blobSha] = contents
+		tree[i] = TreeFile{
+			Path: filePath,
+			Sha:  blobSha,
+			Mode: filemode.Regular,
+			Size: int64(len(contents)),
+		}
+		fmt.Fprintf(description, "%v %v\n", blobSha, filePath)
+	}
+	sha = plumbing.ComputeHash(plumbing.CommitObject, description.Bytes()).String()
+	backend.history[sha] = &CommitInfo{
+		Sha:  sha,
+		Tree: backend.addTree(tree),
+		Time: time.Now(),
+	}
+	if len(refs) > 0 {
+		if parent, found := backend.refs[refs[0]]; found {
+			backend.history[sha].Parents = []string{parent}
+		}
+	}
+	for _, ref := range refs {
+		backend.refs[ref] = sha
+	}
+	return
+}
+
+// addTree adds the trees of files by their paths relative to the root tree, returning the sha of the root tree
+func (backend *MemoryBackend) addTree(files []TreeFile) (sha string) {
+	var entries []TreeFile
+	var dirNames []string
+	filesByDir := make(map[string][]TreeFile)
+	for _, file := range files {
+		slash := strings.Index(file.Path, "/")
+		if slash < 0 {
+			entries = append(entries, file)
+			continue
+		}
+		dirName := file.Path[:slash]
+		if _, found := filesByDir[dirName]; !found {
+			dirNames = append(dirNames, dirName)
+		}
+		file.Path = file.Path[slash+1:]
+		filesByDir[dirName] = append(filesByDir[dirName], file)
+	}
+	for _, dirName := range dirNames {
+		entries = append(entries, TreeFile{
+			Path: dirName,
+			Sha:  backend.addTree(filesByDir[dirName]),
+			Mode: filemode.Dir,
+		})
+	}
+	// git sorts the entries of a tree as if directory names end with a slash
+	sortKey := func(entry TreeFile) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Path + "/"
+		}
+		return entry.Path
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+
+	tree := &object.Tree{}
+	for _, entry := range entries {
+		tree.Entries = append(tree.Entries, object.TreeEntry{
+			Name: entry.Path,
+			Mode: entry.Mode,
+			Hash: plumbing.NewHash(entry.Sha),
+		})
+	}
+	encoded := &plumbing.MemoryObject{}
+	err := tree.Encode(encoded)
+	if err != nil {
+		panic(err)
+	}
+	sha = encoded.Hash().String()
+	backend.trees[sha] = entries
+	return
+}
+
+// SetRef points a branch or a tag at a commit
+func (backend *MemoryBackend) SetRef(name string, sha string) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.refs[name] = sha
+}
+
+func (backend *MemoryBackend) ResolveRevision(revision string) (sha string, err error) {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+
+	for _, name := range []string{revision, "refs/heads/" + revision, "refs/tags/" + revision} {
+		sha, found := backend.refs[name]
+		if found {
+			return sha, nil
+		}
+	}
+	if len(revision) >= ShortShaLength {
+		var matches []string
+		for commitSha := range backend.history {
+			if strings.HasPrefix(commitSha, revision) {
+				matches = append(matches, commitSha)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+	return "", fmt.Errorf("%w: %v", ErrRevisionNotFound, revision)
+}
+
+func (backend *MemoryBackend) ReadCommit(sha string) (commit *CommitInfo, err error) {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+	commit, found := backend.history[sha]
+	if !found {
+		return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, sha)
+	}
+	return commit, nil
+}
+
+func (backend *MemoryBackend) StatObject(object string) (info *ObjectInfo, err error) {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+
+	separator := strings.Index(object, ":")
+	if separator >= 0 {
+		info = backend.statPath(object[:separator], object[separator+1:])
+		if info != nil {
+			return info, nil
+		}
+	} else if contents, found := backend.blobs[object]; found {
+		return &ObjectInfo{Sha: object, Type: plumbing.BlobObject.String(), Size: int64(len(contents))}, nil
+	} else if _, found = backend.history[object]; found {
+		return &ObjectInfo{Sha: object, Type: plumbing.CommitObject.String()}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, object)
+}
+
+// statPath describes the object at a path of a commit by walking its trees, or returns nil if there's none
+func (backend *MemoryBackend) statPath(commitSha string, objectPath string) *ObjectInfo {
+	commit, found := backend.history[commitSha]
+	if !found {
+		return nil
+	}
+	info := &ObjectInfo{Sha: commit.Tree, Type: plumbing.TreeObject.String()}
+	for _, name := range strings.Split(objectPath, "/") {
+		if info.Type != plumbing.TreeObject.String() {
+			return nil
+		}
+		var entry *TreeFile
+		for i := range backend.trees[info.Sha] {
+			if backend.trees[info.Sha][i].Path == name {
+				entry = &backend.trees[info.Sha][i]
+			}
+		}
+		if entry == nil {
+			return nil
+		}
+		info = &ObjectInfo{Sha: entry.Sha, Type: plumbing.BlobObject.String(), Size: entry.Size}
+		if entry.Mode == filemode.Dir {
+			info = &ObjectInfo{Sha: entry.Sha, Type: plumbing.TreeObject.String()}
+		}
+	}
+	return info
+}
+
+func (backend *MemoryBackend) ReadTree(treeSha string) (entries []TreeFile, err error) {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+	entries, found := backend.trees[treeSha]
+	if !found {
+		return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, treeSha)
+	}
+	return entries, nil
+}
+
+func (backend *MemoryBackend) OpenBlob(sha string) (reader io.ReadCloser, err error) {
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+	contents, found := backend.blobs[sha]
+	if !found {
+		return nil, fmt.Errorf("%w: %v", ErrObjectNotFound, sha)
+	}
+	return ioutil.NopCloser(strings.NewReader(contents)), nil
+}
+
+func (backend *MemoryBackend) Refresh() error {
+	return nil
+}
+
+func (backend *MemoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package git
+
+import (
+	"errors"
+	"gitreefs/core/common"
+)
+
+// Configure applies the shared options of the git layer, before any repository is opened
+func Configure(opts *common.SharedOptions) (err error) {
+	SetMaxFileSizeMB(opts.MaxFileSizeMB)
+	err = SetBackend(opts.GitBackend)
+	if err != nil {
+		return
+	}
+	if opts.FetchOnMiss {
+		SetFetchOnMiss(&FetchOnMiss{
+			MinInterval: opts.FetchMinInterval,
+			Timeout:     opts.FetchTimeout,
+		})
+	} else {
+		SetFetchOnMiss(nil)
+	}
+	if opts.FetchMissingBlobs {
+		SetFetchMissingBlobs(&FetchMissingBlobs{
+			Timeout: opts.FetchTimeout,
+		})
+	} else {
+		SetFetchMissingBlobs(nil)
+	}
+	err = SetWorkerPool(&WorkerPool{
+		MaxConcurrent:              opts.MaxConcurrentGitWork,
+		MaxConcurrentPerRepository: opts.MaxConcurrentRepositoryGitWork,
+	})
+	if err != nil {
+		return
+	}
+	err = configureBlobCache(opts)
+	if err != nil {
+		return
+	}
+	err = SetGoGitStorage(&GoGitStorage{
+		KeepDescriptors:      opts.GitKeepDescriptors,
+		MaxOpenDescriptors:   opts.GitMaxOpenDescriptors,
+		ObjectCacheSizeBytes: opts.GitObjectCacheMB * 1024 * 1024,
+		MaxOpenPackFiles:     opts.MaxOpenPackFiles,
+	})
+	if err != nil {
+		return
+	}
+	if len(opts.CloneURLTemplate) == 0 {
+		return SetAutoClone(nil)
+	}
+	return SetAutoClone(&AutoClone{
+		URLTemplate:   opts.CloneURLTemplate,
+		MaxConcurrent: opts.MaxConcurrentClones,
+		Timeout:       opts.CloneTimeout,
+		Wait:          !opts.CloneNoWait,
+	})
+}
+
+func configureBlobCache(opts *common.SharedOptions) (err error) {
+	if opts.BlobCacheSizeMB == 0 {
+		if opts.ReadAheadMaxBlobKB > 0 {
+			return errors.New("read-ahead requires a blob cache size")
+		}
+		return SetBlobCache(nil)
+	}
+	err = SetBlobCache(&BlobCache{
+		MaxSizeBytes: opts.BlobCacheSizeMB * 1024 * 1024,
+	})
+	if err != nil {
+		return
+	}
+	if opts.ReadAheadMaxBlobKB == 0 {
+		return SetReadAhead(nil)
+	}
+	return SetReadAhead(&ReadAhead{
+		MaxBlobSizeBytes: opts.ReadAheadMaxBlobKB * 1024,
+	})
+}
@@ -0,0 +1,189 @@
+package git_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/bfs"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// partialTestSuite serves shallow and partial clones of a local bare remote, which allows filtering their objects
+type partialTestSuite struct {
+	suite.Suite
+	remotesPath string
+	remotePath  string
+	clonesPath  string
+	commits     []string
+}
+
+func TestPartialTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/partial_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(partialTestSuite))
+}
+
+func (partialSuite *partialTestSuite) SetupTest() {
+	partialSuite.remotesPath, partialSuite.commits = testutils.SetupLocalClones()
+	partialSuite.remotePath = path.Join(partialSuite.remotesPath, "remote.git")
+	testutils.ExecCommandWithDir(partialSuite.remotesPath, "git", "clone", "--quiet", "--bare", testutils.LOCAL_REPO_NAME, partialSuite.remotePath)
+	testutils.ExecCommandWithDir(partialSuite.remotePath, "git", "config", "uploadpack.allowFilter", "true")
+	testutils.ExecCommandWithDir(partialSuite.remotePath, "git", "config", "uploadpack.allowAnySHA1InWant", "true")
+
+	var err error
+	partialSuite.clonesPath, err = ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (partialSuite *partialTestSuite) TearDownTest() {
+	git.SetFetchMissingBlobs(nil)
+	git.SetBackend(git.GoGitBackendName)
+	os.RemoveAll(partialSuite.remotesPath)
+	os.RemoveAll(partialSuite.clonesPath)
+}
+
+// clone clones the remote with the given options, returning a provider of the clone
+func (partialSuite *partialTestSuite) clone(options ...string) *git.RepositoryProvider {
+	args := append([]string{"clone", "--quiet", "--no-checkout"}, options...)
+	args = append(args, "file://"+partialSuite.remotePath, testutils.LOCAL_REPO_NAME)
+	testutils.ExecCommandWithDir(partialSuite.clonesPath, "git", args...)
+	provider, err := git.NewRepositoryProvider(partialSuite.clonePath())
+	if err != nil {
+		panic(err)
+	}
+	return provider
+}
+
+func (partialSuite *partialTestSuite) clonePath() string {
+	return path.Join(partialSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+}
+
+func (partialSuite *partialTestSuite) TestDetectClone() {
+	partialSuite.clone("--filter=blob:none").Close()
+	info, err := git.DetectClone(partialSuite.clonePath())
+	partialSuite.Nil(err)
+	partialSuite.True(info.IsPartial())
+	partialSuite.Equal("origin", info.PromisorRemote)
+	partialSuite.False(info.IsShallow())
+
+	info, err = git.DetectClone(path.Join(partialSuite.remotesPath, testutils.LOCAL_REPO_NAME))
+	partialSuite.Nil(err)
+	partialSuite.False(info.IsPartial())
+	partialSuite.False(info.IsShallow())
+}
+
+func (partialSuite *partialTestSuite) TestMissingBlob() {
+	provider := partialSuite.clone("--filter=blob:none")
+	defer provider.Close()
+
+	root, err := provider.ListTree("master")
+	partialSuite.Nil(err)
+	partialSuite.True(find(root, "src/main.go").IsMissing())
+	partialSuite.EqualValues(0, find(root, "src/main.go").Size())
+	partialSuite.True(find(root, "docs/guide.md").IsMissing())
+
+	_, err = provider.FileContents("master", "src/main.go")
+	partialSuite.True(errors.Is(err, git.ErrBlobMissing), err)
+	partialSuite.True(errors.Is(err, syscall.ENODATA), err)
+}
+
+func (partialSuite *partialTestSuite) TestFetchMissingBlobs() {
+	provider := partialSuite.clone("--filter=blob:none")
+	defer provider.Close()
+	git.SetFetchMissingBlobs(&git.FetchMissingBlobs{Timeout: time.Minute})
+
+	// listing doesn't fetch the blobs of the tree, only reading them does
+	root, err := provider.ListTree("master")
+	partialSuite.Nil(err)
+	partialSuite.True(find(root, "src/main.go").IsMissing())
+	partialSuite.True(find(root, "docs/guide.md").IsMissing())
+
+	contents, err := provider.FileContents("master", "src/main.go")
+	partialSuite.Nil(err)
+	partialSuite.Equal(testutils.LocalFiles["src/main.go"], contents)
+	contents, err = provider.FileContents("v1", "README.md")
+	partialSuite.Nil(err)
+	partialSuite.Equal(testutils.LocalFiles["README.md"], contents)
+}
+
+func (partialSuite *partialTestSuite) TestReadMissingBlobs() {
+	partialSuite.clone("--filter=blob:none").Close()
+	fs, err := bfs.NewGitFileSystem(partialSuite.clonesPath)
+	partialSuite.Nil(err)
+	filePath := path.Join(testutils.LOCAL_REPO_NAME, "master", "src", "main.go")
+
+	infos, err := fs.ReadDir(path.Join(testutils.LOCAL_REPO_NAME, "master", "src"))
+	partialSuite.Nil(err)
+	partialSuite.Equal("main.go", infos[0].Name())
+	partialSuite.EqualValues(0, infos[0].Size())
+	// reading a missing file fails rather than reading as empty by its listed size
+	file, err := fs.Open(filePath)
+	partialSuite.Nil(err)
+	_, err = ioutil.ReadAll(file)
+	partialSuite.True(errors.Is(err, syscall.ENODATA), err)
+	_, err = fs.Stat(filePath)
+	partialSuite.True(errors.Is(err, syscall.ENODATA), err)
+
+	git.SetFetchMissingBlobs(&git.FetchMissingBlobs{Timeout: time.Minute})
+	info, err := fs.Stat(filePath)
+	partialSuite.Nil(err)
+	partialSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size())
+	file, err = fs.Open(filePath)
+	partialSuite.Nil(err)
+	contents, err := ioutil.ReadAll(file)
+	partialSuite.Nil(err)
+	partialSuite.Equal(testutils.LocalFiles["src/main.go"], string(contents))
+}
+
+func (partialSuite *partialTestSuite) TestCatFileBackend() {
+	partialSuite.Nil(git.SetBackend(git.CatFileBackendName))
+	provider := partialSuite.clone("--filter=blob:none")
+	defer provider.Close()
+
+	root, err := provider.ListTree("master")
+	partialSuite.Nil(err)
+	partialSuite.True(find(root, "src/main.go").IsMissing())
+	// cat-file doesn't fetch missing blobs lazily unless fetching is enabled
+	_, err = provider.FileContents("master", "src/main.go")
+	partialSuite.True(errors.Is(err, git.ErrBlobMissing), err)
+	partialSuite.True(errors.Is(err, syscall.ENODATA), err)
+	_, err = provider.FileContents("master", "src/pkg/util.go")
+	partialSuite.True(errors.Is(err, syscall.ENODATA), err)
+	_, err = provider.ResolveCommit(partialSuite.commits[1])
+	partialSuite.Nil(err)
+
+	git.SetFetchMissingBlobs(&git.FetchMissingBlobs{Timeout: time.Minute})
+	contents, err := provider.FileContents("master", "src/main.go")
+	partialSuite.Nil(err)
+	partialSuite.Equal(testutils.LocalFiles["src/main.go"], contents)
+}
+
+func (partialSuite *partialTestSuite) TestShallowClone() {
+	provider := partialSuite.clone("--depth", "1")
+	defer provider.Close()
+	info, err := git.DetectClone(partialSuite.clonePath())
+	partialSuite.Nil(err)
+	partialSuite.Equal([]string{partialSuite.commits[1]}, info.ShallowCommits)
+
+	sha, err := provider.ResolveCommit("master")
+	partialSuite.Nil(err)
+	partialSuite.Equal(partialSuite.commits[1], sha)
+	contents, err := provider.FileContents("master", "docs/guide.md")
+	partialSuite.Nil(err)
+	partialSuite.Equal(testutils.LocalSecondCommitFiles["docs/guide.md"], contents)
+
+	_, err = provider.ResolveCommit("master~1")
+	partialSuite.True(errors.Is(err, git.ErrBeyondShallowBoundary), err)
+	partialSuite.True(errors.Is(err, git.ErrRevisionNotFound), err)
+	canResolve, err := provider.CanResolve("master^")
+	partialSuite.Nil(err)
+	partialSuite.False(canResolve)
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"fmt"
+	"gitreefs/core/common"
+	"sync"
+)
+
+// Priority orders git work waiting for a worker, work of a higher priority is run first
+type Priority int
+
+const (
+	// InteractivePriority is the priority of lookups and reads a client waits for
+	InteractivePriority Priority = iota
+	// PrefetchPriority is the priority of background work no client waits for, run when no interactive work waits
+	PrefetchPriority
+
+	prioritiesCount
+)
+
+var (
+	workers      *workerPool
+	workersMutex = &sync.RWMutex{}
+)
+
+func init() {
+	_ = SetWorkerPool(&WorkerPool{
+		MaxConcurrent:              common.DefaultMaxConcurrentGitWork,
+		MaxConcurrentPerRepository: common.DefaultMaxConcurrentRepositoryGitWork,
+	})
+}
+
+// WorkerPool bounds the git work running at once, reading trees and blobs, across all repositories and per repository.
+// Zero limits leave the work unbounded.
+type WorkerPool struct {
+	MaxConcurrent              int
+	MaxConcurrentPerRepository int
+}
+
+type workerPool struct {
+	config *WorkerPool
+	global *limiter
+}
+
+// SetWorkerPool sets the limits of git work, the per repository limit applies to repositories opened afterwards
+func SetWorkerPool(config *WorkerPool) error {
+	if config.MaxConcurrent < 0 || config.MaxConcurrentPerRepository < 0 {
+		return fmt.Errorf("concurrent git work limits can't be negative, got %v and %v per repository",
+			config.MaxConcurrent, config.MaxConcurrentPerRepository)
+	}
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	workers = &workerPool{
+		config: config,
+		global: newLimiter(config.MaxConcurrent),
+	}
+	return nil
+}
+
+func currentWorkers() *workerPool {
+	workersMutex.RLock()
+	defer workersMutex.RUnlock()
+	return workers
+}
+
+// newRepositoryLimiter returns the limiter of a repository opened under the current limits
+func newRepositoryLimiter() *limiter {
+	return newLimiter(currentWorkers().config.MaxConcurrentPerRepository)
+}
+
+// limiter is a counting semaphore granting its slots to waiters by priority, and by order of arrival within a priority.
+// A nil limiter doesn't limit.
+type limiter struct {
+	max     int
+	running int
+	waiting [prioritiesCount][]chan struct{}
+	mutex   *sync.Mutex
+}
+
+func newLimiter(max int) *limiter {
+	if max == 0 {
+		return nil
+	}
+	return &limiter{
+		max:   max,
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (limiter *limiter) acquire(priority Priority) {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	if limiter.running < limiter.max {
+		limiter.running++
+		limiter.mutex.Unlock()
+		return
+	}
+	granted := make(chan struct{})
+	limiter.waiting[priority] = append(limiter.waiting[priority], granted)
+	limiter.mutex.Unlock()
+	<-granted
+}
+
+// release hands the slot over to the first waiter of the highest priority, if there is any
+func (limiter *limiter) release() {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for priority, waiting := range limiter.waiting {
+		if len(waiting) > 0 {
+			limiter.waiting[priority] = waiting[1:]
+			close(waiting[0])
+			return
+		}
+	}
+	limiter.running--
+}
+
+// work runs git work of a repository once both the repository and the global limits allow it.
+// Work must not wait for other work while running, or it may hold the slots that work waits for.
+func (provider *RepositoryProvider) work(priority Priority, op func()) {
+	global := currentWorkers().global
+	provider.workers.acquire(priority)
+	defer provider.workers.release()
+	global.acquire(priority)
+	defer global.release()
+	op()
+}
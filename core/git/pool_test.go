@@ -0,0 +1,187 @@
+package git
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until a limiter has a number of waiters of a priority
+func waitForWaiters(limiter *limiter, priority Priority, count int) {
+	for {
+		limiter.mutex.Lock()
+		waiting := len(limiter.waiting[priority])
+		limiter.mutex.Unlock()
+		if waiting == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	limiter := newLimiter(1)
+	limiter.acquire(InteractivePriority)
+
+	order := make(chan Priority, 3)
+	acquire := func(priority Priority) {
+		limiter.acquire(priority)
+		order <- priority
+		limiter.release()
+	}
+	go acquire(PrefetchPriority)
+	waitForWaiters(limiter, PrefetchPriority, 1)
+	go acquire(InteractivePriority)
+	waitForWaiters(limiter, InteractivePriority, 1)
+	go acquire(PrefetchPriority)
+	waitForWaiters(limiter, PrefetchPriority, 2)
+
+	limiter.release()
+	assert.Equal(t, InteractivePriority, <-order)
+	assert.Equal(t, PrefetchPriority, <-order)
+	assert.Equal(t, PrefetchPriority, <-order)
+	limiter.acquire(InteractivePriority)
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	assert.Equal(t, 1, limiter.running)
+}
+
+func TestLimiterMax(t *testing.T) {
+	limiter := newLimiter(3)
+	running, maxRunning := 0, 0
+	mutex := &sync.Mutex{}
+	waitGroup := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			limiter.acquire(PrefetchPriority)
+			defer limiter.release()
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+	}
+	waitGroup.Wait()
+	assert.Equal(t, 3, maxRunning)
+	assert.Nil(t, newLimiter(0))
+}
+
+func TestListTreeWithSingleWorker(t *testing.T) {
+	logger.InitLoggers("logs/pool_test-%v-%v.log", "ERROR", "-")
+	assert.Nil(t, SetWorkerPool(&WorkerPool{MaxConcurrent: 1, MaxConcurrentPerRepository: 1}))
+	defer SetWorkerPool(&WorkerPool{
+		MaxConcurrent:              common.DefaultMaxConcurrentGitWork,
+		MaxConcurrentPerRepository: common.DefaultMaxConcurrentRepositoryGitWork,
+	})
+
+	backend := NewMemoryBackend()
+	backend.Commit(map[string]string{
+		"a/b/c/file.txt": "c",
+		"a/b/file.txt":   "b",
+		"a/d/file.txt":   "d",
+		"e/file.txt":     "e",
+		"file.txt":       "root",
+	}, "master")
+	provider := NewBackendProvider(backend)
+
+	root, err := provider.ListTreeWithPriority("master", PrefetchPriority)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, root.Count())
+	contents, err := provider.FileContents("master", "a/b/c/file.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "c", contents)
+
+	assert.NotNil(t, SetWorkerPool(&WorkerPool{MaxConcurrent: -1}))
+}
+
+// countingBackend counts the reads of each tree, which take a while so concurrent builds overlap
+type countingBackend struct {
+	*MemoryBackend
+	reads map[string]int
+	mutex *sync.Mutex
+}
+
+func (backend *countingBackend) ReadTree(treeSha string) (entries []TreeFile, err error) {
+	backend.mutex.Lock()
+	backend.reads[treeSha]++
+	backend.mutex.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	return backend.MemoryBackend.ReadTree(treeSha)
+}
+
+func TestConcurrentBuildsOfSameTree(t *testing.T) {
+	logger.InitLoggers("logs/pool_test-%v-%v.log", "ERROR", "-")
+	backend := &countingBackend{MemoryBackend: NewMemoryBackend(), reads: map[string]int{}, mutex: &sync.Mutex{}}
+	backend.Commit(map[string]string{
+		"a/b/file.txt": "b",
+		"a/file.txt":   "a",
+		"file.txt":     "root",
+	}, "master")
+	provider := NewBackendProvider(backend)
+
+	roots := make([]*Entry, 10)
+	waitGroup := &sync.WaitGroup{}
+	for i := range roots {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			var err error
+			roots[i], err = provider.ListTree("master")
+			assert.Nil(t, err)
+		}(i)
+	}
+	waitGroup.Wait()
+	for _, root := range roots {
+		assert.Same(t, roots[0], root)
+	}
+	assert.Len(t, backend.reads, 3)
+	for treeSha, reads := range backend.reads {
+		assert.Equal(t, 1, reads, treeSha)
+	}
+	assert.Equal(t, 0, provider.pendingTrees.Count())
+}
+
+func TestListTreeWithoutFreeSubtreeBuilders(t *testing.T) {
+	logger.InitLoggers("logs/pool_test-%v-%v.log", "ERROR", "-")
+	backend := NewMemoryBackend()
+	backend.Commit(map[string]string{
+		"a/b/c/file.txt": "c",
+		"a/d/file.txt":   "d",
+		"file.txt":       "root",
+	}, "master")
+	provider := NewBackendProvider(backend)
+	// subtrees are built in turn when every builder is busy
+	provider.subtreeBuilders = make(chan struct{})
+	provider.treeBySha = newInterner(1)
+
+	root, err := provider.ListTree("master")
+	assert.Nil(t, err)
+	assert.Equal(t, 8, root.Count())
+	_, found := provider.treeBySha.get(backend.history[backend.refs["master"]].Tree)
+	assert.True(t, found)
+	_, found = provider.names.get("file.txt")
+	assert.True(t, found)
+}
+
+func TestInternerBound(t *testing.T) {
+	interner := newInterner(2)
+	assert.Equal(t, "a", interner.internName("a"))
+	assert.Equal(t, "b", interner.internName("b"))
+	interner.internName("a")
+	interner.internName("c")
+	_, found := interner.get("b")
+	assert.False(t, found)
+	_, found = interner.get("a")
+	assert.True(t, found)
+}
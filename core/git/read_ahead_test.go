@@ -0,0 +1,30 @@
+package git
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gitreefs/core/logger"
+	"testing"
+)
+
+func TestReadAheadQueueDropsWhenFull(t *testing.T) {
+	logger.InitLoggers("logs/read_ahead_test-%v-%v.log", "ERROR", "-")
+	assert.Nil(t, SetBlobCache(&BlobCache{MaxSizeBytes: 1024}))
+	defer SetBlobCache(nil)
+	assert.Nil(t, SetReadAhead(&ReadAhead{MaxBlobSizeBytes: 1024}))
+
+	backend := NewMemoryBackend()
+	backend.Commit(map[string]string{"a/file.txt": "a", "b/file.txt": "b"}, "master")
+	provider := NewBackendProvider(backend)
+	// the workers read the shared queue, so a queue of the provider's own fills up
+	provider.readAheadQueue = make(chan *readAheadDir, 1)
+	provider.ReadAhead("master", "a")
+	provider.ReadAhead("master", "a")
+	provider.ReadAhead("master", "b")
+	assert.Len(t, provider.readAheadQueue, 1)
+	assert.Equal(t, "a", (<-provider.readAheadQueue).dirPath)
+	// a dropped directory is read ahead once listed again
+	_, found := provider.readAheadDirs.get("master:b")
+	assert.False(t, found)
+	provider.ReadAhead("master", "b")
+	assert.Equal(t, "b", (<-provider.readAheadQueue).dirPath)
+}
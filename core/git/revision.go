@@ -0,0 +1,353 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SelectorKind is the kind of a selector following the ref or sha of a revision
+type SelectorKind int
+
+const (
+	// AncestorSelector is ~N, the Nth first-parent ancestor
+	AncestorSelector SelectorKind = iota
+	// ParentSelector is ^N, the Nth parent, with ^0 being the commit itself
+	ParentSelector
+	// PeelSelector is ^{commit} or ^{}, peeling a tag to its commit
+	PeelSelector
+	// ReflogSelector is @{N}, the Nth prior value of the ref
+	ReflogSelector
+	// DateSelector is @{date}, the value of the ref at a date
+	DateSelector
+)
+
+var (
+	// ErrInvalidRevision is returned for commitishes not matching the revision grammar, which therefore aren't found
+	ErrInvalidRevision = fmt.Errorf("%w: invalid revision", ErrRevisionNotFound)
+
+	relativeDatePattern = regexp.MustCompile(`^(\d+)[. ](second|minute|hour|day|week|month|year)s?[. ]ago$`)
+	relativeDateUnits   = map[string]time.Duration{
+		"second": time.Second,
+		"minute": time.Minute,
+		"hour":   time.Hour,
+		"day":    24 * time.Hour,
+		"week":   7 * 24 * time.Hour,
+		"month":  30 * 24 * time.Hour,
+		"year":   365 * 24 * time.Hour,
+	}
+	absoluteDateLayouts = []string{
+		"2006-01-02",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+	}
+)
+
+// Selector selects a commit relative to the one before it in a revision
+type Selector struct {
+	Kind SelectorKind
+	// N is the count of ~N, ^N and @{N}
+	N    int
+	Date time.Time
+}
+
+// Revision is a commitish parsed by the grammar:
+//
+//	revision := base selector* [":" path]
+//	base     := ref | sha | "@"
+//	selector := "~" [N] | "^" [N] | "^{commit}" | "^{}" | "@{" N "}" | "@{" date "}"
+//
+// where @{...} only follows the base directly, and date is YYYY-MM-DD, YYYY-MM-DDTHH:MM:SS, YYYY-MM-DD HH:MM:SS,
+// RFC 3339 or N.unit.ago, e.g. 2.weeks.ago. The path of a subtree makes it the root of the revision.
+type Revision struct {
+	Base      string
+	Selectors []Selector
+	Path      string
+}
+
+// ParseRevision parses a commitish by the revision grammar, or fails with ErrInvalidRevision
+func ParseRevision(commitish string) (revision *Revision, err error) {
+	return parseRevision(commitish, time.Now())
+}
+
+func parseRevision(commitish string, now time.Time) (revision *Revision, err error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w '%v': %v", ErrInvalidRevision, commitish, reason)
+	}
+	revision = &Revision{}
+
+	rest := commitish
+	depth := 0
+	for i, char := range commitish {
+		if char == '{' {
+			depth++
+		} else if char == '}' {
+			depth--
+		} else if char == ':' && depth == 0 {
+			rest = commitish[:i]
+			revision.Path, err = cleanRevisionPath(commitish[i+1:])
+			if err != nil {
+				return nil, invalid(err.Error())
+			}
+			break
+		}
+	}
+
+	baseEnd := len(rest)
+	for i := range rest {
+		if rest[i] == '~' || rest[i] == '^' || strings.HasPrefix(rest[i:], "@{") {
+			baseEnd = i
+			break
+		}
+	}
+	revision.Base = rest[:baseEnd]
+	rest = rest[baseEnd:]
+	if revision.Base == "@" || (len(revision.Base) == 0 && strings.HasPrefix(rest, "@{")) {
+		revision.Base = "HEAD"
+	}
+	if !isValidBase(revision.Base) {
+		return nil, invalid("invalid ref or sha")
+	}
+
+	for len(rest) > 0 {
+		var selector Selector
+		switch {
+		case strings.HasPrefix(rest, "@{") || strings.HasPrefix(rest, "^{"):
+			closing := strings.Index(rest, "}")
+			if closing < 0 {
+				return nil, invalid("unclosed brace")
+			}
+			content := rest[2:closing]
+			if rest[0] == '^' {
+				if content != "commit" && content != "" {
+					return nil, invalid("only commits can be peeled to")
+				}
+				selector.Kind = PeelSelector
+			} else if len(revision.Selectors) > 0 {
+				return nil, invalid("@{...} must follow the ref")
+			} else if n, convErr := strconv.Atoi(content); convErr == nil && n >= 0 {
+				selector.Kind = ReflogSelector
+				selector.N = n
+			} else {
+				selector.Kind = DateSelector
+				selector.Date, err = parseRevisionDate(content, now)
+				if err != nil {
+					return nil, invalid(err.Error())
+				}
+			}
+			rest = rest[closing+1:]
+		case rest[0] == '~' || rest[0] == '^':
+			selector.Kind = AncestorSelector
+			if rest[0] == '^' {
+				selector.Kind = ParentSelector
+			}
+			digits := 1
+			for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+				digits++
+			}
+			selector.N = 1
+			if digits > 1 {
+				selector.N, err = strconv.Atoi(rest[1:digits])
+				if err != nil {
+					return nil, invalid(err.Error())
+				}
+			}
+			rest = rest[digits:]
+		default:
+			return nil, invalid(fmt.Sprintf("unexpected '%v'", rest))
+		}
+		revision.Selectors = append(revision.Selectors, selector)
+	}
+	return revision, nil
+}
+
+// isValidBase tells whether a ref or sha could be the base of a revision, following the format of ref names loosely
+func isValidBase(base string) bool {
+	if len(base) == 0 || strings.Contains(base, "..") || strings.HasPrefix(base, "/") || strings.HasSuffix(base, "/") {
+		return false
+	}
+	for _, char := range base {
+		if char <= ' ' || char == 0x7f || strings.ContainsRune("~^:?*[\\{}", char) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanRevisionPath cleans the path of a subtree, relative to the root of its commit
+func cleanRevisionPath(treePath string) (string, error) {
+	treePath = strings.Trim(treePath, "/")
+	if len(treePath) == 0 {
+		return RootEntryPath, nil
+	}
+	for _, component := range strings.Split(treePath, "/") {
+		if component == "." || component == ".." {
+			return "", fmt.Errorf("path '%v' must not contain %v", treePath, component)
+		}
+	}
+	return path.Clean(treePath), nil
+}
+
+func parseRevisionDate(text string, now time.Time) (date time.Time, err error) {
+	if match := relativeDatePattern.FindStringSubmatch(text); match != nil {
+		var count int
+		count, err = strconv.Atoi(match[1])
+		if err != nil {
+			return
+		}
+		return now.Add(-time.Duration(count) * relativeDateUnits[match[2]]), nil
+	}
+	date, err = time.Parse(time.RFC3339, text)
+	if err == nil {
+		return
+	}
+	for _, layout := range absoluteDateLayouts {
+		date, err = time.ParseInLocation(layout, text, time.Local)
+		if err == nil {
+			return
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date '%v'", text)
+}
+
+// resolveRevision resolves a parsed revision to the sha of its commit
+func (provider *RepositoryProvider) resolveRevision(revision *Revision) (sha string, err error) {
+	sha, err = provider.resolveFetching(revision.Base)
+	if err != nil {
+		return
+	}
+	for _, selector := range revision.Selectors {
+		switch selector.Kind {
+		case AncestorSelector:
+			for n := 0; n < selector.N && err == nil; n++ {
+				sha, err = provider.parent(sha, 1)
+			}
+		case ParentSelector:
+			sha, err = provider.parent(sha, selector.N)
+		case PeelSelector:
+			// tags are always peeled to their commits
+		case ReflogSelector, DateSelector:
+			sha, err = provider.resolveReflog(revision.Base, sha, selector)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parent returns the nth parent of a commit, the commit itself being its 0th
+func (provider *RepositoryProvider) parent(sha string, n int) (string, error) {
+	if n == 0 {
+		return sha, nil
+	}
+	if provider.info.IsShallowCommit(sha) {
+		return "", fmt.Errorf("%w: parents of %v", ErrBeyondShallowBoundary, sha)
+	}
+	commit, err := provider.backend.ReadCommit(sha)
+	if err != nil {
+		return "", err
+	}
+	if n > len(commit.Parents) {
+		return "", fmt.Errorf("%w: %v has %v parents, not %v", ErrRevisionNotFound, sha, len(commit.Parents), n)
+	}
+	return commit.Parents[n-1], nil
+}
+
+// firstParentAt returns the first commit in the first-parent history of a commit which was committed by a date
+func (provider *RepositoryProvider) firstParentAt(sha string, date time.Time) (string, error) {
+	for {
+		commit, err := provider.backend.ReadCommit(sha)
+		if err != nil {
+			return "", err
+		}
+		if !commit.Time.After(date) {
+			return sha, nil
+		}
+		sha, err = provider.parent(sha, 1)
+		if err != nil {
+			return "", fmt.Errorf("no commit by %v: %w", date, err)
+		}
+	}
+}
+
+// resolveReflog selects a prior value of a ref by its reflog. Without a reflog, a date selects from the history of
+// the ref's commit, as it does when the date precedes the reflog.
+func (provider *RepositoryProvider) resolveReflog(ref string, sha string, selector Selector) (string, error) {
+	entries, err := readReflog(provider.clonePath, ref)
+	if err != nil {
+		return "", err
+	}
+	if selector.Kind == ReflogSelector {
+		if selector.N == 0 && len(entries) == 0 {
+			return sha, nil
+		}
+		if selector.N >= len(entries) {
+			return "", fmt.Errorf("%w: the reflog of %v has %v entries", ErrRevisionNotFound, ref, len(entries))
+		}
+		return entries[len(entries)-1-selector.N].sha, nil
+	}
+	if len(entries) == 0 {
+		return provider.firstParentAt(sha, selector.Date)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].time.After(selector.Date) {
+			return entries[i].sha, nil
+		}
+	}
+	return provider.firstParentAt(entries[0].sha, selector.Date)
+}
+
+// reflogEntry is a value a ref was set to, and when
+type reflogEntry struct {
+	sha  string
+	time time.Time
+}
+
+// readReflog reads the reflog of a ref in a clone from oldest to newest, looking it up as git does for ref names
+func readReflog(clonePath string, ref string) (entries []reflogEntry, err error) {
+	if len(clonePath) == 0 {
+		return nil, nil
+	}
+	logsPath := filepath.Join(gitDir(clonePath), "logs")
+	var contents []byte
+	for _, name := range []string{ref, "refs/" + ref, "refs/tags/" + ref, "refs/heads/" + ref, "refs/remotes/" + ref, "refs/remotes/" + ref + "/HEAD"} {
+		contents, err = ioutil.ReadFile(filepath.Join(logsPath, filepath.FromSlash(name)))
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) && !errors.Is(err, syscall.EISDIR) {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, nil
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		// <old sha> <new sha> <name> <<email>> <unix time> <zone>\t<message>
+		if tab := strings.Index(line, "\t"); tab >= 0 {
+			line = line[:tab]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		seconds, parseErr := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+		if parseErr != nil || strings.Trim(fields[1], "0") == "" {
+			continue
+		}
+		entries = append(entries, reflogEntry{
+			sha:  fields[1],
+			time: time.Unix(seconds, 0),
+		})
+	}
+	return entries, nil
+}
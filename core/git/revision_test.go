@@ -0,0 +1,224 @@
+package git_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseRevision(t *testing.T) {
+	date := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+	for commitish, expected := range map[string]*git.Revision{
+		"master":      {Base: "master"},
+		"@":           {Base: "HEAD"},
+		"v1.2^{}":     {Base: "v1.2", Selectors: []git.Selector{{Kind: git.PeelSelector}}},
+		"a/b~3^2^":    {Base: "a/b", Selectors: []git.Selector{{Kind: git.AncestorSelector, N: 3}, {Kind: git.ParentSelector, N: 2}, {Kind: git.ParentSelector, N: 1}}},
+		"master^0~":   {Base: "master", Selectors: []git.Selector{{Kind: git.ParentSelector, N: 0}, {Kind: git.AncestorSelector, N: 1}}},
+		"master@{2}":  {Base: "master", Selectors: []git.Selector{{Kind: git.ReflogSelector, N: 2}}},
+		"@{1}":        {Base: "HEAD", Selectors: []git.Selector{{Kind: git.ReflogSelector, N: 1}}},
+		"v1:src/pkg/": {Base: "v1", Path: "src/pkg"},
+		"v1:":         {Base: "v1"},
+
+		"master@{2021-03-01}":           {Base: "master", Selectors: []git.Selector{{Kind: git.DateSelector, Date: date}}},
+		"master@{2021-03-01 00:00:00}~": {Base: "master", Selectors: []git.Selector{{Kind: git.DateSelector, Date: date}, {Kind: git.AncestorSelector, N: 1}}},
+		"master@{2021-03-01T00:00:00}:src": {
+			Base: "master", Selectors: []git.Selector{{Kind: git.DateSelector, Date: date}}, Path: "src",
+		},
+	} {
+		revision, err := git.ParseRevision(commitish)
+		assert.Nil(t, err, commitish)
+		assert.Equal(t, expected.Base, revision.Base, commitish)
+		assert.Equal(t, expected.Path, revision.Path, commitish)
+		assert.Equal(t, len(expected.Selectors), len(revision.Selectors), commitish)
+		for i := range expected.Selectors {
+			assert.Equal(t, expected.Selectors[i].Kind, revision.Selectors[i].Kind, commitish)
+			assert.Equal(t, expected.Selectors[i].N, revision.Selectors[i].N, commitish)
+			assert.True(t, expected.Selectors[i].Date.Equal(revision.Selectors[i].Date), commitish)
+		}
+	}
+
+	revision, err := git.ParseRevision("master@{2.weeks.ago}")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(-14*24*time.Hour), revision.Selectors[0].Date, time.Minute)
+
+	for _, commitish := range []string{
+		"", "~1", "master^{tree}", "master@{yesterday}", "master~1@{1}", "master@{1", "master~x",
+		"master:../etc", "master:src/./pkg", "a..b", "/master", "ma ster",
+	} {
+		_, err = git.ParseRevision(commitish)
+		assert.True(t, errors.Is(err, git.ErrInvalidRevision), commitish)
+		assert.True(t, errors.Is(err, git.ErrRevisionNotFound), commitish)
+	}
+}
+
+// revisionTestSuite resolves revisions of a clone with dated history over each backend:
+//
+//	c1 (v1) - c2 - c3 - m (master)
+//	            \       /
+//	              f1 --
+type revisionTestSuite struct {
+	suite.Suite
+	backendName string
+	clonesPath  string
+	clonePath   string
+	commits     map[string]string
+	provider    *git.RepositoryProvider
+}
+
+func TestGoGitRevisions(t *testing.T) {
+	logger.InitLoggers("logs/revision_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, &revisionTestSuite{backendName: git.GoGitBackendName})
+}
+
+func TestCatFileRevisions(t *testing.T) {
+	logger.InitLoggers("logs/revision_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, &revisionTestSuite{backendName: git.CatFileBackendName})
+}
+
+// gitAt runs git in a clone as if at a date
+func gitAt(clonePath string, date string, arg ...string) {
+	command := exec.Command("git", append([]string{"-c", "user.name=gitreefs", "-c", "user.email=gitreefs@localhost"}, arg...)...)
+	command.Dir = clonePath
+	command.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date+"T12:00:00", "GIT_COMMITTER_DATE="+date+"T12:00:00")
+	output, err := command.CombinedOutput()
+	if err != nil {
+		panic(string(output))
+	}
+}
+
+func (revisionSuite *revisionTestSuite) commit(name string, date string, files map[string]string) {
+	for filePath, contents := range files {
+		fullPath := path.Join(revisionSuite.clonePath, filePath)
+		os.MkdirAll(path.Dir(fullPath), 0777)
+		ioutil.WriteFile(fullPath, []byte(contents), 0666)
+	}
+	gitAt(revisionSuite.clonePath, date, "add", "--all")
+	gitAt(revisionSuite.clonePath, date, "commit", "--quiet", "-m", name)
+	revisionSuite.commits[name] = testutils.RevParse(revisionSuite.clonePath, "HEAD")
+}
+
+func (revisionSuite *revisionTestSuite) SetupTest() {
+	var err error
+	revisionSuite.clonesPath, err = ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	revisionSuite.clonePath = path.Join(revisionSuite.clonesPath, testutils.LOCAL_REPO_NAME)
+	revisionSuite.commits = make(map[string]string)
+	os.MkdirAll(revisionSuite.clonePath, 0777)
+	gitAt(revisionSuite.clonePath, "2021-01-01", "init", "--quiet")
+	gitAt(revisionSuite.clonePath, "2021-01-01", "symbolic-ref", "HEAD", "refs/heads/master")
+
+	revisionSuite.commit("c1", "2021-01-01", testutils.LocalFiles)
+	gitAt(revisionSuite.clonePath, "2021-01-01", "tag", "-a", "-m", "v1", "v1")
+	revisionSuite.commit("c2", "2021-02-01", testutils.LocalSecondCommitFiles)
+	gitAt(revisionSuite.clonePath, "2021-02-15", "checkout", "--quiet", "-b", "feature")
+	revisionSuite.commit("f1", "2021-02-15", map[string]string{"feature.txt": "feature\n"})
+	gitAt(revisionSuite.clonePath, "2021-02-15", "checkout", "--quiet", "master")
+	revisionSuite.commit("c3", "2021-03-01", map[string]string{"c3.txt": "c3\n"})
+	gitAt(revisionSuite.clonePath, "2021-04-01", "merge", "--quiet", "--no-ff", "-m", "m", "feature")
+	revisionSuite.commits["m"] = testutils.RevParse(revisionSuite.clonePath, "HEAD")
+
+	revisionSuite.Nil(git.SetBackend(revisionSuite.backendName))
+	revisionSuite.provider = revisionSuite.openProvider()
+}
+
+func (revisionSuite *revisionTestSuite) TearDownTest() {
+	revisionSuite.provider.Close()
+	git.SetBackend(git.GoGitBackendName)
+	os.RemoveAll(revisionSuite.clonesPath)
+}
+
+func (revisionSuite *revisionTestSuite) openProvider() *git.RepositoryProvider {
+	provider, err := git.NewRepositoryProvider(revisionSuite.clonePath)
+	if err != nil {
+		panic(err)
+	}
+	return provider
+}
+
+func (revisionSuite *revisionTestSuite) assertResolves(expected map[string]string) {
+	for commitish, name := range expected {
+		sha, err := revisionSuite.provider.ResolveCommit(commitish)
+		revisionSuite.Nil(err, commitish)
+		revisionSuite.Equal(revisionSuite.commits[name], sha, "%v should be %v", commitish, name)
+	}
+}
+
+func (revisionSuite *revisionTestSuite) assertNotFound(commitishes ...string) {
+	for _, commitish := range commitishes {
+		canResolve, err := revisionSuite.provider.CanResolve(commitish)
+		revisionSuite.Nil(err, commitish)
+		revisionSuite.False(canResolve, commitish)
+	}
+}
+
+func (revisionSuite *revisionTestSuite) TestAncestry() {
+	revisionSuite.assertResolves(map[string]string{
+		"master":          "m",
+		"master^0":        "m",
+		"master~":         "c3",
+		"master^":         "c3",
+		"master~1":        "c3",
+		"master^2":        "f1",
+		"master^2~1":      "c2",
+		"master~3":        "c1",
+		"feature~2":       "c1",
+		"v1":              "c1",
+		"v1^{}":           "c1",
+		"master^{commit}": "m",
+	})
+	revisionSuite.assertResolves(map[string]string{
+		revisionSuite.commits["m"][:7] + "^2": "f1",
+	})
+	revisionSuite.assertNotFound("master^3", "master~4", "v1^", "master^{tree}", "master~x")
+}
+
+func (revisionSuite *revisionTestSuite) TestReflog() {
+	revisionSuite.assertResolves(map[string]string{
+		"master@{0}":            "m",
+		"master@{1}":            "c3",
+		"master@{3}":            "c1",
+		"master@{2021-02-10}":   "c2",
+		"master@{2021-03-15}":   "c3",
+		"master@{2021-05-01}":   "m",
+		"feature@{2021-03-01}":  "f1",
+		"master@{2021-03-15}~1": "c2",
+	})
+	revisionSuite.assertNotFound("master@{10}", "master@{2020-01-01}")
+}
+
+func (revisionSuite *revisionTestSuite) TestDateWithoutReflog() {
+	revisionSuite.Nil(os.RemoveAll(path.Join(revisionSuite.clonePath, ".git", "logs")))
+	revisionSuite.assertResolves(map[string]string{
+		"master@{0}":           "m",
+		"master@{2021-02-10}":  "c2",
+		"master@{2021-02-20}":  "c2",
+		"master@{2021-03-15}":  "c3",
+		"feature@{2021-03-01}": "f1",
+	})
+	revisionSuite.assertNotFound("master@{1}", "master@{2020-01-01}")
+}
+
+func (revisionSuite *revisionTestSuite) TestSubtree() {
+	root, err := revisionSuite.provider.ListTree("master~1:src")
+	revisionSuite.Nil(err)
+	revisionSuite.NotNil(find(root, "main.go"))
+	revisionSuite.NotNil(find(root, "pkg/util.go"))
+	revisionSuite.Nil(find(root, "README.md"))
+
+	contents, err := revisionSuite.provider.FileContents("v1:src/pkg", "util.go")
+	revisionSuite.Nil(err)
+	revisionSuite.Equal(testutils.LocalFiles["src/pkg/util.go"], contents)
+
+	revisionSuite.assertNotFound("master:missing", "master:README.md", "master:src/main.go", "master:../src")
+}
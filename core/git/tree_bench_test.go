@@ -0,0 +1,80 @@
+// +build bench
+
+package git_test
+
+import (
+	"fmt"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"runtime"
+	"testing"
+)
+
+const (
+	benchDirsCount    = 100
+	benchFilesCount   = 100
+	benchCommitsCount = 50
+)
+
+// setupBenchBackend commits a tree of benchDirsCount directories of benchFilesCount files each,
+// and then changes one file per commit, as most commits of a large repository do
+func setupBenchBackend() (backend *git.MemoryBackend, shas []string) {
+	backend = git.NewMemoryBackend()
+	files := make(map[string]string)
+	for dir := 0; dir < benchDirsCount; dir++ {
+		for file := 0; file < benchFilesCount; file++ {
+			files[fmt.Sprintf("dir%v/file%v.go", dir, file)] = fmt.Sprintf("package dir%v\n", dir)
+		}
+	}
+	for commit := 0; commit < benchCommitsCount; commit++ {
+		files[fmt.Sprintf("dir%v/file0.go", commit%benchDirsCount)] = fmt.Sprintf("// commit %v\n", commit)
+		shas = append(shas, backend.Commit(files, "master"))
+	}
+	return
+}
+
+func BenchmarkListTreeMemory(b *testing.B) {
+	logger.InitLoggers("logs/tree_bench_test-%v-%v.log", "INFO", "-")
+	backend, shas := setupBenchBackend()
+	b.ResetTimer()
+
+	var bytes int64
+	var roots []*git.Entry
+	for i := 0; i < b.N; i++ {
+		roots = nil
+		provider := git.NewBackendProvider(backend)
+		bytes += testutils.HeapGrowth(func() {
+			for _, sha := range shas {
+				root, err := provider.ListTree(sha)
+				if err != nil {
+					b.Fatal(err)
+				}
+				roots = append(roots, root)
+			}
+		})
+	}
+	testutils.PrintMemoryUsage()
+	runtime.KeepAlive(roots)
+
+	entries := roots[0].Count() * len(roots)
+	b.ReportMetric(float64(bytes)/float64(b.N*len(shas)), "B/commit")
+	b.ReportMetric(float64(bytes)/float64(b.N*entries), "B/entry")
+}
+
+func BenchmarkFind(b *testing.B) {
+	logger.InitLoggers("logs/tree_bench_test-%v-%v.log", "ERROR", "-")
+	backend, shas := setupBenchBackend()
+	root, err := git.NewBackendProvider(backend).ListTree(shas[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, found := root.Find(fmt.Sprintf("dir%v/file%v.go", i%benchDirsCount, i%benchFilesCount))
+		if !found {
+			b.Fatal("not found")
+		}
+	}
+}
@@ -28,6 +28,8 @@ var (
 	fileLogger  *lumberjack.Logger
 	globalLevel LogLevel
 	appVersion  = "-"
+	// consoleOutput is where debug and info logs are written to the console, error logs go to stderr
+	consoleOutput io.Writer = os.Stdout
 )
 
 func init() {
@@ -92,6 +94,10 @@ func stringToLevel(level string) LogLevel {
 	}
 }
 
+func IsValidLevel(level string) bool {
+	return stringToLevel(level) != 0
+}
+
 func createLogger(level LogLevel) io.Writer {
 
 	var consoleWriter io.Writer
@@ -99,7 +105,7 @@ func createLogger(level LogLevel) io.Writer {
 	case LogLevelError:
 		consoleWriter = os.Stderr
 	default:
-		consoleWriter = os.Stdout
+		consoleWriter = consoleOutput
 	}
 
 	writers := []io.Writer{consoleWriter}
@@ -112,6 +118,12 @@ func createLogger(level LogLevel) io.Writer {
 	}
 }
 
+// SetConsoleOutput writes debug and info logs to the given writer instead of stdout, for commands whose output is stdout
+func SetConsoleOutput(writer io.Writer) {
+	consoleOutput = writer
+	initLoggers()
+}
+
 func CloseLoggers() {
 	if fileLogger != nil {
 		fileLogger.Close()
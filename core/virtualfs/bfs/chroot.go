@@ -0,0 +1,156 @@
+package bfs
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChrootFileSystem is a view of a GitFileSystem rooted at a repository, a commitish or a directory within it
+type ChrootFileSystem struct {
+	fs   *GitFileSystem
+	base string
+}
+
+var _ billy.Filesystem = &ChrootFileSystem{}
+var _ billy.Capable = &ChrootFileSystem{}
+
+type chrootFile struct {
+	billy.File
+	name string
+}
+
+func (file *chrootFile) Name() string {
+	return file.name
+}
+
+// confine resolves a path relative to a root, failing for paths that escape it
+func confine(base string, path string) (string, error) {
+	cleanPath := filepath.Clean(strings.TrimPrefix(filepath.ToSlash(path), "/"))
+	if cleanPath == "." {
+		cleanPath = ""
+	}
+	if cleanPath == ".." || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+		return "", billy.ErrCrossedBoundary
+	}
+	return filepath.Join(base, cleanPath), nil
+}
+
+func newChrootFileSystem(fs *GitFileSystem, base string) (billy.Filesystem, error) {
+	info, err := fs.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "chroot", Path: base, Err: os.ErrInvalid}
+	}
+	return &ChrootFileSystem{
+		fs:   fs,
+		base: base,
+	}, nil
+}
+
+// Chroot returns a view of the file system rooted at a repository, a commitish or a directory within it
+func (fs *GitFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	base, err := confine("", path)
+	if err != nil {
+		return nil, err
+	}
+	return newChrootFileSystem(fs, base)
+}
+
+func (chroot *ChrootFileSystem) underlyingPath(path string) (string, error) {
+	return confine(chroot.base, path)
+}
+
+func (chroot *ChrootFileSystem) Capabilities() billy.Capability {
+	return chroot.fs.Capabilities()
+}
+
+func (chroot *ChrootFileSystem) Open(path string) (billy.File, error) {
+	fullPath, err := chroot.underlyingPath(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := chroot.fs.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return &chrootFile{File: file, name: path}, nil
+}
+
+func (chroot *ChrootFileSystem) OpenFile(path string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&writeFlags != 0 {
+		return nil, readOnlyError("open", path)
+	}
+	return chroot.Open(path)
+}
+
+func (chroot *ChrootFileSystem) Stat(path string) (os.FileInfo, error) {
+	fullPath, err := chroot.underlyingPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return chroot.fs.Stat(fullPath)
+}
+
+func (chroot *ChrootFileSystem) Lstat(path string) (os.FileInfo, error) {
+	return chroot.Stat(path)
+}
+
+func (chroot *ChrootFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	fullPath, err := chroot.underlyingPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return chroot.fs.ReadDir(fullPath)
+}
+
+func (chroot *ChrootFileSystem) Readlink(link string) (string, error) {
+	fullPath, err := chroot.underlyingPath(link)
+	if err != nil {
+		return "", err
+	}
+	return chroot.fs.Readlink(fullPath)
+}
+
+func (chroot *ChrootFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (chroot *ChrootFileSystem) Root() string {
+	return filepath.Join(chroot.fs.Root(), chroot.base)
+}
+
+func (chroot *ChrootFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fullPath, err := chroot.underlyingPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return newChrootFileSystem(chroot.fs, fullPath)
+}
+
+func (chroot *ChrootFileSystem) Create(path string) (billy.File, error) {
+	return nil, readOnlyError("create", path)
+}
+
+func (chroot *ChrootFileSystem) Rename(oldpath, newpath string) error {
+	return readOnlyError("rename", oldpath)
+}
+
+func (chroot *ChrootFileSystem) Remove(path string) error {
+	return readOnlyError("remove", path)
+}
+
+func (chroot *ChrootFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, readOnlyError("tempfile", dir)
+}
+
+func (chroot *ChrootFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return readOnlyError("mkdir", path)
+}
+
+func (chroot *ChrootFileSystem) Symlink(target, link string) error {
+	return readOnlyError("symlink", link)
+}
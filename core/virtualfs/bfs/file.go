@@ -1,18 +1,18 @@
 package bfs
 
 import (
+	"errors"
 	"github.com/go-git/go-billy/v5"
 	"gitreefs/core/git"
 	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
 	"io"
 	"os"
 )
 
 type File struct {
-	provider  *git.RepositoryProvider
-	commitish *Commitish
+	node      nodes.Node
 	fullPath  string
-	subPath   string
 	position  int64
 	size      int64
 	isClosed  bool
@@ -22,18 +22,12 @@ type File struct {
 
 var _ billy.File = &File{}
 
-func NewFile(
-	fullPath string,
-	commitish *Commitish,
-	subPath string,
-	size int64,
-) (file *File, err error) {
+func NewFile(fullPath string, node nodes.Node) (file *File, err error) {
 	return &File{
-		commitish: commitish,
-		fullPath:  fullPath,
-		subPath:   subPath,
-		size:      size,
-		position:  0,
+		node:     node,
+		fullPath: fullPath,
+		size:     node.Size(),
+		position: 0,
 	}, nil
 }
 
@@ -45,30 +39,40 @@ func (file *File) Read(buff []byte) (n int, err error) {
 	return file.ReadAt(buff, file.position)
 }
 
+// ReadAt reads the file within its size, except for a file whose blob is missing from a partial clone,
+// which is always read for its contents, so it fails with ErrBlobMissing or fetches it rather than reading as empty
 func (file *File) ReadAt(buff []byte, offset int64) (int, error) {
 	if file.isClosed {
 		return 0, os.ErrClosed
 	}
 
-	if offset < 0 || offset >= file.size {
+	if offset < 0 || (offset >= file.size && !file.node.IsMissing()) {
 		return 0, io.EOF
 	}
 
-	targetCapacity := int64(len(buff))
-	if offset+targetCapacity > file.size {
-		targetCapacity = file.size - offset
-	}
-
 	if !file.isFetched {
-		contents, err := file.commitish.FileContents(file.subPath)
+		contents, err := file.node.Contents()
+		if errors.Is(err, git.ErrBlobMissing) {
+			logger.Info("file.ReadAt: missing blob for '%v': %v", file.fullPath, err)
+			return 0, err
+		}
 		if err != nil {
 			logger.Error("file.ReadAt: failed for '%v': %v", file.fullPath, err)
 			return 0, os.ErrNotExist
 		}
 		file.contents = contents
+		file.size = int64(len(contents))
 		file.isFetched = true
 	}
 
+	if offset >= file.size {
+		return 0, io.EOF
+	}
+	targetCapacity := int64(len(buff))
+	if offset+targetCapacity > file.size {
+		targetCapacity = file.size - offset
+	}
+
 	targetContents := file.contents[offset : offset+targetCapacity]
 	bytesRead := copy(buff, targetContents)
 	file.position += int64(bytesRead)
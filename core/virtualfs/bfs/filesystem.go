@@ -1,22 +1,24 @@
 package bfs
 
 import (
+	"errors"
 	"github.com/go-git/go-billy/v5"
 	"gitreefs/core/git"
 	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
 	"os"
 	"path/filepath"
 )
 
 type GitFileSystem struct {
-	root *Root
+	root *nodes.Root
 }
 
 var _ billy.Filesystem = &GitFileSystem{}
 var _ billy.Capable = &GitFileSystem{}
 
 func NewGitFileSystem(clonesPath string) (*GitFileSystem, error) {
-	root, err := NewRoot(clonesPath)
+	root, err := nodes.NewRoot(clonesPath)
 	if err != nil {
 		return nil, err
 	}
@@ -25,10 +27,20 @@ func NewGitFileSystem(clonesPath string) (*GitFileSystem, error) {
 	}, nil
 }
 
+// Nodes is the root of the nodes the file system serves
+func (fs *GitFileSystem) Nodes() *nodes.Root {
+	return fs.root
+}
+
 func (fs *GitFileSystem) Capabilities() billy.Capability {
 	return billy.ReadCapability | billy.SeekCapability
 }
 
+// lookup finds the node of a path, the empty path being the root
+func (fs *GitFileSystem) lookup(path string) (nodes.Node, error) {
+	return nodes.Lookup(fs.root, split(path)...)
+}
+
 func (fs *GitFileSystem) Open(path string) (billy.File, error) {
 	components, err := breakdown(path)
 	if err != nil {
@@ -40,27 +52,12 @@ func (fs *GitFileSystem) Open(path string) (billy.File, error) {
 		return nil, os.ErrNotExist
 	}
 
-	var repository *Repository
-	repository, err = fs.root.getOrAddRepository(components.repositoryName)
-	if err != nil || repository == nil {
-		logger.Info("fs.Open: could not find repository for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	var commitish *Commitish
-	commitish, err = repository.getOrAddCommitish(components.commitishName)
-	if err != nil || commitish == nil {
-		logger.Info("fs.Open: could not find commitish for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	var entry *git.Entry
-	entry, err = commitish.GetEntry(components.subPath)
-	if err != nil || entry == nil {
+	node, err := fs.lookup(path)
+	if err != nil {
 		logger.Info("fs.Open: could not find file for %v: %v", path, err)
 		return nil, os.ErrNotExist
 	}
-	file, err := NewFile(path, commitish, components.subPath, entry.Size)
+	file, err := NewFile(path, node)
 	if err != nil || file == nil {
 		logger.Info("fs.Open: could not open file for %v: %v", path, err)
 		return nil, os.ErrNotExist
@@ -68,55 +65,35 @@ func (fs *GitFileSystem) Open(path string) (billy.File, error) {
 	return file, nil
 }
 
-func (fs *GitFileSystem) OpenFile(filename string, _ int, _ os.FileMode) (billy.File, error) {
+func (fs *GitFileSystem) OpenFile(filename string, flag int, _ os.FileMode) (billy.File, error) {
+	if flag&writeFlags != 0 {
+		return nil, readOnlyError("open", filename)
+	}
 	return fs.Open(filename)
 }
 
+// Stat describes a file or a directory. A file whose blob is missing from a partial clone is read for its size,
+// as clients read files only up to the size they stat, which fails with ErrBlobMissing unless fetching it is enabled.
+// Files listed by ReadDir aren't read, so listing a directory doesn't fetch its files.
 func (fs *GitFileSystem) Stat(path string) (os.FileInfo, error) {
-	components, err := breakdown(path)
+	node, err := fs.lookup(path)
 	if err != nil {
-		logger.Error("fs.Stat: could not find '%v': %v", path, err)
+		logger.Info("fs.Stat: could not find %v: %v", path, err)
 		return nil, os.ErrNotExist
 	}
-
-	if !components.hasRepository() {
-		return statDir("")
-	}
-
-	var repository *Repository
-	repository, err = fs.root.getOrAddRepository(components.repositoryName)
-	if err != nil || repository == nil {
-		logger.Info("fs.Stat: could not find repository for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	if !components.hasCommitish() {
-		return statDir(repository.name)
-	}
-
-	var commitish *Commitish
-	commitish, err = repository.getOrAddCommitish(components.commitishName)
-	if err != nil || commitish == nil {
-		logger.Info("fs.Stat: could not find commitish for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	entry, err := commitish.GetEntry(components.subPath)
-	if err != nil || entry == nil {
-		logger.Info("fs.Stat: could not find git entry for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	baseName := git.ExtractBaseName(path)
-	if entry.IsDir {
-		info, err := statDir(baseName)
-		if info == nil || err != nil {
-			logger.Error("fs.Stat: failed to stat %v: %v", path, err)
+	if node.IsMissing() {
+		_, err = node.Contents()
+		if errors.Is(err, git.ErrBlobMissing) {
+			logger.Info("fs.Stat: missing blob for %v: %v", path, err)
+			return nil, err
+		}
+		if err != nil {
+			logger.Error("fs.Stat: failed reading %v for its size: %v", path, err)
 			return nil, os.ErrNotExist
 		}
-		return info, nil
 	}
-	info, err := statFile(baseName, entry.Size)
+
+	info, err := statNode(node)
 	if info == nil || err != nil {
 		logger.Error("fs.Stat: failed to stat %v: %v", path, err)
 		return nil, os.ErrNotExist
@@ -125,35 +102,25 @@ func (fs *GitFileSystem) Stat(path string) (os.FileInfo, error) {
 }
 
 func (fs *GitFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
-	components, err := breakdown(path)
+	node, err := fs.lookup(path)
 	if err != nil {
-		logger.Error("fs.ReadDir: could not find '%v': %v", path, err)
+		logger.Info("fs.ReadDir: could not find %v: %v", path, err)
 		return nil, os.ErrNotExist
 	}
 
-	if !components.hasRepository() || !components.hasCommitish() {
-		return []os.FileInfo{}, nil
-	}
-
-	var repository *Repository
-	repository, err = fs.root.getOrAddRepository(components.repositoryName)
-	if err != nil || repository == nil {
-		logger.Info("fs.ReadDir: could not find repository for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	var commitish *Commitish
-	commitish, err = repository.getOrAddCommitish(components.commitishName)
-	if err != nil || commitish == nil {
-		logger.Info("fs.ReadDir: could not find commitish for %v: %v", path, err)
-		return nil, os.ErrNotExist
-	}
-
-	files, err := commitish.ListDir(components.subPath)
-	if files == nil || err != nil {
+	children, err := node.Children()
+	if err != nil {
 		logger.Error("fs.ReadDir: failed on %v: %v", path, err)
 		return nil, os.ErrNotExist
 	}
+	files := make([]os.FileInfo, len(children))
+	for i, child := range children {
+		files[i], err = statNode(child)
+		if err != nil {
+			logger.Error("fs.ReadDir: failed to stat %v of %v: %v", child.Name(), path, err)
+			return nil, os.ErrNotExist
+		}
+	}
 	return files, nil
 }
 
@@ -0,0 +1,295 @@
+package bfs
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
+	testutils "gitreefs/test_utils"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+type bfsTestSuite struct {
+	suite.Suite
+	clonesPath string
+	commits    []string
+	fs         *GitFileSystem
+}
+
+func TestBfsTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/bfs_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(bfsTestSuite))
+}
+
+func (bfsSuite *bfsTestSuite) SetupTest() {
+	bfsSuite.clonesPath, bfsSuite.commits = testutils.SetupLocalClones()
+	var err error
+	bfsSuite.fs, err = NewGitFileSystem(bfsSuite.clonesPath)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (bfsSuite *bfsTestSuite) TearDownTest() {
+	os.RemoveAll(bfsSuite.clonesPath)
+}
+
+func (bfsSuite *bfsTestSuite) assertReadOnly(err error) {
+	bfsSuite.NotNil(err)
+	pathErr, isPathErr := err.(*os.PathError)
+	bfsSuite.True(isPathErr, "not a path error: %v", err)
+	if isPathErr {
+		bfsSuite.Equal(syscall.EROFS, pathErr.Err)
+	}
+}
+
+func (bfsSuite *bfsTestSuite) filePath() string {
+	return path.Join(testutils.LOCAL_REPO_NAME, "master", "src", "main.go")
+}
+
+func (bfsSuite *bfsTestSuite) TestCreate() {
+	file, err := bfsSuite.fs.Create(path.Join(testutils.LOCAL_REPO_NAME, "master", "new.txt"))
+	bfsSuite.Nil(file)
+	bfsSuite.assertReadOnly(err)
+}
+
+func (bfsSuite *bfsTestSuite) TestRename() {
+	bfsSuite.assertReadOnly(bfsSuite.fs.Rename(bfsSuite.filePath(), bfsSuite.filePath()+".bak"))
+}
+
+func (bfsSuite *bfsTestSuite) TestRemove() {
+	bfsSuite.assertReadOnly(bfsSuite.fs.Remove(bfsSuite.filePath()))
+	_, err := bfsSuite.fs.Stat(bfsSuite.filePath())
+	bfsSuite.Nil(err)
+}
+
+func (bfsSuite *bfsTestSuite) TestTempFile() {
+	file, err := bfsSuite.fs.TempFile(path.Join(testutils.LOCAL_REPO_NAME, "master"), "tmp")
+	bfsSuite.Nil(file)
+	bfsSuite.assertReadOnly(err)
+}
+
+func (bfsSuite *bfsTestSuite) TestMkdirAll() {
+	bfsSuite.assertReadOnly(bfsSuite.fs.MkdirAll(path.Join(testutils.LOCAL_REPO_NAME, "master", "a", "b"), 0777))
+}
+
+func (bfsSuite *bfsTestSuite) TestSymlink() {
+	bfsSuite.assertReadOnly(bfsSuite.fs.Symlink(bfsSuite.filePath(), path.Join(testutils.LOCAL_REPO_NAME, "master", "link")))
+}
+
+func (bfsSuite *bfsTestSuite) TestChrootAtDirectory() {
+	chroot, err := bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, "master", "src"))
+	bfsSuite.Nil(err)
+	bfsSuite.Equal("/local/master/src", chroot.Root())
+
+	infos, err := chroot.ReadDir("")
+	bfsSuite.Nil(err)
+	bfsSuite.Len(infos, 2)
+	bfsSuite.Equal("main.go", infos[0].Name())
+	bfsSuite.Equal("pkg", infos[1].Name())
+
+	info, err := chroot.Stat("/pkg/util.go")
+	bfsSuite.Nil(err)
+	bfsSuite.EqualValues(len(testutils.LocalFiles["src/pkg/util.go"]), info.Size())
+
+	file, err := chroot.Open("pkg/util.go")
+	bfsSuite.Nil(err)
+	bfsSuite.Equal("pkg/util.go", file.Name())
+	contents := make([]byte, 64)
+	read, err := file.Read(contents)
+	bfsSuite.Nil(err)
+	bfsSuite.Equal(testutils.LocalFiles["src/pkg/util.go"], string(contents[:read]))
+	file.Close()
+
+	nested, err := chroot.Chroot("pkg")
+	bfsSuite.Nil(err)
+	_, err = nested.Stat("util.go")
+	bfsSuite.Nil(err)
+}
+
+func (bfsSuite *bfsTestSuite) TestChrootAtRepositoryAndCommitish() {
+	chroot, err := bfsSuite.fs.Chroot(testutils.LOCAL_REPO_NAME)
+	bfsSuite.Nil(err)
+	info, err := chroot.Stat(path.Join(bfsSuite.commits[0], "README.md"))
+	bfsSuite.Nil(err)
+	bfsSuite.False(info.IsDir())
+
+	chroot, err = bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, bfsSuite.commits[0]))
+	bfsSuite.Nil(err)
+	_, err = chroot.Stat("README.md")
+	bfsSuite.Nil(err)
+	_, err = chroot.Stat(path.Join("docs", "guide.md"))
+	bfsSuite.True(os.IsNotExist(err))
+}
+
+func (bfsSuite *bfsTestSuite) TestChrootPreventsEscape() {
+	chroot, err := bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, "master", "src"))
+	bfsSuite.Nil(err)
+
+	for _, escaping := range []string{"..", "../README.md", "pkg/../../README.md", "/../README.md"} {
+		_, err = chroot.Stat(escaping)
+		bfsSuite.Equal(billy.ErrCrossedBoundary, err, "escaped with %v", escaping)
+		_, err = chroot.Open(escaping)
+		bfsSuite.Equal(billy.ErrCrossedBoundary, err, "escaped with %v", escaping)
+		_, err = chroot.ReadDir(escaping)
+		bfsSuite.Equal(billy.ErrCrossedBoundary, err, "escaped with %v", escaping)
+		_, err = chroot.Chroot(escaping)
+		bfsSuite.Equal(billy.ErrCrossedBoundary, err, "escaped with %v", escaping)
+	}
+
+	_, err = bfsSuite.fs.Chroot("../..")
+	bfsSuite.Equal(billy.ErrCrossedBoundary, err)
+}
+
+func (bfsSuite *bfsTestSuite) TestChrootInvalidRoots() {
+	_, err := bfsSuite.fs.Chroot(bfsSuite.filePath())
+	bfsSuite.NotNil(err)
+	_, err = bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, "master", "wat"))
+	bfsSuite.True(os.IsNotExist(err))
+	_, err = bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, "wat"))
+	bfsSuite.True(os.IsNotExist(err))
+}
+
+func (bfsSuite *bfsTestSuite) TestOpenFileForWriting() {
+	for _, flag := range []int{os.O_WRONLY, os.O_RDWR, os.O_CREATE, os.O_TRUNC, os.O_APPEND} {
+		file, err := bfsSuite.fs.OpenFile(bfsSuite.filePath(), flag, 0)
+		bfsSuite.Nil(file)
+		bfsSuite.assertReadOnly(err)
+	}
+	file, err := bfsSuite.fs.OpenFile(bfsSuite.filePath(), os.O_RDONLY, 0)
+	bfsSuite.Nil(err)
+	bfsSuite.Nil(file.Close())
+}
+
+func (bfsSuite *bfsTestSuite) TestChrootIsReadOnly() {
+	chroot, err := bfsSuite.fs.Chroot(path.Join(testutils.LOCAL_REPO_NAME, "master"))
+	bfsSuite.Nil(err)
+	_, err = chroot.Create("new.txt")
+	bfsSuite.assertReadOnly(err)
+	bfsSuite.assertReadOnly(chroot.Remove("README.md"))
+	_, err = chroot.OpenFile("README.md", os.O_RDWR, 0)
+	bfsSuite.assertReadOnly(err)
+	bfsSuite.Equal(billy.ReadCapability|billy.SeekCapability, billy.Capabilities(chroot))
+}
+
+func (bfsSuite *bfsTestSuite) TestLstat() {
+	info, err := bfsSuite.fs.Lstat(bfsSuite.filePath())
+	bfsSuite.Nil(err)
+	bfsSuite.Equal("main.go", info.Name())
+	bfsSuite.False(info.IsDir())
+	bfsSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size())
+	bfsSuite.True(info.Mode().IsRegular())
+
+	info, err = bfsSuite.fs.Lstat(path.Join(testutils.LOCAL_REPO_NAME, "master", "src"))
+	bfsSuite.Nil(err)
+	bfsSuite.True(info.IsDir())
+
+	_, err = bfsSuite.fs.Lstat(path.Join(testutils.LOCAL_REPO_NAME, "master", "wat"))
+	bfsSuite.True(os.IsNotExist(err))
+}
+
+func (bfsSuite *bfsTestSuite) TestReadlink() {
+	_, err := bfsSuite.fs.Readlink(bfsSuite.filePath())
+	bfsSuite.NotNil(err)
+	bfsSuite.False(os.IsNotExist(err))
+
+	_, err = bfsSuite.fs.Readlink(path.Join(testutils.LOCAL_REPO_NAME, "master", "wat"))
+	bfsSuite.True(os.IsNotExist(err))
+}
+
+func (bfsSuite *bfsTestSuite) openFile() billy.File {
+	file, err := bfsSuite.fs.Open(bfsSuite.filePath())
+	bfsSuite.Nil(err)
+	bfsSuite.NotNil(file)
+	return file
+}
+
+func (bfsSuite *bfsTestSuite) TestFileWrite() {
+	file := bfsSuite.openFile()
+	defer file.Close()
+	written, err := file.Write([]byte("package foo"))
+	bfsSuite.EqualValues(0, written)
+	bfsSuite.assertReadOnly(err)
+
+	contents := make([]byte, 7)
+	read, err := file.Read(contents)
+	bfsSuite.Nil(err)
+	bfsSuite.Equal("package", string(contents[:read]))
+}
+
+func (bfsSuite *bfsTestSuite) TestFileLock() {
+	file := bfsSuite.openFile()
+	defer file.Close()
+	bfsSuite.assertReadOnly(file.Lock())
+	bfsSuite.assertReadOnly(file.Unlock())
+}
+
+func (bfsSuite *bfsTestSuite) TestFileTruncate() {
+	file := bfsSuite.openFile()
+	defer file.Close()
+	bfsSuite.assertReadOnly(file.Truncate(0))
+}
+
+// nestedFileSystem serves a directory within the local clone, so that a traversal to its parent would open the clone
+func (bfsSuite *bfsTestSuite) nestedFileSystem() *GitFileSystem {
+	nestedPath := path.Join(bfsSuite.clonesPath, testutils.LOCAL_REPO_NAME, "nested")
+	err := os.MkdirAll(nestedPath, 0777)
+	if err != nil {
+		panic(err)
+	}
+	fs, err := NewGitFileSystem(nestedPath)
+	if err != nil {
+		panic(err)
+	}
+	return fs
+}
+
+func (bfsSuite *bfsTestSuite) TestRepositoryTraversal() {
+	fs := bfsSuite.nestedFileSystem()
+	for _, traversal := range []string{
+		"..",
+		"../master/README.md",
+		"./../master",
+		".",
+		"./master",
+		".git",
+	} {
+		_, err := fs.Stat(traversal)
+		bfsSuite.True(os.IsNotExist(err), "stat %v: %v", traversal, err)
+		_, err = fs.Open(traversal)
+		bfsSuite.True(os.IsNotExist(err), "open %v: %v", traversal, err)
+		// repositories and commitishes aren't listed, so only the contents of a commitish are ever read
+		infos, err := fs.ReadDir(traversal)
+		bfsSuite.True(err != nil || len(infos) == 0, "read dir %v", traversal)
+	}
+
+	_, err := nodes.NewRepository(path.Join(bfsSuite.clonesPath, testutils.LOCAL_REPO_NAME, "nested"), "..")
+	bfsSuite.True(errors.Is(err, common.ErrInvalidRepositoryName))
+}
+
+func (bfsSuite *bfsTestSuite) TestSymlinkedClones() {
+	otherClonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(otherClonesPath)
+
+	err := os.Symlink(path.Join(bfsSuite.clonesPath, testutils.LOCAL_REPO_NAME), path.Join(bfsSuite.clonesPath, "alias"))
+	bfsSuite.Nil(err)
+	err = os.Symlink(path.Join(otherClonesPath, testutils.LOCAL_REPO_NAME), path.Join(bfsSuite.clonesPath, "outside"))
+	bfsSuite.Nil(err)
+	err = os.Symlink(bfsSuite.clonesPath, path.Join(bfsSuite.clonesPath, "self"))
+	bfsSuite.Nil(err)
+
+	_, err = bfsSuite.fs.Stat(path.Join("alias", "master", "README.md"))
+	bfsSuite.Nil(err)
+	_, err = bfsSuite.fs.Stat(path.Join("outside", "master", "README.md"))
+	bfsSuite.True(os.IsNotExist(err))
+	_, err = bfsSuite.fs.Stat(path.Join("self", "master"))
+	bfsSuite.True(os.IsNotExist(err))
+
+	_, err = nodes.NewRepository(bfsSuite.clonesPath, "outside")
+	bfsSuite.True(errors.Is(err, common.ErrOutsideClonesPath))
+}
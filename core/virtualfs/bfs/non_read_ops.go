@@ -3,58 +3,74 @@ package bfs
 import (
 	"github.com/go-git/go-billy/v5"
 	"os"
+	"syscall"
 )
 
-const (
-	ERROR_MESSAGE = "operation is not readonly and not supported"
-)
+// writeFlags are the open flags of writing to a file, which opening in a read only file system fails on
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// readOnlyError is returned by all mutating operations, EROFS is translated by NFS servers to NFS3ERR_ROFS
+func readOnlyError(op string, path string) error {
+	return &os.PathError{
+		Op:   op,
+		Path: path,
+		Err:  syscall.EROFS,
+	}
+}
 
 func (fs *GitFileSystem) Create(filename string) (billy.File, error) {
-	panic(ERROR_MESSAGE)
+	return nil, readOnlyError("create", filename)
 }
+
 func (fs *GitFileSystem) Rename(oldpath, newpath string) error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("rename", oldpath)
 }
 
 func (fs *GitFileSystem) Remove(filename string) error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("remove", filename)
 }
 
 func (fs *GitFileSystem) TempFile(dir, prefix string) (billy.File, error) {
-	panic(ERROR_MESSAGE)
+	return nil, readOnlyError("tempfile", dir)
 }
 
 func (fs *GitFileSystem) MkdirAll(filename string, perm os.FileMode) error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("mkdir", filename)
 }
 
 func (fs *GitFileSystem) Lstat(filename string) (os.FileInfo, error) {
-	panic(ERROR_MESSAGE)
+	// there are no symbolic links in the virtual fs
+	return fs.Stat(filename)
 }
 
 func (fs *GitFileSystem) Symlink(target, link string) error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("symlink", link)
 }
 
 func (fs *GitFileSystem) Readlink(link string) (string, error) {
-	panic(ERROR_MESSAGE)
-}
-
-func (fs *GitFileSystem) Chroot(path string) (billy.Filesystem, error) {
-	panic(ERROR_MESSAGE)
+	_, err := fs.Stat(link)
+	if err != nil {
+		return "", err
+	}
+	return "", &os.PathError{
+		Op:   "readlink",
+		Path: link,
+		Err:  syscall.EINVAL,
+	}
 }
 
 func (file *File) Write(p []byte) (n int, err error) {
-	panic(ERROR_MESSAGE)
+	return 0, readOnlyError("write", file.fullPath)
 }
+
 func (file *File) Lock() error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("lock", file.fullPath)
 }
 
 func (file *File) Unlock() error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("unlock", file.fullPath)
 }
 
 func (file *File) Truncate(size int64) error {
-	panic(ERROR_MESSAGE)
+	return readOnlyError("truncate", file.fullPath)
 }
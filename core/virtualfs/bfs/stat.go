@@ -1,6 +1,7 @@
 package bfs
 
 import (
+	"gitreefs/core/virtualfs/nodes"
 	"os"
 	"time"
 )
@@ -55,3 +56,10 @@ func statFile(name string, size int64) (os.FileInfo, error) {
 		isDir: false,
 	}, nil
 }
+
+func statNode(node nodes.Node) (os.FileInfo, error) {
+	if node.IsDir() {
+		return statDir(node.Name())
+	}
+	return statFile(node.Name(), node.Size())
+}
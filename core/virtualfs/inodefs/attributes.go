@@ -1,38 +1,62 @@
 package inodefs
 
 import (
+	"fmt"
 	"github.com/jacobsa/fuse/fuseops"
 	"os"
 	"time"
 )
 
-var (
-	uid = uint32(os.Getuid())
-	gid = uint32(os.Getgid())
-)
+// Permissions are the owner, group and permission bits reported for all files and directories
+type Permissions struct {
+	Uid      uint32
+	Gid      uint32
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// DefaultPermissions gives the mounting process's uid and gid full access to everything
+func DefaultPermissions() *Permissions {
+	return &Permissions{
+		Uid:      uint32(os.Getuid()),
+		Gid:      uint32(os.Getgid()),
+		FileMode: os.ModePerm,
+		DirMode:  os.ModePerm,
+	}
+}
+
+func (permissions *Permissions) Validate() error {
+	if permissions.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode %#o, only permission bits are allowed", uint32(permissions.FileMode))
+	}
+	if permissions.DirMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid directory mode %#o, only permission bits are allowed", uint32(permissions.DirMode))
+	}
+	return nil
+}
 
-func FileAttributes(size int64) fuseops.InodeAttributes {
+func (permissions *Permissions) FileAttributes(size int64) fuseops.InodeAttributes {
 	return fuseops.InodeAttributes{
 		Size:  uint64(size),
 		Nlink: 1,
-		Mode:  os.ModePerm,
+		Mode:  permissions.FileMode,
 		Atime: time.Now(),
 		Mtime: time.Now(),
 		Ctime: time.Now(),
-		Uid:   uid,
-		Gid:   gid,
+		Uid:   permissions.Uid,
+		Gid:   permissions.Gid,
 	}
 }
 
-func DirAttributes() fuseops.InodeAttributes {
+func (permissions *Permissions) DirAttributes() fuseops.InodeAttributes {
 	return fuseops.InodeAttributes{
 		Size:  0,
 		Nlink: 1,
-		Mode:  os.ModeDir | os.ModePerm,
+		Mode:  os.ModeDir | permissions.DirMode,
 		Atime: time.Now(),
 		Mtime: time.Now(),
 		Ctime: time.Now(),
-		Uid:   uid,
-		Gid:   gid,
+		Uid:   permissions.Uid,
+		Gid:   permissions.Gid,
 	}
 }
@@ -3,22 +3,88 @@ package inodefs
 import (
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
-	"sync/atomic"
+	"gitreefs/core/virtualfs/nodes"
 )
 
-var (
-	allocatedInodeId uint64 = fuseops.RootInodeID
-)
-
-func NextInodeID() (next fuseops.InodeID) {
-	nextInodeId := atomic.AddUint64(&allocatedInodeId, 1)
-	return fuseops.InodeID(nextInodeId)
-}
-
 type Inode interface {
 	Id() fuseops.InodeID
 	GetOrAddChild(name string) (Inode, error)
 	Attributes() fuseops.InodeAttributes
 	ListChildren() (children []*fuseutil.Dirent, err error)
 	Contents() (string, error)
+	// IsMissing tells whether a file's blob is missing from a partial clone, so its size is unknown until it's read
+	IsMissing() bool
+}
+
+// nodeInode presents a node of the virtual git fs as an inode, the root node having the fuse root inode id
+type nodeInode struct {
+	node        nodes.Node
+	permissions *Permissions
+}
+
+var _ Inode = &nodeInode{}
+
+// NewRootInode presents the root of the nodes as the fuse root inode
+func NewRootInode(rootNode *nodes.Root, permissions *Permissions) (root Inode, err error) {
+	err = permissions.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &nodeInode{
+		node:        rootNode,
+		permissions: permissions,
+	}, nil
+}
+
+func (in *nodeInode) Id() fuseops.InodeID {
+	return fuseops.InodeID(in.node.Id())
+}
+
+func (in *nodeInode) GetOrAddChild(name string) (child Inode, err error) {
+	var childNode nodes.Node
+	childNode, err = in.node.Child(name)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeInode{
+		node:        childNode,
+		permissions: in.permissions,
+	}, nil
+}
+
+func (in *nodeInode) ListChildren() (children []*fuseutil.Dirent, err error) {
+	var childNodes []nodes.Node
+	childNodes, err = in.node.Children()
+	if err != nil {
+		return nil, err
+	}
+	children = make([]*fuseutil.Dirent, len(childNodes))
+	for i, childNode := range childNodes {
+		childType := fuseutil.DT_File
+		if childNode.IsDir() {
+			childType = fuseutil.DT_Directory
+		}
+		children[i] = &fuseutil.Dirent{
+			Offset: fuseops.DirOffset(i + 1),
+			Inode:  fuseops.InodeID(childNode.Id()),
+			Name:   childNode.Name(),
+			Type:   childType,
+		}
+	}
+	return
+}
+
+func (in *nodeInode) Attributes() fuseops.InodeAttributes {
+	if in.node.IsDir() {
+		return in.permissions.DirAttributes()
+	}
+	return in.permissions.FileAttributes(in.node.Size())
+}
+
+func (in *nodeInode) Contents() (string, error) {
+	return in.node.Contents()
+}
+
+func (in *nodeInode) IsMissing() bool {
+	return in.node.IsMissing()
 }
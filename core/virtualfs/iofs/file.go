@@ -0,0 +1,109 @@
+package iofs
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"gitreefs/core/git"
+	"io"
+	"io/fs"
+	"syscall"
+)
+
+type file struct {
+	billy.File
+	name string
+	info fs.FileInfo
+}
+
+var _ fs.File = &file{}
+var _ io.ReaderAt = &file{}
+var _ io.Seeker = &file{}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// checkSize fails reads of files larger than the size limit, rather than reporting them as missing
+func (f *file) checkSize() error {
+	if f.info.Size() >= git.MaxFileSizeBytes {
+		return &fs.PathError{Op: "read", Path: f.name, Err: git.ErrFileTooLarge}
+	}
+	return nil
+}
+
+func (f *file) Read(buff []byte) (int, error) {
+	err := f.checkSize()
+	if err != nil {
+		return 0, err
+	}
+	return f.File.Read(buff)
+}
+
+func (f *file) ReadAt(buff []byte, offset int64) (int, error) {
+	err := f.checkSize()
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.File.ReadAt(buff, offset)
+	if n < len(buff) && err == nil {
+		// io.ReaderAt must explain short reads
+		err = io.EOF
+	}
+	return n, err
+}
+
+type dir struct {
+	fsys     *FS
+	name     string
+	info     fs.FileInfo
+	entries  []fs.DirEntry
+	isListed bool
+	isClosed bool
+}
+
+var _ fs.ReadDirFile = &dir{}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dir) Close() error {
+	if d.isClosed {
+		return fs.ErrClosed
+	}
+	d.isClosed = true
+	return nil
+}
+
+// ReadDir returns the next count entries, or all remaining ones for a non positive count
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.isClosed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.isListed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.isListed = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
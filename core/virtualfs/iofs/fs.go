@@ -0,0 +1,105 @@
+package iofs
+
+import (
+	"gitreefs/core/virtualfs/bfs"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// FS is the virtual git fs as an io/fs file system, addressed by <repository>/<commitish>/<path>.
+// As with the mounted fs, repositories and their commitishes can be opened but aren't listed,
+// so walks should start at a commitish, e.g. fs.WalkDir(fsys, "repo/master", walkFn).
+type FS struct {
+	fs *bfs.GitFileSystem
+}
+
+var _ fs.FS = &FS{}
+var _ fs.ReadDirFS = &FS{}
+var _ fs.StatFS = &FS{}
+
+func New(clonesPath string) (*FS, error) {
+	gitFileSystem, err := bfs.NewGitFileSystem(clonesPath)
+	if err != nil {
+		return nil, err
+	}
+	return &FS{
+		fs: gitFileSystem,
+	}, nil
+}
+
+// underlyingPath maps a valid io/fs path to the path of the git fs, where the root is the empty path
+func underlyingPath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "", nil
+	}
+	return name, nil
+}
+
+func pathError(op string, name string, err error) error {
+	if os.IsNotExist(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return nil, pathError("open", name, err.(*fs.PathError).Err)
+	}
+	if info.IsDir() {
+		return &dir{
+			fsys: fsys,
+			name: name,
+			info: info,
+		}, nil
+	}
+
+	filePath, _ := underlyingPath("open", name)
+	underlying, err := fsys.fs.Open(filePath)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &file{
+		File: underlying,
+		name: name,
+		info: info,
+	}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	statPath, err := underlyingPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.fs.Stat(statPath)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return newFileInfo(name, info), nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return nil, pathError("readdir", name, err.(*fs.PathError).Err)
+	}
+	if !info.IsDir() {
+		return nil, pathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	dirPath, _ := underlyingPath("readdir", name)
+	infos, err := fsys.fs.ReadDir(dirPath)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = &dirEntry{info: newFileInfo(info.Name(), info)}
+	}
+	return entries, nil
+}
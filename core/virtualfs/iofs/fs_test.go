@@ -0,0 +1,210 @@
+package iofs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+type iofsTestSuite struct {
+	suite.Suite
+	clonesPath string
+	commits    []string
+	fsys       *FS
+}
+
+func TestIofsTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/iofs_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(iofsTestSuite))
+}
+
+func (iofsSuite *iofsTestSuite) SetupTest() {
+	iofsSuite.clonesPath, iofsSuite.commits = testutils.SetupLocalClones()
+	var err error
+	iofsSuite.fsys, err = New(iofsSuite.clonesPath)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (iofsSuite *iofsTestSuite) TearDownTest() {
+	git.SetMaxFileSizeMB(common.DefaultMaxFileSizeMB)
+	os.RemoveAll(iofsSuite.clonesPath)
+}
+
+func localFilePaths(fileSets ...map[string]string) (filePaths []string) {
+	for _, files := range fileSets {
+		for filePath := range files {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	sort.Strings(filePaths)
+	return
+}
+
+func (iofsSuite *iofsTestSuite) subFS(commitish string) fs.FS {
+	sub, err := fs.Sub(iofsSuite.fsys, path.Join(testutils.LOCAL_REPO_NAME, commitish))
+	iofsSuite.Nil(err)
+	return sub
+}
+
+func (iofsSuite *iofsTestSuite) TestCommitishes() {
+	firstFiles := localFilePaths(testutils.LocalFiles)
+	secondFiles := localFilePaths(testutils.LocalFiles, testutils.LocalSecondCommitFiles)
+	for commitish, expected := range map[string][]string{
+		"v1":                     firstFiles,
+		iofsSuite.commits[0]:     firstFiles,
+		iofsSuite.commits[0][:7]: firstFiles,
+		"master":                 secondFiles,
+		iofsSuite.commits[1][:7]: secondFiles,
+	} {
+		err := fstest.TestFS(iofsSuite.subFS(commitish), expected...)
+		iofsSuite.Nil(err, "commitish %v", commitish)
+	}
+}
+
+func (iofsSuite *iofsTestSuite) TestWalkDir() {
+	root := path.Join(testutils.LOCAL_REPO_NAME, "master")
+	var walked []string
+	err := fs.WalkDir(iofsSuite.fsys, root, func(walkedPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, walkedPath)
+		return nil
+	})
+	iofsSuite.Nil(err)
+	iofsSuite.Equal([]string{
+		root,
+		root + "/README.md",
+		root + "/docs",
+		root + "/docs/guide.md",
+		root + "/src",
+		root + "/src/main.go",
+		root + "/src/pkg",
+		root + "/src/pkg/empty.txt",
+		root + "/src/pkg/util.go",
+	}, walked)
+}
+
+func (iofsSuite *iofsTestSuite) TestReadFile() {
+	for filePath, expected := range testutils.LocalFiles {
+		contents, err := fs.ReadFile(iofsSuite.fsys, path.Join(testutils.LOCAL_REPO_NAME, "v1", filePath))
+		iofsSuite.Nil(err)
+		iofsSuite.Equal(expected, string(contents), filePath)
+	}
+}
+
+func (iofsSuite *iofsTestSuite) TestStat() {
+	for name, expectedName := range map[string]string{
+		".":                   ".",
+		"local":               "local",
+		"local/master":        "master",
+		"local/master/src":    "src",
+		"local/master/docs":   "docs",
+		"local/master/src/..": "",
+	} {
+		info, err := iofsSuite.fsys.Stat(name)
+		if len(expectedName) == 0 {
+			iofsSuite.True(errors.Is(err, fs.ErrInvalid), "stat %v: %v", name, err)
+			continue
+		}
+		iofsSuite.Nil(err, name)
+		iofsSuite.Equal(expectedName, info.Name())
+		iofsSuite.True(info.IsDir(), name)
+		iofsSuite.True(info.ModTime().IsZero(), name)
+	}
+
+	info, err := iofsSuite.fsys.Stat("local/master/src/main.go")
+	iofsSuite.Nil(err)
+	iofsSuite.Equal("main.go", info.Name())
+	iofsSuite.False(info.IsDir())
+	iofsSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size())
+}
+
+func (iofsSuite *iofsTestSuite) TestNotExist() {
+	for _, name := range []string{
+		"wat",
+		"local/wat",
+		"local/master/wat",
+		"local/v1/docs",
+		"local/master/src/main.go/wat",
+		".git",
+	} {
+		_, err := iofsSuite.fsys.Stat(name)
+		iofsSuite.True(errors.Is(err, fs.ErrNotExist), "stat %v: %v", name, err)
+		_, err = iofsSuite.fsys.Open(name)
+		iofsSuite.True(errors.Is(err, fs.ErrNotExist), "open %v: %v", name, err)
+		_, err = iofsSuite.fsys.ReadDir(name)
+		iofsSuite.True(errors.Is(err, fs.ErrNotExist), "readdir %v: %v", name, err)
+	}
+}
+
+func (iofsSuite *iofsTestSuite) TestInvalidPaths() {
+	for _, name := range []string{"", "/local", "local/", "../local", "local/master/../v1", "local//master"} {
+		_, err := iofsSuite.fsys.Open(name)
+		iofsSuite.True(errors.Is(err, fs.ErrInvalid), "open %v: %v", name, err)
+		_, err = iofsSuite.fsys.ReadDir(name)
+		iofsSuite.True(errors.Is(err, fs.ErrInvalid), "readdir %v: %v", name, err)
+	}
+}
+
+func (iofsSuite *iofsTestSuite) TestReadDirOfRootAndRepository() {
+	// repositories and commitishes aren't listed, as with the mounted fs
+	for _, name := range []string{".", "local"} {
+		entries, err := iofsSuite.fsys.ReadDir(name)
+		iofsSuite.Nil(err)
+		iofsSuite.Empty(entries)
+	}
+
+	_, err := iofsSuite.fsys.ReadDir("local/master/README.md")
+	iofsSuite.NotNil(err)
+}
+
+func (iofsSuite *iofsTestSuite) TestDirFile() {
+	file, err := iofsSuite.fsys.Open("local/master/src")
+	iofsSuite.Nil(err)
+	dirFile, isDir := file.(fs.ReadDirFile)
+	iofsSuite.True(isDir)
+
+	_, err = file.Read(make([]byte, 1))
+	iofsSuite.NotNil(err)
+
+	entries, err := dirFile.ReadDir(1)
+	iofsSuite.Nil(err)
+	iofsSuite.Len(entries, 1)
+	iofsSuite.Equal("main.go", entries[0].Name())
+	entries, err = dirFile.ReadDir(5)
+	iofsSuite.Nil(err)
+	iofsSuite.Len(entries, 1)
+	iofsSuite.Equal("pkg", entries[0].Name())
+	iofsSuite.True(entries[0].IsDir())
+	_, err = dirFile.ReadDir(1)
+	iofsSuite.Equal(io.EOF, err)
+
+	iofsSuite.Nil(file.Close())
+	iofsSuite.NotNil(file.Close())
+	_, err = dirFile.ReadDir(1)
+	iofsSuite.NotNil(err)
+}
+
+func (iofsSuite *iofsTestSuite) TestFileTooLarge() {
+	git.SetMaxFileSizeMB(0)
+	_, err := fs.ReadFile(iofsSuite.fsys, "local/master/src/main.go")
+	iofsSuite.True(errors.Is(err, git.ErrFileTooLarge), "read: %v", err)
+
+	// files are still listed and can be stat'ed
+	info, err := iofsSuite.fsys.Stat("local/master/src/main.go")
+	iofsSuite.Nil(err)
+	iofsSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size())
+}
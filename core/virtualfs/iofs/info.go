@@ -0,0 +1,73 @@
+package iofs
+
+import (
+	"io/fs"
+	"path"
+	"time"
+)
+
+// fileInfo reports the info of the git fs with its io/fs name, and without a modification time,
+// as the contents of a commitish never change
+type fileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	isDir bool
+}
+
+var _ fs.FileInfo = &fileInfo{}
+
+func newFileInfo(name string, info fs.FileInfo) *fileInfo {
+	return &fileInfo{
+		name:  path.Base(name),
+		size:  info.Size(),
+		mode:  info.Mode(),
+		isDir: info.IsDir(),
+	}
+}
+
+func (info *fileInfo) Name() string {
+	return info.name
+}
+
+func (info *fileInfo) Size() int64 {
+	return info.size
+}
+
+func (info *fileInfo) Mode() fs.FileMode {
+	return info.mode
+}
+
+func (info *fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (info *fileInfo) IsDir() bool {
+	return info.isDir
+}
+
+func (info *fileInfo) Sys() interface{} {
+	return nil
+}
+
+type dirEntry struct {
+	info *fileInfo
+}
+
+var _ fs.DirEntry = &dirEntry{}
+
+func (entry *dirEntry) Name() string {
+	return entry.info.Name()
+}
+
+func (entry *dirEntry) IsDir() bool {
+	return entry.info.IsDir()
+}
+
+func (entry *dirEntry) Type() fs.FileMode {
+	return entry.info.Mode().Type()
+}
+
+func (entry *dirEntry) Info() (fs.FileInfo, error) {
+	return entry.info, nil
+}
@@ -0,0 +1,116 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"os"
+	"sync"
+)
+
+type Commitish struct {
+	id         NodeID
+	name       string
+	canonical  string
+	repository *Repository
+	root       *Entry
+	mutex      *sync.Mutex
+}
+
+var _ Node = &Commitish{}
+
+// NewCommitish resolves the commitish to its canonical name, its tree is only listed on first access
+func NewCommitish(repository *Repository, name string) (commitish *Commitish, err error) {
+	var canonical string
+	canonical, err = repository.provider.Canonicalize(name)
+	if errors.Is(err, git.ErrRevisionNotFound) {
+		return nil, fmt.Errorf("commitish %v of %v: %v: %w", name, repository.name, err, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("NewCommitish: %v as %v :: %v", name, canonical, repository.clonePath)
+	return &Commitish{
+		id:         nextNodeID(),
+		name:       name,
+		canonical:  canonical,
+		repository: repository,
+		mutex:      &sync.Mutex{},
+	}, nil
+}
+
+func (commitish *Commitish) fetchContentIfNeeded() (root *Entry, err error) {
+	return commitish.fetchContent(git.InteractivePriority)
+}
+
+// fetchContent lists the tree of the commitish on first access, with the given priority
+func (commitish *Commitish) fetchContent(priority git.Priority) (root *Entry, err error) {
+	commitish.mutex.Lock()
+	root = commitish.root
+	commitish.mutex.Unlock()
+	if root != nil {
+		return
+	}
+	// listed without holding the mutex, as the repository already lists each tree once for all commitishes waiting for it
+	root, err = commitish.repository.root(commitish, priority)
+	if err != nil {
+		return nil, err
+	}
+	commitish.mutex.Lock()
+	defer commitish.mutex.Unlock()
+	if commitish.root == nil {
+		commitish.root = root
+	}
+	return commitish.root, nil
+}
+
+func (commitish *Commitish) Id() NodeID {
+	return commitish.id
+}
+
+func (commitish *Commitish) Name() string {
+	return commitish.name
+}
+
+func (commitish *Commitish) IsDir() bool {
+	return true
+}
+
+func (commitish *Commitish) Size() int64 {
+	return 0
+}
+
+func (commitish *Commitish) IsMissing() bool {
+	return false
+}
+
+// Canonical is the sha of the commit of the commitish, followed by the path of its root if it has one
+func (commitish *Commitish) Canonical() string {
+	return commitish.canonical
+}
+
+func (commitish *Commitish) Repository() *Repository {
+	return commitish.repository
+}
+
+func (commitish *Commitish) Child(name string) (Node, error) {
+	root, err := commitish.fetchContentIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+	return root.Child(name)
+}
+
+// Children lists the root entry of the commitish, which reads the files of the root ahead as any listed directory
+func (commitish *Commitish) Children() ([]Node, error) {
+	root, err := commitish.fetchContentIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+	return root.Children()
+}
+
+func (commitish *Commitish) Contents() (string, error) {
+	return "", nil
+}
@@ -0,0 +1,113 @@
+package nodes
+
+import (
+	"gitreefs/core/git"
+	"path"
+	"sync"
+)
+
+// Entry is a file or a directory in the tree of a commitish. Its path isn't kept, but derived from its parents.
+type Entry struct {
+	id        NodeID
+	name      string
+	parent    *Entry
+	gitEntry  *git.Entry
+	commitish *Commitish
+	// children are created on first lookup, by the index of their names in the git entry
+	children []*Entry
+	// readSize is the size of a file whose blob was missing from a partial clone, once it's read
+	readSize int64
+	// the mutex isn't a pointer as elsewhere, sparing an allocation for each of the many entries
+	mutex sync.Mutex
+}
+
+var _ Node = &Entry{}
+
+func newEntry(commitish *Commitish, parent *Entry, id NodeID, name string, gitEntry *git.Entry) *Entry {
+	return &Entry{
+		id:        id,
+		name:      name,
+		parent:    parent,
+		gitEntry:  gitEntry,
+		commitish: commitish,
+	}
+}
+
+func (entry *Entry) Id() NodeID {
+	return entry.id
+}
+
+func (entry *Entry) Name() string {
+	return entry.name
+}
+
+// Path is the path of the entry relative to the root of its commitish
+func (entry *Entry) Path() string {
+	if entry.parent == nil {
+		return git.RootEntryPath
+	}
+	return path.Join(entry.parent.Path(), entry.name)
+}
+
+func (entry *Entry) IsDir() bool {
+	return entry.gitEntry.IsDir()
+}
+
+// Size is the size of a file, which for a file whose blob is missing is known only once its contents are read
+func (entry *Entry) Size() int64 {
+	if !entry.gitEntry.IsMissing() {
+		return entry.gitEntry.Size()
+	}
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	return entry.readSize
+}
+
+func (entry *Entry) IsMissing() bool {
+	return entry.gitEntry.IsMissing()
+}
+
+func (entry *Entry) childAt(index int) *Entry {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.children == nil {
+		entry.children = make([]*Entry, entry.gitEntry.Len())
+	}
+	if entry.children[index] == nil {
+		entry.children[index] = newEntry(
+			entry.commitish, entry, nextNodeID(), entry.gitEntry.Names()[index], entry.gitEntry.ChildAt(index))
+	}
+	return entry.children[index]
+}
+
+func (entry *Entry) Child(name string) (Node, error) {
+	index := entry.gitEntry.Index(name)
+	if index < 0 {
+		return nil, notFound(name, entry)
+	}
+	return entry.childAt(index), nil
+}
+
+func (entry *Entry) Children() (children []Node, err error) {
+	entry.commitish.repository.provider.ReadAhead(entry.commitish.canonical, entry.Path())
+	children = make([]Node, entry.gitEntry.Len())
+	for i := range children {
+		children[i] = entry.childAt(i)
+	}
+	return
+}
+
+func (entry *Entry) Contents() (string, error) {
+	if entry.IsDir() {
+		return "", nil
+	}
+	provider := entry.commitish.repository.provider
+	provider.ReadAhead(entry.commitish.canonical, git.ExtractDirPath(entry.Path()))
+	contents, err := provider.FileContents(entry.commitish.canonical, entry.Path())
+	if err == nil && entry.IsMissing() {
+		entry.mutex.Lock()
+		entry.readSize = int64(len(contents))
+		entry.mutex.Unlock()
+	}
+	return contents, err
+}
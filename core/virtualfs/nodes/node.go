@@ -0,0 +1,52 @@
+package nodes
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// NodeID identifies a node for the lifetime of the process, RootID being the id of every root
+type NodeID uint64
+
+const RootID NodeID = 1
+
+var (
+	allocatedNodeId = uint64(RootID)
+)
+
+func nextNodeID() NodeID {
+	return NodeID(atomic.AddUint64(&allocatedNodeId, 1))
+}
+
+// Node is an element of the virtual git fs, shared by all of its front ends:
+// the root holds repositories, a repository holds commitishes and a commitish holds the entries of its tree.
+// Nodes are added on first lookup and kept from then on, so a node's id is stable.
+type Node interface {
+	Id() NodeID
+	Name() string
+	IsDir() bool
+	Size() int64
+	// IsMissing tells whether a file's blob is missing from a partial clone, so its size is zero until its contents are read
+	IsMissing() bool
+	// Child looks a child up by name, adding it on first lookup. Errors of missing children wrap os.ErrNotExist
+	Child(name string) (Node, error)
+	// Children lists the children sorted by name. Repositories and commitishes aren't listed
+	Children() ([]Node, error)
+	Contents() (string, error)
+}
+
+func notFound(name string, parent Node) error {
+	return fmt.Errorf("no child with name %v under %v: %w", name, parent.Name(), os.ErrNotExist)
+}
+
+// Lookup walks from a node down the given names
+func Lookup(node Node, names ...string) (_ Node, err error) {
+	for _, name := range names {
+		node, err = node.Child(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
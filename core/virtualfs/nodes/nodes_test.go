@@ -0,0 +1,334 @@
+package nodes
+
+import (
+	"errors"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	testutils "gitreefs/test_utils"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nodesTestSuite struct {
+	suite.Suite
+	clonesPath string
+	commits    []string
+	root       *Root
+}
+
+func TestNodesTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/nodes_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(nodesTestSuite))
+}
+
+func (nodesSuite *nodesTestSuite) SetupTest() {
+	nodesSuite.clonesPath, nodesSuite.commits = testutils.SetupLocalClones()
+	var err error
+	nodesSuite.root, err = NewRoot(nodesSuite.clonesPath)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (nodesSuite *nodesTestSuite) TearDownTest() {
+	os.RemoveAll(nodesSuite.clonesPath)
+}
+
+func (nodesSuite *nodesTestSuite) TestLookup() {
+	for filePath, contents := range testutils.LocalFiles {
+		node, err := Lookup(nodesSuite.root, append([]string{testutils.LOCAL_REPO_NAME, "v1"}, splitPath(filePath)...)...)
+		nodesSuite.Nil(err, filePath)
+		nodesSuite.False(node.IsDir(), filePath)
+		nodesSuite.EqualValues(len(contents), node.Size(), filePath)
+		nodesSuite.Equal(filePath, node.(*Entry).Path())
+		actual, err := node.Contents()
+		nodesSuite.Nil(err)
+		nodesSuite.Equal(contents, actual, filePath)
+	}
+
+	node, err := Lookup(nodesSuite.root)
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(RootID, node.Id())
+}
+
+func (nodesSuite *nodesTestSuite) TestStableIds() {
+	first, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master", "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	second, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master", "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(first.Id(), second.Id())
+
+	other, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "v1", "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	nodesSuite.NotEqual(first.Id(), other.Id())
+}
+
+func (nodesSuite *nodesTestSuite) TestChildren() {
+	commitish, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master")
+	nodesSuite.Nil(err)
+	children, err := commitish.Children()
+	nodesSuite.Nil(err)
+	var names []string
+	for _, child := range children {
+		names = append(names, child.Name())
+		found, err := commitish.Child(child.Name())
+		nodesSuite.Nil(err)
+		nodesSuite.Equal(child.Id(), found.Id())
+	}
+	nodesSuite.Equal([]string{"README.md", "docs", "src"}, names)
+
+	// repositories and commitishes aren't listed
+	for _, node := range []Node{nodesSuite.root, commitish.(*Commitish).Repository()} {
+		children, err = node.Children()
+		nodesSuite.Nil(err)
+		nodesSuite.Empty(children)
+	}
+
+	file, err := Lookup(commitish, "README.md")
+	nodesSuite.Nil(err)
+	children, err = file.Children()
+	nodesSuite.Nil(err)
+	nodesSuite.Empty(children)
+}
+
+func (nodesSuite *nodesTestSuite) TestSharedTrees() {
+	master := nodesSuite.commits[1]
+	var commitishes []*Commitish
+	for _, name := range []string{"master", master, master[:7], "master^0"} {
+		node, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, name)
+		nodesSuite.Nil(err, name)
+		commitishes = append(commitishes, node.(*Commitish))
+	}
+	for i, commitish := range commitishes {
+		nodesSuite.Equal(master, commitish.Canonical())
+		_, err := commitish.Children()
+		nodesSuite.Nil(err)
+		// the aliases share the entries of the tree, so walking each of them doesn't add entries of its own
+		nodesSuite.Same(commitishes[0].root, commitish.root, commitish.Name())
+		if i > 0 {
+			// the aliases are still distinct nodes
+			nodesSuite.NotEqual(commitishes[0].Id(), commitish.Id())
+		}
+	}
+
+	byBranch, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master", "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	bySha, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, master, "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	nodesSuite.Same(byBranch, bySha)
+
+	other, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master~1")
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(nodesSuite.commits[0], other.(*Commitish).Canonical())
+
+	subtree, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master:src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(master+":src", subtree.(*Entry).commitish.Canonical())
+	nodesSuite.Equal("pkg/util.go", subtree.(*Entry).Path())
+	contents, err := subtree.Contents()
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(testutils.LocalFiles["src/pkg/util.go"], contents)
+}
+
+func (nodesSuite *nodesTestSuite) TestConcurrentTreeListing() {
+	commitish, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master")
+	nodesSuite.Nil(err)
+	roots := make(chan *Entry, 10)
+	for i := 0; i < cap(roots); i++ {
+		go func() {
+			root, _ := commitish.(*Commitish).repository.root(commitish.(*Commitish), git.InteractivePriority)
+			roots <- root
+		}()
+	}
+	first := <-roots
+	nodesSuite.NotNil(first)
+	for i := 1; i < cap(roots); i++ {
+		nodesSuite.Same(first, <-roots)
+	}
+}
+
+func (nodesSuite *nodesTestSuite) TestSharedRootsBound() {
+	node, err := nodesSuite.root.Child(testutils.LOCAL_REPO_NAME)
+	nodesSuite.Nil(err)
+	repository := node.(*Repository)
+	repository.rootByCanonical, err = simplelru.NewLRU(1, nil)
+	nodesSuite.Nil(err)
+
+	master, err := Lookup(repository, "master", "src")
+	nodesSuite.Nil(err)
+	_, err = Lookup(repository, "master~1", "src")
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(1, repository.rootByCanonical.Len())
+
+	// an alias of an evicted root lists it anew, while the commitishes holding it keep it
+	again, err := Lookup(repository, nodesSuite.commits[1], "src")
+	nodesSuite.Nil(err)
+	nodesSuite.False(master == again)
+	sameAgain, err := Lookup(repository, "master", "src")
+	nodesSuite.Nil(err)
+	nodesSuite.Same(master, sameAgain)
+}
+
+func (nodesSuite *nodesTestSuite) TestRepositoryOpenedOutsideMapLock() {
+	// a repository being opened, e.g. waiting for its clone, holds only its own pending lookup
+	blocked := &pendingRepository{mutex: &sync.Mutex{}}
+	blocked.mutex.Lock()
+	defer blocked.mutex.Unlock()
+	nodesSuite.root.repositoriesByName.Set("blocked", blocked)
+
+	opened := make(chan error, 1)
+	go func() {
+		_, err := nodesSuite.root.Child(testutils.LOCAL_REPO_NAME)
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		nodesSuite.Nil(err)
+	case <-time.After(10 * time.Second):
+		nodesSuite.Fail("lookup waited for another repository")
+	}
+
+	repositories := make(chan Node, 10)
+	for i := 0; i < cap(repositories); i++ {
+		go func() {
+			repository, _ := nodesSuite.root.Child(testutils.LOCAL_REPO_NAME)
+			repositories <- repository
+		}()
+	}
+	first := <-repositories
+	nodesSuite.NotNil(first)
+	for i := 1; i < cap(repositories); i++ {
+		nodesSuite.Same(first, <-repositories)
+	}
+}
+
+func (nodesSuite *nodesTestSuite) TestCommitishResolvedOutsideMapLock() {
+	repository, err := nodesSuite.root.Child(testutils.LOCAL_REPO_NAME)
+	nodesSuite.Nil(err)
+	// a commitish being resolved, e.g. fetching a missing sha, holds only its own pending lookup
+	blocked := &pendingCommitish{mutex: &sync.Mutex{}}
+	blocked.mutex.Lock()
+	defer blocked.mutex.Unlock()
+	repository.(*Repository).commitishByName.Set(nodesSuite.commits[0], blocked)
+
+	resolved := make(chan error, 1)
+	go func() {
+		_, err := repository.Child("master")
+		resolved <- err
+	}()
+	select {
+	case err = <-resolved:
+		nodesSuite.Nil(err)
+	case <-time.After(10 * time.Second):
+		nodesSuite.Fail("lookup waited for another commitish")
+	}
+}
+
+func (nodesSuite *nodesTestSuite) TestNotFound() {
+	for _, names := range [][]string{
+		{"wat"},
+		{testutils.LOCAL_REPO_NAME, "wat"},
+		{testutils.LOCAL_REPO_NAME, "0000000"},
+		{testutils.LOCAL_REPO_NAME, "v1", "docs"},
+		{testutils.LOCAL_REPO_NAME, "master", "README.md", "wat"},
+	} {
+		node, err := Lookup(nodesSuite.root, names...)
+		nodesSuite.Nil(node)
+		nodesSuite.True(errors.Is(err, os.ErrNotExist), "lookup %v: %v", names, err)
+	}
+
+	for _, name := range []string{"..", "--upload-pack=touch"} {
+		_, err := nodesSuite.root.Child(name)
+		nodesSuite.True(errors.Is(err, common.ErrInvalidRepositoryName), name)
+	}
+}
+
+func (nodesSuite *nodesTestSuite) TestReadAhead() {
+	nodesSuite.Nil(git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 1024 * 1024}))
+	defer git.SetBlobCache(nil)
+	nodesSuite.Nil(git.SetReadAhead(&git.ReadAhead{MaxBlobSizeBytes: 1024}))
+
+	src, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master", "src", "pkg")
+	nodesSuite.Nil(err)
+	_, err = src.Children()
+	nodesSuite.Nil(err)
+	deadline := time.Now().Add(10 * time.Second)
+	for git.CurrentBlobCacheStats().ReadAhead < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	util, err := src.Child("util.go")
+	nodesSuite.Nil(err)
+	contents, err := util.Contents()
+	nodesSuite.Nil(err)
+	nodesSuite.Equal(testutils.LocalFiles["src/pkg/util.go"], contents)
+	stats := git.CurrentBlobCacheStats()
+	nodesSuite.EqualValues(2, stats.ReadAhead)
+	nodesSuite.EqualValues(1, stats.ReadAheadHits)
+}
+
+func (nodesSuite *nodesTestSuite) TestReadAheadOfCommitishRoot() {
+	nodesSuite.Nil(git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 1024 * 1024}))
+	defer git.SetBlobCache(nil)
+	nodesSuite.Nil(git.SetReadAhead(&git.ReadAhead{MaxBlobSizeBytes: 1024}))
+
+	commitish, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master")
+	nodesSuite.Nil(err)
+	_, err = commitish.Children()
+	nodesSuite.Nil(err)
+	deadline := time.Now().Add(10 * time.Second)
+	for git.CurrentBlobCacheStats().ReadAhead < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	readme, err := commitish.Child("README.md")
+	nodesSuite.Nil(err)
+	_, err = readme.Contents()
+	nodesSuite.Nil(err)
+	stats := git.CurrentBlobCacheStats()
+	nodesSuite.EqualValues(1, stats.ReadAhead)
+	nodesSuite.EqualValues(1, stats.ReadAheadHits)
+}
+
+func (nodesSuite *nodesTestSuite) TestPrefetch() {
+	nodesSuite.Nil(git.SetBlobCache(&git.BlobCache{MaxSizeBytes: 1024 * 1024}))
+	defer git.SetBlobCache(nil)
+
+	job, err := StartPrefetch(nodesSuite.root, []PrefetchTarget{
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master", Globs: []string{"src/pkg", "*.md"}},
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "wat"},
+	})
+	nodesSuite.Nil(err)
+	progress := job.Wait()
+	nodesSuite.True(progress.Done)
+	nodesSuite.Equal(2, progress.Targets)
+	nodesSuite.Equal(1, progress.TargetsListed)
+	nodesSuite.Equal(3, progress.Files)
+	nodesSuite.Equal(3, progress.FilesRead)
+	nodesSuite.EqualValues(len(testutils.LocalFiles["README.md"])+len(testutils.LocalFiles["src/pkg/util.go"]), progress.BytesRead)
+	nodesSuite.Equal(1, progress.Failed)
+	nodesSuite.Len(progress.Errors, 1)
+
+	util, err := Lookup(nodesSuite.root, testutils.LOCAL_REPO_NAME, "master", "src", "pkg", "util.go")
+	nodesSuite.Nil(err)
+	_, err = util.Contents()
+	nodesSuite.Nil(err)
+	stats := git.CurrentBlobCacheStats()
+	nodesSuite.EqualValues(1, stats.ReadAheadHits)
+	nodesSuite.EqualValues(0, stats.Misses)
+
+	_, err = StartPrefetch(nodesSuite.root, []PrefetchTarget{
+		{Repository: testutils.LOCAL_REPO_NAME, Commitish: "master", Globs: []string{"src/["}},
+	})
+	nodesSuite.NotNil(err)
+}
+
+func splitPath(filePath string) []string {
+	return strings.Split(filePath, "/")
+}
@@ -0,0 +1,190 @@
+package nodes
+
+import (
+	"fmt"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"path"
+	"sync"
+)
+
+const (
+	// files of a target are read by this many goroutines, each waiting for a prefetch worker of the git layer
+	prefetchFileReaders = 4
+	// errors beyond these many are only counted
+	maxPrefetchErrors = 100
+)
+
+// PrefetchTarget is a commitish of a repository whose tree is prefetched, along with its files matching any of the globs.
+// A glob matches the path of a file or of any of its directories, and no globs match all files.
+type PrefetchTarget struct {
+	Repository string   `json:"repository"`
+	Commitish  string   `json:"commitish"`
+	Globs      []string `json:"globs,omitempty"`
+}
+
+// PrefetchProgress is the progress of a prefetch job, files are counted once the tree of their target is listed
+type PrefetchProgress struct {
+	Targets       int      `json:"targets"`
+	TargetsListed int      `json:"targets_listed"`
+	Files         int      `json:"files"`
+	FilesRead     int      `json:"files_read"`
+	BytesRead     int64    `json:"bytes_read"`
+	Failed        int      `json:"failed"`
+	Errors        []string `json:"errors,omitempty"`
+	Done          bool     `json:"done"`
+}
+
+// PrefetchJob lists the trees of its targets and reads their files into the blob cache in the background
+type PrefetchJob struct {
+	progress PrefetchProgress
+	done     chan struct{}
+	mutex    *sync.Mutex
+}
+
+// StartPrefetch starts prefetching the targets with the prefetch priority, so lookups aren't kept waiting.
+// Files are only read if the blob cache is enabled.
+func StartPrefetch(root *Root, targets []PrefetchTarget) (job *PrefetchJob, err error) {
+	for _, target := range targets {
+		for _, glob := range target.Globs {
+			_, err = path.Match(glob, "")
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob '%v' of %v/%v: %w", glob, target.Repository, target.Commitish, err)
+			}
+		}
+	}
+	job = &PrefetchJob{
+		progress: PrefetchProgress{Targets: len(targets)},
+		done:     make(chan struct{}),
+		mutex:    &sync.Mutex{},
+	}
+	go job.run(root, targets)
+	return job, nil
+}
+
+// Progress returns a copy of the progress of the job
+func (job *PrefetchJob) Progress() PrefetchProgress {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	progress := job.progress
+	progress.Errors = append([]string(nil), job.progress.Errors...)
+	return progress
+}
+
+// Wait waits for the job to be done, returning its final progress
+func (job *PrefetchJob) Wait() PrefetchProgress {
+	<-job.done
+	return job.Progress()
+}
+
+func (job *PrefetchJob) update(update func(progress *PrefetchProgress)) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	update(&job.progress)
+}
+
+func (job *PrefetchJob) fail(err error) {
+	logger.Info("Prefetch: %v", err)
+	job.update(func(progress *PrefetchProgress) {
+		progress.Failed++
+		if len(progress.Errors) < maxPrefetchErrors {
+			progress.Errors = append(progress.Errors, err.Error())
+		}
+	})
+}
+
+func (job *PrefetchJob) run(root *Root, targets []PrefetchTarget) {
+	defer close(job.done)
+	for _, target := range targets {
+		err := job.prefetch(root, target)
+		if err != nil {
+			job.fail(fmt.Errorf("%v/%v: %w", target.Repository, target.Commitish, err))
+		}
+	}
+	job.update(func(progress *PrefetchProgress) {
+		progress.Done = true
+	})
+	logger.Info("Prefetch of %v targets done", len(targets))
+}
+
+func (job *PrefetchJob) prefetch(root *Root, target PrefetchTarget) (err error) {
+	var node Node
+	node, err = Lookup(root, target.Repository, target.Commitish)
+	if err != nil {
+		return
+	}
+	commitish := node.(*Commitish)
+	var rootEntry *Entry
+	rootEntry, err = commitish.fetchContent(git.PrefetchPriority)
+	if err != nil {
+		return
+	}
+
+	var filePaths []string
+	if git.BlobCacheEnabled() {
+		walkFiles(rootEntry.gitEntry, git.RootEntryPath, func(filePath string) {
+			if matchesAny(target.Globs, filePath) {
+				filePaths = append(filePaths, filePath)
+			}
+		})
+	}
+	job.update(func(progress *PrefetchProgress) {
+		progress.TargetsListed++
+		progress.Files += len(filePaths)
+	})
+
+	paths := make(chan string)
+	waitGroup := &sync.WaitGroup{}
+	for i := 0; i < prefetchFileReaders; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for filePath := range paths {
+				size, readErr := commitish.repository.provider.PrefetchFile(commitish.canonical, filePath)
+				if readErr != nil {
+					job.fail(fmt.Errorf("%v/%v/%v: %w", target.Repository, target.Commitish, filePath, readErr))
+					continue
+				}
+				job.update(func(progress *PrefetchProgress) {
+					progress.FilesRead++
+					progress.BytesRead += size
+				})
+			}
+		}()
+	}
+	for _, filePath := range filePaths {
+		paths <- filePath
+	}
+	close(paths)
+	waitGroup.Wait()
+	return nil
+}
+
+// walkFiles calls visit with the path of every file under a directory
+func walkFiles(dir *git.Entry, dirPath string, visit func(filePath string)) {
+	for i, name := range dir.Names() {
+		child := dir.ChildAt(i)
+		childPath := path.Join(dirPath, name)
+		if child.IsDir() {
+			walkFiles(child, childPath, visit)
+		} else {
+			visit(childPath)
+		}
+	}
+}
+
+// matchesAny tells whether any of the globs matches a file or any of its directories, which is always so without globs
+func matchesAny(globs []string, filePath string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		for matchedPath := filePath; matchedPath != "."; matchedPath = path.Dir(matchedPath) {
+			matched, _ := path.Match(glob, matchedPath)
+			if matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package nodes
+
+import (
+	"fmt"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/orcaman/concurrent-map"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"os"
+	"sync"
+)
+
+const (
+	// the roots of up to these many canonical commitishes of each repository are kept for commitishes resolving to them
+	maxSharedRoots = 1024
+)
+
+type Repository struct {
+	id              NodeID
+	name            string
+	clonePath       string
+	provider        *git.RepositoryProvider
+	commitishByName cmap.ConcurrentMap
+	rootByCanonical *simplelru.LRU
+	rootsMutex      *sync.Mutex
+}
+
+// sharedRoot is the root entry of a canonical commitish, shared by all the commitishes resolving to it,
+// so walks of a branch and of its sha look up the same entries
+type sharedRoot struct {
+	root  *Entry
+	mutex *sync.Mutex
+}
+
+// pendingCommitish is a commitish resolved once, with concurrent first lookups waiting for the same resolution
+type pendingCommitish struct {
+	commitish *Commitish
+	mutex     *sync.Mutex
+}
+
+var _ Node = &Repository{}
+
+func NewRepository(clonesPath string, name string) (repository *Repository, err error) {
+	err = git.EnsureClone(clonesPath, name)
+	if err != nil {
+		return
+	}
+	clonePath, err := common.ResolveClonePath(clonesPath, name)
+	if err != nil {
+		return
+	}
+	var provider *git.RepositoryProvider
+	provider, err = git.NewRepositoryProvider(clonePath)
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("no clone of %v at %v: %w", name, clonePath, os.ErrNotExist)
+	}
+	rootByCanonical, err := simplelru.NewLRU(maxSharedRoots, nil)
+	if err != nil {
+		provider.Close()
+		return nil, err
+	}
+	repository = &Repository{
+		id:              nextNodeID(),
+		name:            name,
+		clonePath:       clonePath,
+		provider:        provider,
+		commitishByName: cmap.New(),
+		rootByCanonical: rootByCanonical,
+		rootsMutex:      &sync.Mutex{},
+	}
+	logger.Debug("NewRepository: %v", clonePath)
+	return
+}
+
+func (repository *Repository) Id() NodeID {
+	return repository.id
+}
+
+func (repository *Repository) Name() string {
+	return repository.name
+}
+
+func (repository *Repository) IsDir() bool {
+	return true
+}
+
+func (repository *Repository) Size() int64 {
+	return 0
+}
+
+func (repository *Repository) IsMissing() bool {
+	return false
+}
+
+func (repository *Repository) Provider() *git.RepositoryProvider {
+	return repository.provider
+}
+
+func (repository *Repository) Child(name string) (child Node, err error) {
+	wrapped :=
+		repository.commitishByName.Upsert(name, nil, func(found bool, existingValue interface{}, _ interface{}) interface{} {
+			if found {
+				return existingValue
+			}
+			return &pendingCommitish{mutex: &sync.Mutex{}}
+		})
+	pending := wrapped.(*pendingCommitish)
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	if pending.commitish == nil {
+		// resolved, and possibly fetched, outside of the map's lock, so lookups of other commitishes don't wait for it.
+		// Failures aren't kept, so the next lookup tries again.
+		pending.commitish, err = NewCommitish(repository, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pending.commitish, nil
+}
+
+func (repository *Repository) sharedRoot(canonical string) *sharedRoot {
+	repository.rootsMutex.Lock()
+	defer repository.rootsMutex.Unlock()
+	value, found := repository.rootByCanonical.Get(canonical)
+	if found {
+		return value.(*sharedRoot)
+	}
+	shared := &sharedRoot{mutex: &sync.Mutex{}}
+	repository.rootByCanonical.Add(canonical, shared)
+	return shared
+}
+
+// root lists the tree of a commitish's canonical commitish once, with concurrent first listings waiting for the same one,
+// and returns its root entry shared by all commitishes resolving to it.
+// Roots evicted from the bounded map stay with the commitishes already holding them, and are listed again for others.
+func (repository *Repository) root(commitish *Commitish, priority git.Priority) (root *Entry, err error) {
+	shared := repository.sharedRoot(commitish.canonical)
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+	if shared.root != nil {
+		return shared.root, nil
+	}
+	var gitRoot *git.Entry
+	gitRoot, err = repository.provider.ListTreeWithPriority(commitish.canonical, priority)
+	if err != nil {
+		return nil, err
+	}
+	shared.root = newEntry(commitish, nil, nextNodeID(), commitish.canonical, gitRoot)
+	return shared.root, nil
+}
+
+func (repository *Repository) Children() ([]Node, error) {
+	// commitishes aren't listed, as there is no use case to list all possible commitishes
+	return []Node{}, nil
+}
+
+func (repository *Repository) Contents() (string, error) {
+	return "", nil
+}
@@ -0,0 +1,77 @@
+package nodes
+
+import (
+	"github.com/orcaman/concurrent-map"
+	"sync"
+)
+
+type Root struct {
+	clonesPath         string
+	repositoriesByName cmap.ConcurrentMap
+}
+
+var _ Node = &Root{}
+
+// pendingRepository is a repository opened once, with concurrent first lookups waiting for the same opening
+type pendingRepository struct {
+	repository *Repository
+	mutex      *sync.Mutex
+}
+
+func NewRoot(clonesPath string) (root *Root, err error) {
+	return &Root{
+		clonesPath:         clonesPath,
+		repositoriesByName: cmap.New(),
+	}, nil
+}
+
+func (root *Root) Id() NodeID {
+	return RootID
+}
+
+func (root *Root) Name() string {
+	return ""
+}
+
+func (root *Root) IsDir() bool {
+	return true
+}
+
+func (root *Root) Size() int64 {
+	return 0
+}
+
+func (root *Root) IsMissing() bool {
+	return false
+}
+
+func (root *Root) Child(name string) (child Node, err error) {
+	wrapped :=
+		root.repositoriesByName.Upsert(name, nil, func(found bool, existingValue interface{}, _ interface{}) interface{} {
+			if found {
+				return existingValue
+			}
+			return &pendingRepository{mutex: &sync.Mutex{}}
+		})
+	pending := wrapped.(*pendingRepository)
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	if pending.repository == nil {
+		// opened, and possibly cloned, outside of the map's lock, so lookups of other repositories don't wait for it.
+		// Failures aren't kept, so the next lookup tries again.
+		pending.repository, err = NewRepository(root.clonesPath, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pending.repository, nil
+}
+
+func (root *Root) Children() ([]Node, error) {
+	// repositories aren't listed, as there is no use case to list all clones
+	return []Node{}, nil
+}
+
+func (root *Root) Contents() (string, error) {
+	return "", nil
+}
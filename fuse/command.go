@@ -0,0 +1,159 @@
+package fuse
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"gitreefs/control"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/nodes"
+	"gitreefs/fuse/fuseserver"
+	"golang.org/x/net/context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// App mounts a forest of git trees backed by FUSE
+type App struct {
+}
+
+var _ common.App = &App{}
+
+func (app *App) DeclareCommand() cli.Command {
+	command := cli.Command{
+		Name:      "mount",
+		Usage:     "Mount a forest of git trees as a virtual file system backed by FUSE",
+		ArgsUsage: "[clones-path mount-point]",
+		Description: `ARGS:
+    clones-path   path to a directory containing git clones (with .git in them), or set by --clones-path
+    mount-point   path to target location to mount the virtual fs at, or set by --mount-point`,
+		Flags: append(common.SharedFlags("DEBUG"),
+
+			cli.StringFlag{
+				Name:  "mount-point",
+				Value: "",
+				Usage: "Path to mount the virtual fs at, if not given as an argument.",
+			},
+
+			cli.StringFlag{
+				Name:  "access-policy",
+				Value: "",
+				Usage: "Path to a JSON file of the repositories each uid or group may access, reloaded on change. By default all repositories are accessible.",
+			},
+
+			cli.DurationFlag{
+				Name:  "access-policy-reload-interval",
+				Value: fuseserver.DefaultPolicyReloadInterval,
+				Usage: "Interval for checking the access policy for changes.",
+			},
+
+			cli.IntFlag{
+				Name:  "uid",
+				Value: os.Getuid(),
+				Usage: "Owner of all files and directories.",
+			},
+
+			cli.IntFlag{
+				Name:  "gid",
+				Value: os.Getgid(),
+				Usage: "Group of all files and directories.",
+			},
+
+			cli.StringFlag{
+				Name:  "file-mode",
+				Value: "0777",
+				Usage: "Permission bits of all files, in octal.",
+			},
+
+			cli.StringFlag{
+				Name:  "dir-mode",
+				Value: "0777",
+				Usage: "Permission bits of all directories, in octal.",
+			},
+
+			cli.BoolFlag{
+				Name:  "allow-other",
+				Usage: "Let users other than the mounting one access the mount (requires user_allow_other in /etc/fuse.conf unless mounting as root).",
+			},
+
+			cli.BoolFlag{
+				Name:  "default-permissions",
+				Usage: "Have the kernel check access by the owner, group and permission bits.",
+			},
+		),
+	}
+	common.DeclareConfig(&command, "fuse")
+	return command
+}
+
+func (app *App) RunUntilStopped(opts common.Options) (err error) {
+
+	clonesPath := opts.(*options).ClonesPath
+	mountPoint := opts.(*options).mountPoint
+	logger.Info("Mounting: %v --> %v", clonesPath, mountPoint)
+	err = git.Configure(opts.(*options).SharedOptions)
+	if err != nil {
+		return
+	}
+
+	root, err := nodes.NewRoot(clonesPath)
+	if err != nil {
+		return
+	}
+	config := &fuseserver.Config{
+		Permissions:        opts.(*options).permissions,
+		AllowOther:         opts.(*options).allowOther,
+		DefaultPermissions: opts.(*options).defaultPermissions,
+		Root:               root,
+	}
+	if accessPolicy := opts.(*options).accessPolicy; len(accessPolicy) > 0 {
+		config.Policy, err = fuseserver.WatchAccessPolicy(accessPolicy, opts.(*options).policyReloadInterval)
+		if err != nil {
+			return err
+		}
+		defer config.Policy.Close()
+		logger.Info("Allowing access by the access policy at %v", accessPolicy)
+	}
+
+	controlServer, err := control.ServeShared(opts.(*options).SharedOptions, root)
+	if err != nil {
+		return err
+	}
+	if controlServer != nil {
+		defer controlServer.Close()
+	}
+
+	mountedFs, err := fuseserver.Mount(clonesPath, mountPoint, config, false)
+	if err != nil {
+		return fmt.Errorf("mountFs: %w", err)
+	}
+	logger.Info("fileHandler system has been successfully mounted.")
+
+	registerSignalHandler(mountedFs.Dir())
+
+	err = mountedFs.Join(context.Background())
+	if err != nil {
+		return fmt.Errorf("MountedFileSystem.Join: %w", err)
+	}
+
+	logger.Info("fileHandler system has been successfully un-mounted.")
+
+	return nil
+}
+
+func registerSignalHandler(mountPoint string) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for {
+			<-signalChan
+			logger.Info("Received SIGINT, attempting to unmount...")
+			err := fuseserver.Unmount(mountPoint)
+			if err != nil {
+				logger.Error("Failed to unmount: %v", err)
+			}
+		}
+	}()
+}
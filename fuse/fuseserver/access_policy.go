@@ -0,0 +1,306 @@
+package fuseserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"gitreefs/core/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultPolicyReloadInterval = 5 * time.Second
+	// callers of processes are read from /proc at most once in this time, for up to these many processes
+	callerCacheExpiry = time.Second
+	callerCacheSize   = 4096
+)
+
+// AccessPolicy decides which repositories callers may access by their uid and groups, loaded from a JSON file such as:
+//
+//	{
+//	  "rules": [
+//	    {"uids": [1000], "repositories": ["*"]},
+//	    {"gids": [100], "repositories": ["public-*", "docs"]}
+//	  ]
+//	}
+//
+// A caller may access a repository if any rule matching its uid, primary or supplementary groups
+// has a repository glob (as in path.Match) matching the repository name, all other access is denied.
+type AccessPolicy struct {
+	rules []*accessRule
+}
+
+type accessRule struct {
+	uids         map[uint32]bool
+	gids         map[uint32]bool
+	repositories []string
+}
+
+type accessRuleFile struct {
+	Uids         []uint32 `json:"uids"`
+	Gids         []uint32 `json:"gids"`
+	Repositories []string `json:"repositories"`
+}
+
+type accessPolicyFile struct {
+	Rules []accessRuleFile `json:"rules"`
+}
+
+// caller is the user performing a file system operation
+type caller struct {
+	uid  uint32
+	gids []uint32
+}
+
+func toSet(ids []uint32) map[uint32]bool {
+	set := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func ParseAccessPolicy(contents []byte) (policy *AccessPolicy, err error) {
+	var file accessPolicyFile
+	err = json.Unmarshal(contents, &file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access policy: %w", err)
+	}
+	policy = &AccessPolicy{}
+	for _, rule := range file.Rules {
+		if len(rule.Uids) == 0 && len(rule.Gids) == 0 {
+			return nil, fmt.Errorf("invalid access policy: rule for %v without uids or gids", rule.Repositories)
+		}
+		for _, glob := range rule.Repositories {
+			_, err = path.Match(glob, "")
+			if err != nil {
+				return nil, fmt.Errorf("invalid access policy: bad repository glob '%v': %w", glob, err)
+			}
+		}
+		policy.rules = append(policy.rules, &accessRule{
+			uids:         toSet(rule.Uids),
+			gids:         toSet(rule.Gids),
+			repositories: rule.Repositories,
+		})
+	}
+	return
+}
+
+func LoadAccessPolicy(policyPath string) (*AccessPolicy, error) {
+	contents, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy at %v: %w", policyPath, err)
+	}
+	return ParseAccessPolicy(contents)
+}
+
+func (rule *accessRule) matches(caller *caller) bool {
+	if rule.uids[caller.uid] {
+		return true
+	}
+	for _, gid := range caller.gids {
+		if rule.gids[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *accessRule) allowsRepository(repository string) bool {
+	for _, glob := range rule.repositories {
+		matched, _ := path.Match(glob, repository)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *AccessPolicy) allows(caller *caller, repository string) bool {
+	if caller == nil {
+		return false
+	}
+	for _, rule := range policy.rules {
+		if rule.matches(caller) && rule.allowsRepository(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyWatcher keeps the access policy at a path up to date, reloading it when the file changes
+type PolicyWatcher struct {
+	policyPath string
+	policy     atomic.Value
+	modTime    time.Time
+	size       int64
+	stop       chan struct{}
+}
+
+// WatchAccessPolicy loads the access policy at a path, and checks it for changes every interval until closed
+func WatchAccessPolicy(policyPath string, interval time.Duration) (watcher *PolicyWatcher, err error) {
+	watcher = &PolicyWatcher{
+		policyPath: policyPath,
+		stop:       make(chan struct{}),
+	}
+	_, err = watcher.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go watcher.reloadPeriodically(interval)
+	}
+	return
+}
+
+func (watcher *PolicyWatcher) Policy() *AccessPolicy {
+	return watcher.policy.Load().(*AccessPolicy)
+}
+
+func (watcher *PolicyWatcher) Close() {
+	close(watcher.stop)
+}
+
+func (watcher *PolicyWatcher) reloadIfChanged() (reloaded bool, err error) {
+	info, err := os.Stat(watcher.policyPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read access policy at %v: %w", watcher.policyPath, err)
+	}
+	if info.ModTime().Equal(watcher.modTime) && info.Size() == watcher.size {
+		return false, nil
+	}
+	// an invalid change is reported once, and retried only when the file changes again
+	watcher.modTime = info.ModTime()
+	watcher.size = info.Size()
+	policy, err := LoadAccessPolicy(watcher.policyPath)
+	if err != nil {
+		return false, err
+	}
+	watcher.policy.Store(policy)
+	return true, nil
+}
+
+func (watcher *PolicyWatcher) reloadPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+			reloaded, err := watcher.reloadIfChanged()
+			if err != nil {
+				logger.Error("PolicyWatcher: keeping the previous access policy: %v", err)
+			} else if reloaded {
+				logger.Info("PolicyWatcher: reloaded access policy from %v", watcher.policyPath)
+			}
+		}
+	}
+}
+
+func parseIds(fields []string) (ids []uint32, err error) {
+	for _, field := range fields {
+		var id uint64
+		id, err = strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+	}
+	return
+}
+
+// callerOfPid reads the file system uid and groups of a process from /proc, as fuse only reports the caller's pid
+func callerOfPid(pid uint32) (*caller, error) {
+	if pid == 0 {
+		return nil, fmt.Errorf("unknown caller process")
+	}
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStatus(status)
+}
+
+// callerCache keeps the callers of processes for a short time, sparing reading /proc on every operation.
+// A process changing its ids, or a pid reused by another process, is seen as its previous caller until it expires.
+type callerCache struct {
+	read    func(pid uint32) (*caller, error)
+	expiry  time.Duration
+	callers *simplelru.LRU
+	mutex   *sync.Mutex
+}
+
+type cachedCaller struct {
+	caller  *caller
+	expires time.Time
+}
+
+func newCallerCache(read func(pid uint32) (*caller, error), expiry time.Duration, size int) *callerCache {
+	// the size is always positive, which is all NewLRU checks
+	callers, _ := simplelru.NewLRU(size, nil)
+	return &callerCache{
+		read:    read,
+		expiry:  expiry,
+		callers: callers,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// callerOf returns the caller of a process, reading it only if it isn't cached or expired. Failures aren't cached.
+func (cache *callerCache) callerOf(pid uint32) (result *caller, err error) {
+	now := time.Now()
+	cache.mutex.Lock()
+	value, found := cache.callers.Get(pid)
+	cache.mutex.Unlock()
+	if found && now.Before(value.(*cachedCaller).expires) {
+		return value.(*cachedCaller).caller, nil
+	}
+	result, err = cache.read(pid)
+	if err != nil {
+		return nil, err
+	}
+	cache.mutex.Lock()
+	cache.callers.Add(pid, &cachedCaller{caller: result, expires: now.Add(cache.expiry)})
+	cache.mutex.Unlock()
+	return
+}
+
+func parseProcStatus(status []byte) (result *caller, err error) {
+	var uids, gids, groups []uint32
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			uids, err = parseIds(fields[1:])
+		case "Gid:":
+			gids, err = parseIds(fields[1:])
+		case "Groups:":
+			groups, err = parseIds(fields[1:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid process status: %w", err)
+		}
+	}
+	// real, effective, saved and file system ids, access is checked by the file system ones
+	if len(uids) != 4 || len(gids) != 4 {
+		return nil, fmt.Errorf("invalid process status: missing uid or gid")
+	}
+	return &caller{
+		uid:  uids[3],
+		gids: append([]uint32{gids[3]}, groups...),
+	}, nil
+}
@@ -0,0 +1,240 @@
+package fuseserver
+
+import (
+	"fmt"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/stretchr/testify/assert"
+	testutils "gitreefs/test_utils"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const testPolicy = `{
+  "rules": [
+    {"uids": [1000], "repositories": ["*"]},
+    {"gids": [100], "repositories": ["public-*", "docs"]}
+  ]
+}`
+
+func parseTestPolicy(t *testing.T) *AccessPolicy {
+	policy, err := ParseAccessPolicy([]byte(testPolicy))
+	assert.Nil(t, err)
+	return policy
+}
+
+func writePolicy(t *testing.T, policyPath string, contents string) {
+	err := ioutil.WriteFile(policyPath, []byte(contents), 0644)
+	assert.Nil(t, err)
+}
+
+func TestParseInvalidAccessPolicies(t *testing.T) {
+	for _, contents := range []string{
+		`{"rules": [`,
+		`{"rules": [{"repositories": ["*"]}]}`,
+		`{"rules": [{"uids": [1000], "repositories": ["[a-"]}]}`,
+		`{"rules": [{"uids": [-1], "repositories": ["*"]}]}`,
+	} {
+		_, err := ParseAccessPolicy([]byte(contents))
+		assert.NotNil(t, err, "parsed %v", contents)
+	}
+}
+
+func TestAccessPolicyAllows(t *testing.T) {
+	policy := parseTestPolicy(t)
+
+	assert.True(t, policy.allows(&caller{uid: 1000, gids: []uint32{1000}}, "private"))
+	assert.True(t, policy.allows(&caller{uid: 1001, gids: []uint32{1001, 100}}, "public-repo"))
+	assert.True(t, policy.allows(&caller{uid: 1001, gids: []uint32{100}}, "docs"))
+	assert.False(t, policy.allows(&caller{uid: 1001, gids: []uint32{100}}, "private"))
+	assert.False(t, policy.allows(&caller{uid: 1001, gids: []uint32{100}}, "docs-old"))
+	assert.False(t, policy.allows(&caller{uid: 0, gids: []uint32{0}}, "public-repo"))
+	assert.False(t, policy.allows(nil, "public-repo"))
+}
+
+func TestParseProcStatus(t *testing.T) {
+	status := "Name:\tcat\nUid:\t1000\t1001\t1002\t1003\nGid:\t100\t101\t102\t103\nGroups:\t4 24 27 \n"
+	result, err := parseProcStatus([]byte(status))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1003, result.uid)
+	assert.Equal(t, []uint32{103, 4, 24, 27}, result.gids)
+
+	_, err = parseProcStatus([]byte("Name:\tcat\nGid:\t100\t101\t102\t103\n"))
+	assert.NotNil(t, err)
+	_, err = parseProcStatus([]byte("Uid:\ta\tb\tc\td\nGid:\t100\t101\t102\t103\n"))
+	assert.NotNil(t, err)
+}
+
+func TestCallerOfPid(t *testing.T) {
+	if _, err := os.Stat("/proc/self/status"); err != nil {
+		t.Skip("no procfs")
+	}
+	result, err := callerOfPid(uint32(os.Getpid()))
+	assert.Nil(t, err)
+	assert.EqualValues(t, os.Getuid(), result.uid)
+	assert.Contains(t, result.gids, uint32(os.Getgid()))
+
+	_, err = callerOfPid(0)
+	assert.NotNil(t, err)
+}
+
+func TestCallerCache(t *testing.T) {
+	reads := map[uint32]int{}
+	cache := newCallerCache(func(pid uint32) (*caller, error) {
+		reads[pid]++
+		if pid == 0 {
+			return nil, fmt.Errorf("unknown caller process")
+		}
+		return &caller{uid: pid + uint32(reads[pid])}, nil
+	}, 50*time.Millisecond, 2)
+
+	for i := 0; i < 3; i++ {
+		result, err := cache.callerOf(1000)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1001, result.uid)
+	}
+	assert.Equal(t, 1, reads[1000])
+
+	// failures are read again every time
+	_, err := cache.callerOf(0)
+	assert.NotNil(t, err)
+	_, err = cache.callerOf(0)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, reads[0])
+
+	// the least recently used process is evicted beyond the size
+	_, _ = cache.callerOf(2000)
+	_, _ = cache.callerOf(3000)
+	_, _ = cache.callerOf(1000)
+	assert.Equal(t, 2, reads[1000])
+
+	time.Sleep(60 * time.Millisecond)
+	result, err := cache.callerOf(3000)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3002, result.uid)
+}
+
+func TestWatchAccessPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	policyPath := dir + "/policy.json"
+
+	_, err = WatchAccessPolicy(policyPath, 0)
+	assert.NotNil(t, err)
+
+	writePolicy(t, policyPath, testPolicy)
+	watcher, err := WatchAccessPolicy(policyPath, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer watcher.Close()
+	assert.False(t, watcher.Policy().allows(&caller{uid: 2000}, "private"))
+
+	writePolicy(t, policyPath, `{"rules": [{"uids": [2000], "repositories": ["private"]}]}`)
+	assert.Eventually(t, func() bool {
+		return watcher.Policy().allows(&caller{uid: 2000}, "private")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// invalid changes keep the previous policy
+	writePolicy(t, policyPath, `{"rules": [`)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, watcher.Policy().allows(&caller{uid: 2000}, "private"))
+}
+
+// newPolicyFuseFs serves local clones to two callers, pid 1 of uid 1000 who may access every repository
+// and pid 2 of uid 2000 who may access none
+func newPolicyFuseFs(t *testing.T, clonesPath string) *fuseFs {
+	policyPath := clonesPath + ".policy.json"
+	writePolicy(t, policyPath, testPolicy)
+	watcher, err := WatchAccessPolicy(policyPath, 0)
+	assert.Nil(t, err)
+	os.Remove(policyPath)
+
+	config := DefaultConfig()
+	config.Policy = watcher
+	fs, err := newFuseFs(clonesPath, config)
+	assert.Nil(t, err)
+	fs.callerOf = func(pid uint32) (*caller, error) {
+		switch pid {
+		case 1:
+			return &caller{uid: 1000, gids: []uint32{1000}}, nil
+		case 2:
+			return &caller{uid: 2000, gids: []uint32{2000}}, nil
+		}
+		return nil, fmt.Errorf("no process %v", pid)
+	}
+	return fs
+}
+
+func lookUp(fs *fuseFs, parent fuseops.InodeID, name string, pid uint32) (fuseops.InodeID, error) {
+	op := &fuseops.LookUpInodeOp{Parent: parent, Name: name, OpContext: fuseops.OpContext{Pid: pid}}
+	err := fs.LookUpInode(context.Background(), op)
+	return op.Entry.Child, err
+}
+
+func TestFuseFsEnforcesAccessPolicy(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	fs := newPolicyFuseFs(t, clonesPath)
+
+	_, err := lookUp(fs, fuseops.RootInodeID, testutils.LOCAL_REPO_NAME, 2)
+	assert.Equal(t, syscall.EACCES, err)
+	_, err = lookUp(fs, fuseops.RootInodeID, "wat", 2)
+	assert.Equal(t, syscall.EACCES, err)
+	_, err = lookUp(fs, fuseops.RootInodeID, testutils.LOCAL_REPO_NAME, 3)
+	assert.Equal(t, syscall.EACCES, err)
+
+	repositoryId, err := lookUp(fs, fuseops.RootInodeID, testutils.LOCAL_REPO_NAME, 1)
+	assert.Nil(t, err)
+	commitishId, err := lookUp(fs, repositoryId, "master", 1)
+	assert.Nil(t, err)
+	dirId, err := lookUp(fs, commitishId, "src", 1)
+	assert.Nil(t, err)
+	fileId, err := lookUp(fs, dirId, "main.go", 1)
+	assert.Nil(t, err)
+
+	// inodes looked up by an allowed caller are still denied to others
+	_, err = lookUp(fs, repositoryId, "master", 2)
+	assert.Equal(t, syscall.EACCES, err)
+	_, err = lookUp(fs, dirId, "main.go", 2)
+	assert.Equal(t, syscall.EACCES, err)
+
+	getAttributes := func(pid uint32) error {
+		op := &fuseops.GetInodeAttributesOp{Inode: fileId, OpContext: fuseops.OpContext{Pid: pid}}
+		return fs.GetInodeAttributes(context.Background(), op)
+	}
+	assert.Nil(t, getAttributes(1))
+	assert.Equal(t, syscall.EACCES, getAttributes(2))
+
+	readDir := func(pid uint32) error {
+		op := &fuseops.ReadDirOp{Inode: dirId, Dst: make([]byte, 1024), OpContext: fuseops.OpContext{Pid: pid}}
+		return fs.ReadDir(context.Background(), op)
+	}
+	assert.Nil(t, readDir(1))
+	assert.Equal(t, syscall.EACCES, readDir(2))
+
+	readFile := func(pid uint32) (string, error) {
+		op := &fuseops.ReadFileOp{Inode: fileId, Dst: make([]byte, 1024), OpContext: fuseops.OpContext{Pid: pid}}
+		err := fs.ReadFile(context.Background(), op)
+		return string(op.Dst[:op.BytesRead]), err
+	}
+	contents, err := readFile(1)
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.LocalFiles["src/main.go"], contents)
+	_, err = readFile(2)
+	assert.Equal(t, syscall.EACCES, err)
+}
+
+func TestFuseFsWithoutAccessPolicy(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	fs, err := newFuseFs(clonesPath, DefaultConfig())
+	assert.Nil(t, err)
+
+	repositoryId, err := lookUp(fs, fuseops.RootInodeID, testutils.LOCAL_REPO_NAME, 0)
+	assert.Nil(t, err)
+	_, err = lookUp(fs, repositoryId, "master", 0)
+	assert.Nil(t, err)
+}
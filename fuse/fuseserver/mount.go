@@ -4,8 +4,42 @@ import (
 	"fmt"
 	"github.com/jacobsa/fuse"
 	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/inodefs"
+	"gitreefs/core/virtualfs/nodes"
 )
 
+// Config of the access to a mounted file system
+type Config struct {
+	// Policy limits the repositories each user may access, nil allows access to all repositories
+	Policy *PolicyWatcher
+	// Permissions are the owner, group and modes reported for all files and directories
+	Permissions *inodefs.Permissions
+	// AllowOther lets users other than the mounting one access the mount
+	AllowOther bool
+	// DefaultPermissions has the kernel check access by the reported owner, group and modes
+	DefaultPermissions bool
+	// Root is the root of the nodes served, nil serves a root of its own over the clones path
+	Root *nodes.Root
+}
+
+// DefaultConfig only lets the mounting user access the mount, with access to all repositories
+func DefaultConfig() *Config {
+	return &Config{
+		Permissions: inodefs.DefaultPermissions(),
+	}
+}
+
+func (config *Config) mountOptions() map[string]string {
+	options := make(map[string]string)
+	if config.AllowOther {
+		options["allow_other"] = ""
+	}
+	if config.DefaultPermissions {
+		options["default_permissions"] = ""
+	}
+	return options
+}
+
 func Unmount(mountPoint string) error {
 	err := fuse.Unmount(mountPoint)
 	if err != nil {
@@ -16,9 +50,9 @@ func Unmount(mountPoint string) error {
 	return nil
 }
 
-func Mount(clonesPath string, mountPoint string, isRetry bool) (mountedFs *fuse.MountedFileSystem, err error) {
+func Mount(clonesPath string, mountPoint string, config *Config, isRetry bool) (mountedFs *fuse.MountedFileSystem, err error) {
 
-	fuseServer, err := NewFsServer(clonesPath)
+	fuseServer, err := NewFsServer(clonesPath, config)
 	if err != nil {
 		return nil, fmt.Errorf("fuse.NewFsServer: %w", err)
 	}
@@ -29,6 +63,10 @@ func Mount(clonesPath string, mountPoint string, isRetry bool) (mountedFs *fuse.
 		ReadOnly:    true,
 		DebugLogger: logger.DebugLogger(),
 		ErrorLogger: logger.InfoLogger(),
+		Options:     config.mountOptions(),
+	}
+	if config.AllowOther && !config.DefaultPermissions && config.Policy == nil {
+		logger.Info("Mounting at %v with access to all repositories for all users", mountPoint)
 	}
 
 	mountedFs, err = fuse.Mount(mountPoint, fuseServer, mountCfg)
@@ -39,7 +77,7 @@ func Mount(clonesPath string, mountPoint string, isRetry bool) (mountedFs *fuse.
 	if !isRetry {
 		unmountErr := Unmount(mountPoint)
 		if unmountErr == nil {
-			return Mount(clonesPath, mountPoint, true)
+			return Mount(clonesPath, mountPoint, config, true)
 		}
 		logger.Error("Failed to unmount at %v after failing to mount: %v", mountPoint, err)
 	}
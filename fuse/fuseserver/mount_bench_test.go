@@ -39,7 +39,7 @@ func (mntSuite *mountBenchmarkTestSuite) mount() (mountPoint string) {
 	}
 
 	logger.Info("Mounting at %v", mountPoint)
-	_, err = Mount(mntSuite.clonesPath, mountPoint, false)
+	_, err = Mount(mntSuite.clonesPath, mountPoint, DefaultConfig(), false)
 	if err != nil {
 		panic(err)
 	}
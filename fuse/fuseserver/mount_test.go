@@ -1,11 +1,16 @@
 package fuseserver
 
 import (
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/inodefs"
 	testutils "gitreefs/test_utils"
 	"io/ioutil"
 	"os"
+	"path"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -31,7 +36,7 @@ func (mntSuite *mountTestSuite) SetupTest() {
 	}
 
 	logger.Info("Mounting")
-	_, err = Mount(mntSuite.clonesPath, mntSuite.mountPoint, false)
+	_, err = Mount(mntSuite.clonesPath, mntSuite.mountPoint, DefaultConfig(), false)
 	if err != nil {
 		panic(err)
 	}
@@ -50,3 +55,64 @@ func (mntSuite *mountTestSuite) TearDownTest() {
 func (mntSuite *mountTestSuite) TestWalkFileSystem() {
 	testutils.WalkFileSystem(&mntSuite.Suite, mntSuite.mountPoint, true)
 }
+
+func mountLocalClones(t *testing.T, config *Config) (clonesPath string, mountPoint string) {
+	clonesPath, _ = testutils.SetupLocalClones()
+	mountPoint, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	_, err = Mount(clonesPath, mountPoint, config, false)
+	assert.Nil(t, err)
+	return
+}
+
+func unmountLocalClones(clonesPath string, mountPoint string) {
+	Unmount(mountPoint)
+	os.RemoveAll(mountPoint)
+	os.RemoveAll(clonesPath)
+}
+
+func TestMountPermissions(t *testing.T) {
+	config := DefaultConfig()
+	config.Permissions = &inodefs.Permissions{Uid: 1234, Gid: 5678, FileMode: 0440, DirMode: 0550}
+	config.AllowOther = true
+	config.DefaultPermissions = true
+	clonesPath, mountPoint := mountLocalClones(t, config)
+	defer unmountLocalClones(clonesPath, mountPoint)
+
+	for subPath, mode := range map[string]os.FileMode{
+		path.Join(testutils.LOCAL_REPO_NAME, "master", "README.md"): 0440,
+		path.Join(testutils.LOCAL_REPO_NAME, "master", "src"):       os.ModeDir | 0550,
+		path.Join(testutils.LOCAL_REPO_NAME, "master"):              os.ModeDir | 0550,
+		testutils.LOCAL_REPO_NAME:                                   os.ModeDir | 0550,
+	} {
+		info, err := os.Stat(path.Join(mountPoint, subPath))
+		assert.Nil(t, err)
+		assert.Equal(t, mode, info.Mode(), subPath)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.EqualValues(t, 1234, stat.Uid, subPath)
+		assert.EqualValues(t, 5678, stat.Gid, subPath)
+	}
+
+	mounts, err := ioutil.ReadFile("/proc/mounts")
+	assert.Nil(t, err)
+	for _, line := range strings.Split(string(mounts), "\n") {
+		if strings.Contains(line, mountPoint) {
+			assert.Contains(t, line, "allow_other")
+			assert.Contains(t, line, "default_permissions")
+		}
+	}
+}
+
+func TestMountInvalidPermissions(t *testing.T) {
+	config := DefaultConfig()
+	config.Permissions.FileMode = os.ModeSetuid | 0777
+	_, err := NewFsServer(os.TempDir(), config)
+	assert.NotNil(t, err)
+}
+
+func TestDefaultConfigMountOptions(t *testing.T) {
+	config := DefaultConfig()
+	assert.Empty(t, config.mountOptions())
+	config.AllowOther = true
+	assert.Equal(t, map[string]string{"allow_other": ""}, config.mountOptions())
+}
@@ -1,34 +1,86 @@
 package fuseserver
 
 import (
+	"errors"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
 	"gitreefs/core/logger"
 	"gitreefs/core/virtualfs/inodefs"
+	"gitreefs/core/virtualfs/nodes"
 	"golang.org/x/net/context"
+	"os"
 	"sync"
+	"syscall"
 )
 
 type fuseFs struct {
 	fuseutil.NotImplementedFileSystem
-	clonesPath string
-	inodes     *sync.Map
+	clonesPath   string
+	inodes       *sync.Map
+	repositories *sync.Map // the repository name of each inode under a repository
+	policy       *PolicyWatcher
+	callerOf     func(pid uint32) (*caller, error)
 }
 
-func NewFsServer(clonesPath string) (server fuse.Server, err error) {
-	var rootInode *inodefs.RootInode
-	rootInode, err = inodefs.NewRootInode(clonesPath)
+func newFuseFs(clonesPath string, config *Config) (fs *fuseFs, err error) {
+	rootNode := config.Root
+	if rootNode == nil {
+		rootNode, err = nodes.NewRoot(clonesPath)
+		if err != nil {
+			return
+		}
+	}
+	var rootInode inodefs.Inode
+	rootInode, err = inodefs.NewRootInode(rootNode, config.Permissions)
 	if err != nil {
 		return
 	}
 	inodes := &sync.Map{}
 	inodes.Store(rootInode.Id(), rootInode)
-	server = fuseutil.NewFileSystemServer(&fuseFs{
-		clonesPath: clonesPath,
-		inodes:     inodes,
-	})
-	return
+	return &fuseFs{
+		clonesPath:   clonesPath,
+		inodes:       inodes,
+		repositories: &sync.Map{},
+		policy:       config.Policy,
+		callerOf:     newCallerCache(callerOfPid, callerCacheExpiry, callerCacheSize).callerOf,
+	}, nil
+}
+
+func NewFsServer(clonesPath string, config *Config) (server fuse.Server, err error) {
+	fs, err := newFuseFs(clonesPath, config)
+	if err != nil {
+		return
+	}
+	return fuseutil.NewFileSystemServer(fs), nil
+}
+
+// repositoryOf returns the name of the repository an inode is under, empty for the root
+func (fs *fuseFs) repositoryOf(inodeId fuseops.InodeID) string {
+	repository, found := fs.repositories.Load(inodeId)
+	if !found {
+		return ""
+	}
+	return repository.(string)
+}
+
+// checkAccess checks the caller of an operation may access a repository
+func (fs *fuseFs) checkAccess(opContext fuseops.OpContext, repository string) error {
+	if fs.policy == nil || len(repository) == 0 {
+		return nil
+	}
+	caller, err := fs.callerOf(opContext.Pid)
+	if err != nil {
+		logger.Info("fuseFs.checkAccess: denied %v to pid %v: %v", repository, opContext.Pid, err)
+		return syscall.EACCES
+	}
+	if !fs.policy.Policy().allows(caller, repository) {
+		logger.Debug("fuseFs.checkAccess: denied %v to uid %v", repository, caller.uid)
+		return syscall.EACCES
+	}
+	return nil
 }
 
 func (fs *fuseFs) StatFS(
@@ -37,7 +89,7 @@ func (fs *fuseFs) StatFS(
 	return nil
 }
 
-func (fs *fuseFs) lookUpInode(parentId fuseops.InodeID, name string) (inode inodefs.Inode, err error) {
+func (fs *fuseFs) lookUpInode(parentId fuseops.InodeID, name string, repository string) (inode inodefs.Inode, err error) {
 	parent, found := fs.inodes.Load(parentId)
 	if !found {
 		return nil, nil
@@ -46,14 +98,49 @@ func (fs *fuseFs) lookUpInode(parentId fuseops.InodeID, name string) (inode inod
 	if err != nil || inode == nil {
 		return
 	}
+	fs.repositories.LoadOrStore(inode.Id(), repository)
 	fs.inodes.LoadOrStore(inode.Id(), inode)
 	return
 }
 
+// isNotFound tells whether a lookup failed for a missing or an invalid repository
+func isNotFound(err error) bool {
+	return errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, common.ErrInvalidRepositoryName) ||
+		errors.Is(err, common.ErrOutsideClonesPath)
+}
+
+// unwrapErrno returns the errno an error wraps, or EIO
+func unwrapErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
+
 func (fs *fuseFs) LookUpInode(
 	ctx context.Context,
 	op *fuseops.LookUpInodeOp) error {
-	inode, err := fs.lookUpInode(op.Parent, op.Name)
+	repository := fs.repositoryOf(op.Parent)
+	if op.Parent == fuseops.RootInodeID {
+		repository = op.Name
+	}
+	// access is checked before looking up, so denied callers can't tell which repositories exist
+	err := fs.checkAccess(op.OpContext, repository)
+	if err != nil {
+		return err
+	}
+	inode, err := fs.lookUpInode(op.Parent, op.Name, repository)
+	if err != nil && isNotFound(err) {
+		logger.Info("fuseFs.LookUpInode for %v: %v", op.Name, err)
+		return fuse.ENOENT
+	}
+	if err != nil && (errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ETIMEDOUT)) {
+		// the repository is being cloned, or its clone timed out
+		logger.Info("fuseFs.LookUpInode for %v: %v", op.Name, err)
+		return unwrapErrno(err)
+	}
 	if err != nil {
 		logger.Error("fuseFs.LookUpInode for %v on %v: %v", inode, op.Name, err)
 		return fuse.EIO
@@ -74,6 +161,10 @@ func (fs *fuseFs) GetInodeAttributes(
 	if !found {
 		return fuse.ENOENT
 	}
+	err := fs.checkAccess(op.OpContext, fs.repositoryOf(op.Inode))
+	if err != nil {
+		return err
+	}
 	op.Attributes = inode.(inodefs.Inode).Attributes()
 	return nil
 }
@@ -92,6 +183,10 @@ func (fs *fuseFs) ReadDir(
 	if !found {
 		return fuse.ENOENT
 	}
+	err := fs.checkAccess(op.OpContext, fs.repositoryOf(op.Inode))
+	if err != nil {
+		return err
+	}
 	children, err := inode.(inodefs.Inode).ListChildren()
 	if err != nil {
 		logger.Error("fuseFs.ReadDir for %v: %v", inode, err)
@@ -118,6 +213,10 @@ func (fs *fuseFs) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) error {
 	// Allow opening any file.
+	// files missing from a partial clone are listed with a zero size, so reads of them must reach ReadFile
+	// to either fail with ENODATA or fetch them, rather than be cut off at that size by the kernel
+	var inode, found = fs.inodes.Load(op.Inode)
+	op.UseDirectIO = found && inode.(inodefs.Inode).IsMissing()
 	return nil
 }
 
@@ -128,7 +227,16 @@ func (fs *fuseFs) ReadFile(
 	if !found {
 		return fuse.ENOENT
 	}
+	err := fs.checkAccess(op.OpContext, fs.repositoryOf(op.Inode))
+	if err != nil {
+		return err
+	}
 	contents, err := inode.(inodefs.Inode).Contents()
+	if err != nil && errors.Is(err, git.ErrBlobMissing) {
+		// the blob wasn't fetched to the partial clone
+		logger.Info("fuseFs.ReadFile for %v: %v", inode, err)
+		return unwrapErrno(err)
+	}
 	if err != nil {
 		logger.Error("fuseFs.ReadFile for %v: %v", inode, err)
 		return fuse.EIO
@@ -0,0 +1,77 @@
+package fuseserver
+
+import (
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/stretchr/testify/assert"
+	"gitreefs/core/git"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLookUpRepositoryTraversal(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	// serving a directory within the local clone, so that a traversal to its parent would open the clone
+	nestedPath := path.Join(clonesPath, testutils.LOCAL_REPO_NAME, "nested")
+	assert.Nil(t, os.MkdirAll(nestedPath, 0777))
+	otherClonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(otherClonesPath)
+	assert.Nil(t, os.Symlink(path.Join(otherClonesPath, testutils.LOCAL_REPO_NAME), path.Join(nestedPath, "outside")))
+
+	fs, err := newFuseFs(nestedPath, DefaultConfig())
+	assert.Nil(t, err)
+	for _, name := range []string{"..", ".", "../nested", ".git", "outside"} {
+		_, err = lookUp(fs, fuseops.RootInodeID, name, 0)
+		assert.Equal(t, fuse.ENOENT, err, "looked up %v", name)
+	}
+}
+
+func TestLookUpSymlinkedClone(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	assert.Nil(t, os.Symlink(path.Join(clonesPath, testutils.LOCAL_REPO_NAME), path.Join(clonesPath, "alias")))
+
+	fs, err := newFuseFs(clonesPath, DefaultConfig())
+	assert.Nil(t, err)
+	repositoryId, err := lookUp(fs, fuseops.RootInodeID, "alias", 0)
+	assert.Nil(t, err)
+	_, err = lookUp(fs, repositoryId, "master", 0)
+	assert.Nil(t, err)
+}
+
+func TestLookUpAutoClonedRepository(t *testing.T) {
+	remotesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(remotesPath)
+	clonesPath, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(clonesPath)
+	defer git.SetAutoClone(nil)
+	err = git.SetAutoClone(&git.AutoClone{
+		URLTemplate:   "file://" + remotesPath + "/{name}",
+		MaxConcurrent: 1,
+		Timeout:       time.Minute,
+		Wait:          false,
+	})
+	assert.Nil(t, err)
+
+	fs, err := newFuseFs(clonesPath, DefaultConfig())
+	assert.Nil(t, err)
+	for name, expected := range map[string]error{testutils.LOCAL_REPO_NAME: nil, "wat": fuse.EIO} {
+		_, err = lookUp(fs, fuseops.RootInodeID, name, 0)
+		assert.Equal(t, syscall.EAGAIN, err, "first lookup of %v", name)
+
+		deadline := time.Now().Add(time.Minute)
+		for err == syscall.EAGAIN && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			_, err = lookUp(fs, fuseops.RootInodeID, name, 0)
+		}
+		assert.Equal(t, expected, err, "lookup of %v once cloned", name)
+	}
+}
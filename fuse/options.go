@@ -1,42 +1,47 @@
-package main
+package fuse
 
 import (
 	"fmt"
 	"github.com/urfave/cli"
 	"gitreefs/core/common"
+	"gitreefs/core/virtualfs/inodefs"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 type options struct {
-	logFile    string
-	logLevel   string
-	clonesPath string
-	mountPoint string
+	*common.SharedOptions
+	mountPoint           string
+	accessPolicy         string
+	policyReloadInterval time.Duration
+	permissions          *inodefs.Permissions
+	allowOther           bool
+	defaultPermissions   bool
 }
 
 var _ common.Options = &options{}
 
-func (opts *options) LogFile() string {
-	return opts.logFile
-}
-
-func (opts *options) LogLevel() string {
-	return opts.logLevel
-}
-
-func (app *FuseApp) ParseOptions(ctx *cli.Context) (opts common.Options, err error) {
-	var clonesPath, mountPoint string
+func (app *App) ParseOptions(ctx *cli.Context) (opts common.Options, err error) {
+	sharedOptions := common.ParseSharedOptions(ctx)
+	clonesPath := sharedOptions.ClonesPath
+	mountPoint := ctx.String("mount-point")
 	switch len(ctx.Args()) {
 
 	case 2:
 		clonesPath = ctx.Args()[0]
 		mountPoint = ctx.Args()[1]
 
+	case 0:
+		if len(clonesPath) > 0 && len(mountPoint) > 0 {
+			break
+		}
+		fallthrough
+
 	default:
-		exeName := path.Base(os.Args[0])
-		err = fmt.Errorf("%s takes exactly two arguments. Run `%s --help` for more info", exeName, exeName)
+		err = fmt.Errorf("%s takes exactly two arguments, unless set by options. Run `%s %s --help` for more info",
+			ctx.Command.Name, ctx.App.Name, ctx.Command.Name)
 
 		return
 	}
@@ -57,11 +62,41 @@ func (app *FuseApp) ParseOptions(ctx *cli.Context) (opts common.Options, err err
 		return
 	}
 
+	permissions := &inodefs.Permissions{
+		Uid: uint32(ctx.Int("uid")),
+		Gid: uint32(ctx.Int("gid")),
+	}
+	permissions.FileMode, err = parseMode(ctx.String("file-mode"))
+	if err != nil {
+		return
+	}
+	permissions.DirMode, err = parseMode(ctx.String("dir-mode"))
+	if err != nil {
+		return
+	}
+	err = permissions.Validate()
+	if err != nil {
+		return
+	}
+
+	sharedOptions.ClonesPath = clonesPath
 	opts = &options{
-		logFile:    ctx.String("log-file"),
-		logLevel:   ctx.String("log-level"),
-		clonesPath: clonesPath,
-		mountPoint: mountPoint,
+		SharedOptions:        sharedOptions,
+		mountPoint:           mountPoint,
+		accessPolicy:         ctx.String("access-policy"),
+		policyReloadInterval: ctx.Duration("access-policy-reload-interval"),
+		permissions:          permissions,
+		allowOther:           ctx.Bool("allow-other"),
+		defaultPermissions:   ctx.Bool("default-permissions"),
 	}
 	return
 }
+
+// parseMode parses octal permission bits, e.g. 0444
+func parseMode(mode string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode '%v', expected octal permission bits such as 0444", mode)
+	}
+	return os.FileMode(parsed), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+	"gitreefs/control"
+	"gitreefs/core/common"
+	"gitreefs/fuse"
+	"gitreefs/nfs"
+	"gitreefs/offline"
+	"os"
+)
+
+func main() {
+	commands := []cli.Command{
+		common.Command(&fuse.App{}),
+		common.Command(&nfs.App{}),
+		nfs.HandlesCommand(),
+		common.Command(&control.PrefetchApp{}),
+	}
+	common.RunApp(&cli.App{
+		Name:     "gitreefs",
+		HelpName: "gitreefs",
+		Version:  Version,
+		Usage:    "Virtual file system, mapping from a directory of clones to all of their possible contents",
+		Writer:   os.Stdout,
+		Commands: append(commands, offline.Commands()...),
+	})
+}
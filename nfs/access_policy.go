@@ -0,0 +1,245 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// AccessPolicy decides which clients may access which exports, loaded from a JSON file such as:
+//
+//	{
+//	  "exports": [
+//	    {"path": "/", "clients": ["127.0.0.1"]},
+//	    {"path": "/repo", "clients": ["10.0.0.0/8"], "uids": [1000], "gids": [100]}
+//	  ]
+//	}
+//
+// A path is checked against the most specific export containing it, and is denied if there is none.
+// Clients must match one of the listed addresses or CIDRs (any address if none are listed),
+// and if uids or gids are listed, must present AUTH_UNIX credentials with one of the listed uids or groups.
+type AccessPolicy struct {
+	exports []*exportPolicy
+}
+
+type exportPolicy struct {
+	path     string
+	networks []*net.IPNet
+	uids     map[uint32]bool
+	gids     map[uint32]bool
+}
+
+type exportPolicyFile struct {
+	Path    string   `json:"path"`
+	Clients []string `json:"clients"`
+	Uids    []uint32 `json:"uids"`
+	Gids    []uint32 `json:"gids"`
+}
+
+type accessPolicyFile struct {
+	Exports []exportPolicyFile `json:"exports"`
+}
+
+// unixCredentials are the uid and groups of an AUTH_UNIX client
+type unixCredentials struct {
+	uid  uint32
+	gid  uint32
+	gids []uint32
+}
+
+func cleanExportPath(exportPath string) string {
+	return filepath.Clean("/" + exportPath)
+}
+
+func parseNetwork(client string) (*net.IPNet, error) {
+	if strings.Contains(client, "/") {
+		_, network, err := net.ParseCIDR(client)
+		return network, err
+	}
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client address '%v'", client)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func toSet(ids []uint32) map[uint32]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func ParseAccessPolicy(contents []byte) (policy *AccessPolicy, err error) {
+	var file accessPolicyFile
+	err = json.Unmarshal(contents, &file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access policy: %w", err)
+	}
+	policy = &AccessPolicy{}
+	for _, export := range file.Exports {
+		if len(export.Path) == 0 {
+			return nil, fmt.Errorf("invalid access policy: export without a path")
+		}
+		exportPolicy := &exportPolicy{
+			path: cleanExportPath(export.Path),
+			uids: toSet(export.Uids),
+			gids: toSet(export.Gids),
+		}
+		for _, client := range export.Clients {
+			network, err := parseNetwork(client)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access policy for export %v: %w", export.Path, err)
+			}
+			exportPolicy.networks = append(exportPolicy.networks, network)
+		}
+		policy.exports = append(policy.exports, exportPolicy)
+	}
+	return
+}
+
+func LoadAccessPolicy(policyPath string) (*AccessPolicy, error) {
+	contents, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy at %v: %w", policyPath, err)
+	}
+	return ParseAccessPolicy(contents)
+}
+
+func (export *exportPolicy) contains(exportPath string) bool {
+	return export.path == "/" || exportPath == export.path || strings.HasPrefix(exportPath, export.path+"/")
+}
+
+// exportOf returns the most specific export policy applying to a path
+func (policy *AccessPolicy) exportOf(exportPath string) (matched *exportPolicy) {
+	for _, export := range policy.exports {
+		if export.contains(exportPath) && (matched == nil || len(export.path) > len(matched.path)) {
+			matched = export
+		}
+	}
+	return
+}
+
+func (export *exportPolicy) allowsAddress(ip net.IP) bool {
+	if len(export.networks) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range export.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (export *exportPolicy) requiresCredentials() bool {
+	return export.uids != nil || export.gids != nil
+}
+
+func (export *exportPolicy) allowsCredentials(credentials *unixCredentials) bool {
+	if !export.requiresCredentials() {
+		return true
+	}
+	if credentials == nil {
+		return false
+	}
+	if export.uids[credentials.uid] || export.gids[credentials.gid] {
+		return true
+	}
+	for _, gid := range credentials.gids {
+		if export.gids[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+// allows checks whether a client at an address with the given credentials (nil if not AUTH_UNIX) may access a path
+func (policy *AccessPolicy) allows(exportPath string, ip net.IP, credentials *unixCredentials) (allowed bool, reason string) {
+	exportPath = cleanExportPath(exportPath)
+	export := policy.exportOf(exportPath)
+	switch {
+	case export == nil:
+		return false, "no export policy applies"
+	case !export.allowsAddress(ip):
+		return false, fmt.Sprintf("address %v is not allowed by export %v", ip, export.path)
+	case !export.allowsCredentials(credentials):
+		return false, fmt.Sprintf("credentials %+v are not allowed by export %v", credentials, export.path)
+	}
+	return true, ""
+}
+
+// allowsCall checks whether a client may access a path, by its connection and the credentials of its call
+func (policy *AccessPolicy) allowsCall(exportPath string, conn net.Conn, auth rpc.Auth) (allowed bool, reason string) {
+	credentials, err := parseUnixCredentials(auth)
+	if err != nil {
+		return false, err.Error()
+	}
+	return policy.allows(exportPath, remoteIP(conn), credentials)
+}
+
+// authFlavors returns the authentication flavors clients of a path should use
+func (policy *AccessPolicy) authFlavors(exportPath string) []nfs.AuthFlavor {
+	export := policy.exportOf(cleanExportPath(exportPath))
+	if export != nil && export.requiresCredentials() {
+		return []nfs.AuthFlavor{nfs.AuthFlavorUnix}
+	}
+	return []nfs.AuthFlavor{nfs.AuthFlavorNull}
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return nil
+	}
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// parseUnixCredentials decodes AUTH_UNIX credentials (rfc1057 section 9.2), returning nil for other flavors
+func parseUnixCredentials(auth rpc.Auth) (credentials *unixCredentials, err error) {
+	if nfs.AuthFlavor(auth.Flavor) != nfs.AuthFlavorUnix {
+		return nil, nil
+	}
+	reader := bytes.NewReader(auth.Body)
+	var stamp uint32
+	var machineName string
+	credentials = &unixCredentials{}
+	for _, value := range []interface{}{&stamp, &machineName, &credentials.uid, &credentials.gid} {
+		err = xdr.Read(reader, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_UNIX credentials: %w", err)
+		}
+	}
+	credentials.gids, err = xdr.ReadUint32List(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_UNIX credentials: %w", err)
+	}
+	return
+}
@@ -0,0 +1,190 @@
+package nfs
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// fakeConn is a connection from a remote address, without any traffic
+type fakeConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (conn *fakeConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+func newFakeConn(ip string) net.Conn {
+	return &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1023}}
+}
+
+func unixAuth(uid uint32, gid uint32, gids ...uint32) rpc.Auth {
+	body := new(bytes.Buffer)
+	for _, value := range []interface{}{uint32(0), "client", uid, gid, uint32(len(gids))} {
+		xdr.Write(body, value)
+	}
+	for _, gid := range gids {
+		xdr.Write(body, gid)
+	}
+	return rpc.Auth{Flavor: uint32(nfs.AuthFlavorUnix), Body: body.Bytes()}
+}
+
+func credentialsOf(t *testing.T, auth rpc.Auth) *unixCredentials {
+	credentials, err := parseUnixCredentials(auth)
+	assert.Nil(t, err)
+	return credentials
+}
+
+const testPolicy = `{
+  "exports": [
+    {"path": "/", "clients": ["127.0.0.1"]},
+    {"path": "/local", "clients": ["10.0.0.0/8", "::1"], "uids": [1000], "gids": [100]},
+    {"path": "local/master/src/", "uids": [0]}
+  ]
+}`
+
+func parseTestPolicy(t *testing.T) *AccessPolicy {
+	policy, err := ParseAccessPolicy([]byte(testPolicy))
+	assert.Nil(t, err)
+	return policy
+}
+
+func TestParseUnixCredentials(t *testing.T) {
+	credentials := credentialsOf(t, unixAuth(1000, 100, 100, 20, 30))
+	assert.EqualValues(t, 1000, credentials.uid)
+	assert.EqualValues(t, 100, credentials.gid)
+	assert.Equal(t, []uint32{100, 20, 30}, credentials.gids)
+
+	// credentials as sent by the go-nfs-client
+	credentials = credentialsOf(t, rpc.NewAuthUnix("client", 1001, 1002).Auth())
+	assert.EqualValues(t, 1001, credentials.uid)
+	assert.EqualValues(t, 1002, credentials.gid)
+
+	assert.Nil(t, credentialsOf(t, rpc.AuthNull))
+
+	_, err := parseUnixCredentials(rpc.Auth{Flavor: uint32(nfs.AuthFlavorUnix), Body: []byte{0, 0}})
+	assert.NotNil(t, err)
+}
+
+func TestParseInvalidAccessPolicies(t *testing.T) {
+	for _, contents := range []string{
+		`{"exports": [`,
+		`{"exports": [{"clients": ["127.0.0.1"]}]}`,
+		`{"exports": [{"path": "/", "clients": ["localhost"]}]}`,
+		`{"exports": [{"path": "/", "clients": ["10.0.0.0/33"]}]}`,
+		`{"exports": [{"path": "/", "uids": [-1]}]}`,
+	} {
+		_, err := ParseAccessPolicy([]byte(contents))
+		assert.NotNil(t, err, "parsed %v", contents)
+	}
+}
+
+func TestLoadAccessPolicy(t *testing.T) {
+	file, err := ioutil.TempFile("", "policy")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(testPolicy)
+	assert.Nil(t, err)
+	file.Close()
+
+	policy, err := LoadAccessPolicy(file.Name())
+	assert.Nil(t, err)
+	assert.Len(t, policy.exports, 3)
+
+	_, err = LoadAccessPolicy(file.Name() + ".wat")
+	assert.NotNil(t, err)
+}
+
+func TestAccessPolicyByAddress(t *testing.T) {
+	policy := parseTestPolicy(t)
+
+	allowed, _ := policy.allows("/", net.ParseIP("127.0.0.1"), nil)
+	assert.True(t, allowed)
+	allowed, _ = policy.allows("/other/master", net.ParseIP("127.0.0.1"), nil)
+	assert.True(t, allowed)
+	allowed, _ = policy.allows("/", net.ParseIP("127.0.0.2"), nil)
+	assert.False(t, allowed)
+	allowed, _ = policy.allows("/", nil, nil)
+	assert.False(t, allowed)
+
+	// the most specific export applies, even if a more general one would allow the client
+	credentials := credentialsOf(t, unixAuth(1000, 1000))
+	allowed, _ = policy.allows("/local/master", net.ParseIP("10.1.2.3"), credentials)
+	assert.True(t, allowed)
+	allowed, _ = policy.allows("/local", net.ParseIP("::1"), credentials)
+	assert.True(t, allowed)
+	allowed, _ = policy.allows("/local", net.ParseIP("127.0.0.1"), credentials)
+	assert.False(t, allowed)
+	allowed, _ = policy.allows("/localother", net.ParseIP("127.0.0.1"), nil)
+	assert.True(t, allowed)
+}
+
+func TestAccessPolicyByCredentials(t *testing.T) {
+	policy := parseTestPolicy(t)
+	ip := net.ParseIP("10.0.0.1")
+
+	for _, credentials := range []*unixCredentials{
+		credentialsOf(t, unixAuth(1000, 1000)),
+		credentialsOf(t, unixAuth(1001, 100)),
+		credentialsOf(t, unixAuth(1001, 1001, 20, 100)),
+	} {
+		allowed, reason := policy.allows("/local/master", ip, credentials)
+		assert.True(t, allowed, reason)
+	}
+
+	for _, credentials := range []*unixCredentials{
+		nil,
+		credentialsOf(t, unixAuth(1001, 1001)),
+		credentialsOf(t, unixAuth(1001, 1001, 20)),
+	} {
+		allowed, _ := policy.allows("/local/master", ip, credentials)
+		assert.False(t, allowed)
+	}
+
+	allowed, _ := policy.allows("/local/master/src/pkg", net.ParseIP("192.168.0.1"), credentialsOf(t, unixAuth(0, 0)))
+	assert.True(t, allowed)
+	allowed, _ = policy.allows("/local/master/src/pkg", net.ParseIP("192.168.0.1"), credentialsOf(t, unixAuth(1000, 100)))
+	assert.False(t, allowed)
+}
+
+func TestAccessPolicyAllowsCall(t *testing.T) {
+	policy := parseTestPolicy(t)
+
+	allowed, reason := policy.allowsCall("/local/master", newFakeConn("10.0.0.1"), unixAuth(1000, 1000))
+	assert.True(t, allowed, reason)
+	allowed, _ = policy.allowsCall("/local/master", newFakeConn("127.0.0.1"), unixAuth(1000, 1000))
+	assert.False(t, allowed)
+	allowed, _ = policy.allowsCall("/local/master", newFakeConn("10.0.0.1"), rpc.AuthNull)
+	assert.False(t, allowed)
+	allowed, _ = policy.allowsCall("/local/master", newFakeConn("10.0.0.1"),
+		rpc.Auth{Flavor: uint32(nfs.AuthFlavorUnix), Body: []byte{0, 0}})
+	assert.False(t, allowed)
+}
+
+func TestEmptyAccessPolicyDeniesAll(t *testing.T) {
+	policy, err := ParseAccessPolicy([]byte(`{}`))
+	assert.Nil(t, err)
+	allowed, _ := policy.allows("/", net.ParseIP("127.0.0.1"), credentialsOf(t, unixAuth(0, 0)))
+	assert.False(t, allowed)
+}
+
+func TestAccessPolicyAuthFlavors(t *testing.T) {
+	policy := parseTestPolicy(t)
+	assert.Equal(t, []nfs.AuthFlavor{nfs.AuthFlavorNull}, policy.authFlavors("/"))
+	assert.Equal(t, []nfs.AuthFlavor{nfs.AuthFlavorUnix}, policy.authFlavors("/local/master"))
+}
+
+func TestRemoteIP(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", remoteIP(newFakeConn("10.0.0.1")).String())
+	assert.Equal(t, "::1", remoteIP(&fakeConn{remoteAddr: &net.UDPAddr{IP: net.ParseIP("::1")}}).String())
+	assert.Nil(t, remoteIP(nil))
+	assert.Nil(t, remoteIP(&fakeConn{}))
+}
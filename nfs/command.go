@@ -0,0 +1,190 @@
+package nfs
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"gitreefs/control"
+	"gitreefs/core/common"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/bfs"
+	"net/http"
+	"time"
+)
+
+// App serves a forest of git trees by NFS
+type App struct {
+}
+
+var _ common.App = &App{}
+
+func (app *App) DeclareCommand() cli.Command {
+	command := cli.Command{
+		Name:      "serve-nfs",
+		Usage:     "NFS server providing access to a forest of git trees as a virtual file system",
+		ArgsUsage: "[clones-path storage-path [port]]",
+		Description: `ARGS:
+    clones-path    path to a directory containing git clones (with .git in them), or set by --clones-path
+    storage-path   path to a directory in which to keep persistent storage (file handler mapping), or set by --storage-path
+    port           (optional) to serve the server at, or set by --port`,
+		Flags: append(common.SharedFlags("DEBUG"),
+
+			cli.StringFlag{
+				Name:  "storage-path",
+				Value: "",
+				Usage: "Path to a directory in which to keep persistent storage, if not given as an argument.",
+			},
+
+			cli.StringFlag{
+				Name:  "host",
+				Value: "",
+				Usage: "Host to listen on, all interfaces by default.",
+			},
+
+			cli.IntFlag{
+				Name:  "port",
+				Value: 2049,
+				Usage: "Port to serve the server at, if not given as an argument.",
+			},
+
+			cli.StringFlag{
+				Name:  "export-root",
+				Value: "",
+				Usage: "Export only a repository, commitish or a directory within it (e.g. repo/master/src) as the root.",
+			},
+
+			cli.StringFlag{
+				Name:  "access-policy",
+				Value: "",
+				Usage: "Path to a JSON file of the client addresses and AUTH_UNIX uids/gids allowed to access each export, by default any client is allowed.",
+			},
+
+			cli.IntFlag{
+				Name:  "handles-cache-size",
+				Value: DefaultHandleCacheSize,
+				Usage: "Number of file handles to keep in memory in front of the persistent storage, 0 disables it.",
+			},
+
+			handleExpiryFlag,
+
+			cli.DurationFlag{
+				Name:  "handles-maintenance-interval",
+				Value: DefaultMaintenanceInterval,
+				Usage: "Interval for expiring file handles and reclaiming storage space, 0 disables it.",
+			},
+		),
+	}
+	common.DeclareConfig(&command, "nfs")
+	return command
+}
+
+var handleExpiryFlag = cli.IntFlag{
+	Name:  "handle-expiry-days",
+	Value: int(DefaultHandleExpiry / (24 * time.Hour)),
+	Usage: "Remove file handles not used for this many days, 0 keeps them forever.",
+}
+
+func (app *App) RunUntilStopped(opts common.Options) error {
+	return Serve(opts.(*options))
+}
+
+// HandlesCommand reports on the file handles storage, and optionally compacts it.
+// A running server is asked to through its control endpoint, otherwise the storage is opened directly.
+func HandlesCommand() cli.Command {
+	command := cli.Command{
+		Name:      "nfs-handles",
+		Usage:     "Report on the file handles storage, and optionally compact it",
+		ArgsUsage: "[storage-path]",
+		Description: `Given the --control-address of a running server, the server reports on its storage and compacts it
+   while serving, with its own --handle-expiry-days. Otherwise the storage is opened directly, which fails while a server
+   has it open, read-only for reporting.`,
+		Flags: append(common.SharedFlags("DEBUG"),
+
+			cli.StringFlag{
+				Name:  "storage-path",
+				Value: "",
+				Usage: "Path to the persistent storage of the server, if not given as an argument.",
+			},
+
+			handleExpiryFlag,
+
+			cli.BoolFlag{
+				Name:  "compact",
+				Usage: "Expire unused handles and compact the storage.",
+			},
+		),
+		Action: runHandlesCommand,
+	}
+	common.DeclareConfig(&command, "nfs")
+	return command
+}
+
+func runHandlesCommand(ctx *cli.Context) (err error) {
+	if len(ctx.String("control-address")) > 0 {
+		return runHandlesThroughServer(ctx)
+	}
+	storagePath := ctx.String("storage-path")
+	if ctx.NArg() == 1 {
+		storagePath = ctx.Args()[0]
+	}
+	if ctx.NArg() > 1 || len(storagePath) == 0 {
+		return fmt.Errorf("%s takes exactly one argument, unless set by --storage-path. Run `%s %s --help` for more info",
+			ctx.Command.Name, ctx.App.Name, ctx.Command.Name)
+	}
+
+	err = logger.InitLoggers(ctx.String("log-file"), ctx.String("log-level"), ctx.App.Version)
+	if err != nil {
+		return fmt.Errorf("init loggers: %w", err)
+	}
+	defer logger.CloseLoggers()
+
+	err = common.ValidateDirectory(storagePath, false)
+	if err != nil {
+		return
+	}
+
+	handleExpiry := time.Duration(ctx.Int("handle-expiry-days")) * 24 * time.Hour
+	var handler *Handler
+	if ctx.Bool("compact") {
+		handler, err = NewHandler(&bfs.GitFileSystem{}, nil, storagePath, 0, handleExpiry, 0)
+	} else {
+		handler, err = NewReadOnlyHandler(storagePath, handleExpiry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open handles storage at %v (is the server running? then give its --control-address): %w",
+			storagePath, err)
+	}
+	defer handler.Close()
+
+	var report *HandlesReport
+	if ctx.Bool("compact") {
+		report, err = handler.CompactHandles()
+	} else {
+		report, err = handler.ReportHandles()
+	}
+	if err != nil {
+		return
+	}
+	report.Print(ctx.App.Writer)
+	return
+}
+
+// runHandlesThroughServer has a running server report on its handles storage, or compact it, through its control endpoint.
+// The storage path is the server's, so any given is ignored.
+func runHandlesThroughServer(ctx *cli.Context) (err error) {
+	var client *control.Client
+	client, err = control.NewSharedClient(common.ParseSharedOptions(ctx))
+	if err != nil {
+		return
+	}
+	method := http.MethodGet
+	if ctx.Bool("compact") {
+		method = http.MethodPost
+	}
+	report := &HandlesReport{}
+	err = client.Call(method, HandlesPath, nil, http.StatusOK, report)
+	if err != nil {
+		return
+	}
+	report.Print(ctx.App.Writer)
+	return
+}
@@ -0,0 +1,128 @@
+package nfs
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+	"gitreefs/core/logger"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// separates the export root from the path within it in stored handle paths, can't be a part of any path
+	exportSeparator = "\x00"
+)
+
+// export returns the file system exported at a path relative to the main export, e.g. /repo or /repo/<sha>
+func (handler *Handler) export(exportPath string) (fs billy.Filesystem, err error) {
+	exportPath = strings.Trim(filepath.Clean("/"+exportPath), "/")
+	if len(exportPath) == 0 {
+		return handler.fs, nil
+	}
+	root := filepath.Join(handler.fs.Root(), exportPath)
+	wrapped, found := handler.exports.Get(root)
+	if found {
+		return wrapped.(billy.Filesystem), nil
+	}
+	fs, err = handler.fs.Chroot(exportPath)
+	if err != nil {
+		return nil, err
+	}
+	handler.exports.Set(fs.Root(), fs)
+	return
+}
+
+// exportOfRoot returns the file system of an export by its root, as kept in stored handle paths
+func (handler *Handler) exportOfRoot(root string) (fs billy.Filesystem, err error) {
+	mainRoot := handler.fs.Root()
+	if root != mainRoot && !strings.HasPrefix(root, strings.TrimSuffix(mainRoot, "/")+"/") {
+		return nil, billy.ErrCrossedBoundary
+	}
+	return handler.export(strings.TrimPrefix(root, mainRoot))
+}
+
+// storedPath is the path a handle is mapped to, paths within a non main export are prefixed by the export root
+func (handler *Handler) storedPath(fs billy.Filesystem, path []string) string {
+	fullPath := filepath.Join(path...)
+	if len(fullPath) == 0 {
+		fullPath = RootPathPlaceholder
+	}
+	if fs == nil || fs.Root() == handler.fs.Root() {
+		return fullPath
+	}
+	return fs.Root() + exportSeparator + fullPath
+}
+
+// exportPathOf is the path relative to the main export of a path within an export, as mounted by clients
+func (handler *Handler) exportPathOf(fs billy.Filesystem, path []string) string {
+	return filepath.Join("/", strings.TrimPrefix(fs.Root(), handler.fs.Root()), filepath.Join(path...))
+}
+
+func isExportRoot(storedPath string) bool {
+	return storedPath == RootPathPlaceholder || strings.HasSuffix(storedPath, exportSeparator+RootPathPlaceholder)
+}
+
+func (handler *Handler) parseStoredPath(storedPath string) (fs billy.Filesystem, path []string, err error) {
+	fs = handler.fs
+	fullPath := storedPath
+	separatorIndex := strings.Index(storedPath, exportSeparator)
+	if separatorIndex >= 0 {
+		fs, err = handler.exportOfRoot(storedPath[:separatorIndex])
+		if err != nil {
+			return nil, nil, err
+		}
+		fullPath = storedPath[separatorIndex+len(exportSeparator):]
+	}
+
+	if fullPath == RootPathPlaceholder {
+		path = []string{""}
+	} else {
+		path = strings.Split(fullPath, PathSeparator)
+	}
+	return
+}
+
+// Mount exports the root at the requested path, so clients can mount localhost:/repo or localhost:/repo/<sha>
+func (handler *Handler) Mount(
+	ctx context.Context,
+	conn net.Conn,
+	req nfs.MountRequest,
+) (status nfs.MountStatus, fs billy.Filesystem, auths []nfs.AuthFlavor) {
+	exportPath := string(req.Dirpath)
+	auths = []nfs.AuthFlavor{nfs.AuthFlavorNull}
+	if handler.policy != nil {
+		allowed, reason := handler.policy.allowsCall(exportPath, conn, req.Header.Cred)
+		if !allowed {
+			logger.Info("handler.Mount: denied '%v' to %v: %v", exportPath, remoteIP(conn), reason)
+			return nfs.MountStatusErrAcces, nil, nil
+		}
+		auths = handler.policy.authFlavors(exportPath)
+	}
+
+	fs, err := handler.export(exportPath)
+	if err != nil {
+		logger.Info("handler.Mount: could not export '%v': %v", exportPath, err)
+		return mountStatus(err), nil, nil
+	}
+	logger.Debug("handler.Mount: exporting '%v' at %v", exportPath, fs.Root())
+	return nfs.MountStatusOk, fs, auths
+}
+
+func mountStatus(err error) nfs.MountStatus {
+	switch {
+	case err == billy.ErrCrossedBoundary:
+		return nfs.MountStatusErrAcces
+	case os.IsNotExist(err):
+		return nfs.MountStatusErrNoEnt
+	case os.IsPermission(err):
+		return nfs.MountStatusErrAcces
+	}
+	pathErr, isPathErr := err.(*os.PathError)
+	if isPathErr && pathErr.Err == os.ErrInvalid {
+		return nfs.MountStatusErrNotDir
+	}
+	return nfs.MountStatusErrServerFault
+}
@@ -0,0 +1,95 @@
+package nfs
+
+import (
+	"github.com/hashicorp/golang-lru/simplelru"
+	"sync"
+)
+
+const (
+	DefaultHandleCacheSize = 100000
+)
+
+// handleCache is a bounded bidirectional LRU of path <---> fileHandleId, both as strings.
+// A mapping is evicted from both directions at once, and accessing it from either direction refreshes it.
+type handleCache struct {
+	mutex        *sync.Mutex
+	handleByPath *simplelru.LRU
+	pathByHandle map[string]string
+}
+
+// newHandleCache returns nil for a non positive size, all methods of a nil cache are no-ops
+func newHandleCache(size int) (cache *handleCache, err error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	cache = &handleCache{
+		mutex:        &sync.Mutex{},
+		pathByHandle: make(map[string]string),
+	}
+	cache.handleByPath, err = simplelru.NewLRU(size, func(path interface{}, handle interface{}) {
+		delete(cache.pathByHandle, handle.(string))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (cache *handleCache) add(path string, handle []byte) {
+	if cache == nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	previous, found := cache.handleByPath.Peek(path)
+	if found {
+		delete(cache.pathByHandle, previous.(string))
+	}
+	cache.handleByPath.Add(path, string(handle))
+	cache.pathByHandle[string(handle)] = path
+}
+
+func (cache *handleCache) handle(path string) (handle []byte, found bool) {
+	if cache == nil {
+		return nil, false
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	wrapped, found := cache.handleByPath.Get(path)
+	if !found {
+		return nil, false
+	}
+	return []byte(wrapped.(string)), true
+}
+
+func (cache *handleCache) path(handle []byte) (path string, found bool) {
+	if cache == nil {
+		return "", false
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	path, found = cache.pathByHandle[string(handle)]
+	if found {
+		// refresh recency of the mapping
+		cache.handleByPath.Get(path)
+	}
+	return
+}
+
+func (cache *handleCache) remove(path string) {
+	if cache == nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.handleByPath.Remove(path)
+}
+
+func (cache *handleCache) len() int {
+	if cache == nil {
+		return 0
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.handleByPath.Len()
+}
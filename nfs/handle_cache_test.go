@@ -0,0 +1,49 @@
+package nfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHandleCacheEvictsBothDirections(t *testing.T) {
+	cache, err := newHandleCache(2)
+	assert.Nil(t, err)
+	cache.add("a", []byte("1"))
+	cache.add("b", []byte("2"))
+
+	// touching "a" by its handle keeps it over "b"
+	path, found := cache.path([]byte("1"))
+	assert.True(t, found)
+	assert.Equal(t, "a", path)
+
+	cache.add("c", []byte("3"))
+	assert.Equal(t, 2, cache.len())
+	_, found = cache.handle("b")
+	assert.False(t, found)
+	_, found = cache.path([]byte("2"))
+	assert.False(t, found)
+	handle, found := cache.handle("a")
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), handle)
+}
+
+func TestHandleCacheReplacesHandle(t *testing.T) {
+	cache, err := newHandleCache(2)
+	assert.Nil(t, err)
+	cache.add("a", []byte("1"))
+	cache.add("a", []byte("2"))
+	_, found := cache.path([]byte("1"))
+	assert.False(t, found)
+	cache.remove("a")
+	_, found = cache.path([]byte("2"))
+	assert.False(t, found)
+}
+
+func TestDisabledHandleCache(t *testing.T) {
+	cache, err := newHandleCache(0)
+	assert.Nil(t, err)
+	assert.Nil(t, cache)
+	cache.add("a", []byte("1"))
+	_, found := cache.handle("a")
+	assert.False(t, found)
+}
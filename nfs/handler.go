@@ -1,15 +1,19 @@
-package main
+package nfs
 
 import (
+	"context"
 	"github.com/dgraph-io/badger"
 	"github.com/go-git/go-billy/v5"
 	"github.com/google/uuid"
+	"github.com/orcaman/concurrent-map"
 	"github.com/willscott/go-nfs"
-	"github.com/willscott/go-nfs/helpers"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
 	"gitreefs/core/logger"
 	"math"
+	"net"
+	"os"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
 const (
@@ -18,14 +22,34 @@ const (
 )
 
 type Handler struct {
-	nfs.Handler
-	fs billy.Filesystem
-	db *badger.DB // stores both ways: path <---> fileHandleId , both as []byte
+	fs      billy.Filesystem
+	policy  *AccessPolicy
+	exports cmap.ConcurrentMap
+	db      *badger.DB // stores both ways: path <---> fileHandleId , both as []byte
+	cache   *handleCache
+	pending *pendingHandles
+	tracker *accessTracker
+	expiry  time.Duration
+	stop    chan struct{}
+	// readOnly handlers only report on the store, which a running server may have open meanwhile
+	readOnly bool
 }
 
 var _ nfs.Handler = &Handler{}
+var _ nfs.CallHandler = &Handler{}
 
-func NewHandler(fs billy.Filesystem, dataPath string) (*Handler, error) {
+// NewHandler opens the handles store at dataPath, with an in-memory cache of up to cacheSize handles in front of it.
+// Mounts and calls are checked against the access policy, a nil policy allows any client to access any export.
+// Handles not used for the expiry duration are removed every maintenanceInterval,
+// a zero expiry keeps handles forever and a zero interval disables maintenance.
+func NewHandler(
+	fs billy.Filesystem,
+	policy *AccessPolicy,
+	dataPath string,
+	cacheSize int,
+	expiry time.Duration,
+	maintenanceInterval time.Duration,
+) (*Handler, error) {
 	opts := badger.DefaultOptions(dataPath)
 	opts.Logger = &badgerLogger{}
 	db, err := badger.Open(opts)
@@ -33,87 +57,182 @@ func NewHandler(fs billy.Filesystem, dataPath string) (*Handler, error) {
 		return nil, err
 	}
 
-	return &Handler{
-		Handler: helpers.NewNullAuthHandler(fs),
+	cache, err := newHandleCache(cacheSize)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	handler := &Handler{
 		db:      db,
 		fs:      fs,
+		policy:  policy,
+		exports: cmap.New(),
+		cache:   cache,
+		pending: newPendingHandles(),
+		tracker: newAccessTracker(),
+		expiry:  expiry,
+		stop:    make(chan struct{}),
+	}
+	go handler.flushPeriodically()
+	if maintenanceInterval > 0 {
+		go handler.maintainPeriodically(maintenanceInterval)
+	}
+	return handler, nil
+}
+
+// NewReadOnlyHandler opens the handles store at dataPath only for reporting on it, without serving or changing it
+func NewReadOnlyHandler(dataPath string, expiry time.Duration) (*Handler, error) {
+	opts := badger.DefaultOptions(dataPath)
+	opts.Logger = &badgerLogger{}
+	opts.ReadOnly = true
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		db:       db,
+		pending:  newPendingHandles(),
+		tracker:  newAccessTracker(),
+		expiry:   expiry,
+		stop:     make(chan struct{}),
+		readOnly: true,
 	}, nil
 }
 
+func (handler *Handler) Close() error {
+	close(handler.stop)
+	if handler.readOnly {
+		return handler.db.Close()
+	}
+	err := handler.flushPending()
+	if err != nil {
+		logger.Error("handler.Close: failed to flush new handles: %v", err)
+	}
+	err = handler.flushAccess()
+	if err != nil {
+		logger.Error("handler.Close: failed to flush access times: %v", err)
+	}
+	return handler.db.Close()
+}
+
 func newFileHandle() ([]byte, error) {
 	return uuid.New().MarshalBinary()
 }
 
-func (handler *Handler) lookup(key []byte, addIfMissing bool, valueCreator func() ([]byte, error)) (value []byte, err error) {
-	err = handler.db.Update(func(txn *badger.Txn) error {
+// get reads a single mapping in a read-only transaction, returning nil if it doesn't exist
+func (handler *Handler) get(key []byte) (value []byte, err error) {
+	err = handler.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(key)
-		if err != nil {
-			switch err {
-			case badger.ErrKeyNotFound:
-				item = nil
-			default:
-				return err
-			}
-		}
-
-		if item != nil {
-			return item.Value(func(storedValue []byte) error {
-				value = make([]byte, len(storedValue))
-				copy(value, storedValue)
-				return nil
-			})
-		} else if !addIfMissing {
+		if err == badger.ErrKeyNotFound {
 			return nil
 		}
-
-		value, err = valueCreator()
-		if err != nil {
-			return err
-		}
-
-		err = txn.Set(key, value)
 		if err != nil {
 			return err
 		}
-
-		err = txn.Set(value, key)
+		value, err = item.ValueCopy(nil)
 		return err
 	})
 	return
 }
 
-func (handler *Handler) ToHandle(_ billy.Filesystem, path []string) []byte {
-	fullPath := filepath.Join(path...)
-	if len(fullPath) == 0 {
-		fullPath = RootPathPlaceholder
+func (handler *Handler) handleOf(fullPath string) (handle []byte, err error) {
+	var found bool
+	handle, found = handler.cache.handle(fullPath)
+	if found {
+		return
+	}
+	handle, found = handler.pending.handle(fullPath)
+	if found {
+		return
+	}
+	handle, err = handler.get([]byte(fullPath))
+	if err != nil || handle == nil {
+		return
+	}
+	handler.cache.add(fullPath, handle)
+	return
+}
+
+func (handler *Handler) pathOf(handle []byte) (fullPath string, found bool, err error) {
+	fullPath, found = handler.cache.path(handle)
+	if found {
+		return
+	}
+	fullPath, found = handler.pending.path(handle)
+	if found {
+		return
+	}
+	var value []byte
+	value, err = handler.get(handle)
+	if err != nil || value == nil {
+		return "", false, err
+	}
+	fullPath = string(value)
+	handler.cache.add(fullPath, handle)
+	return fullPath, true, nil
+}
+
+func (handler *Handler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	fullPath := handler.storedPath(fs, path)
+	handle, err := handler.handleOf(fullPath)
+	if err == nil && handle == nil {
+		handle, err = handler.mint(fullPath)
 	}
-	handle, err := handler.lookup([]byte(fullPath), true, newFileHandle)
 	if err != nil || handle == nil {
 		logger.Error("handler.ToHandle: failed for '%v': %v", fullPath, err)
 		return nil
 	}
+	handler.tracker.touch(handle)
 	return handle
 }
 
 func (handler *Handler) FromHandle(handle []byte) (fs billy.Filesystem, path []string, err error) {
-	fs = handler.fs
+	fullPath, found, err := handler.pathOf(handle)
+	if err != nil || !found {
+		logger.Info("handler.FromHandle: could not resolve handle '%v': %v", handle, err)
+		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
+	}
 
-	var fullPath []byte
-	fullPath, err = handler.lookup(handle, false, nil)
-	if err != nil || fullPath == nil {
-		logger.Info("handler.ToHandle: could not resolve handle '%v': %v", handle, err)
+	fs, path, err = handler.parseStoredPath(fullPath)
+	if err != nil {
+		logger.Info("handler.FromHandle: could not resolve export of handle '%v': %v", handle, err)
 		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
 	}
+	handler.tracker.touch(handle)
+	return
+}
 
-	fullPathStr := string(fullPath)
-	if fullPathStr == RootPathPlaceholder {
-		path = []string{""}
-	} else {
-		path = strings.Split(fullPathStr, PathSeparator)
+// FromHandleOfCall resolves a handle only if the client of the call is allowed its path by the access policy,
+// as clients can keep using handles they got before, from mounting or otherwise
+func (handler *Handler) FromHandleOfCall(
+	ctx context.Context,
+	conn net.Conn,
+	auth rpc.Auth,
+	handle []byte,
+) (fs billy.Filesystem, path []string, err error) {
+	fs, path, err = handler.FromHandle(handle)
+	if err != nil || handler.policy == nil {
+		return
+	}
+	exportPath := handler.exportPathOf(fs, path)
+	allowed, reason := handler.policy.allowsCall(exportPath, conn, auth)
+	if !allowed {
+		logger.Info("handler.FromHandle: denied '%v' to %v: %v", exportPath, remoteIP(conn), reason)
+		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusAccess, WrappedErr: os.ErrPermission}
 	}
 	return
 }
 
+// Change returns nil as the exported file systems are read-only
+func (handler *Handler) Change(fs billy.Filesystem) billy.Change {
+	return nil
+}
+
+func (handler *Handler) FSStat(ctx context.Context, fs billy.Filesystem, stat *nfs.FSStat) error {
+	return nil
+}
+
 func (handler Handler) HandleLimit() int {
 	return math.MaxInt32
 }
@@ -0,0 +1,364 @@
+// +build bench
+
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/bfs"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const (
+	benchDirsCount  = 100
+	benchFilesCount = 50
+)
+
+// setupBenchClones creates local clones with a commit of benchDirsCount directories of benchFilesCount files each
+func setupBenchClones() (clonesPath string) {
+	clonesPath, _ = testutils.SetupLocalClones()
+	files := map[string]string{}
+	for dir := 0; dir < benchDirsCount; dir++ {
+		for file := 0; file < benchFilesCount; file++ {
+			files[fmt.Sprintf("bench/dir%v/file%v", dir, file)] = fmt.Sprintf("file %v of dir %v\n", file, dir)
+		}
+	}
+	testutils.Commit(path.Join(clonesPath, testutils.LOCAL_REPO_NAME), files, "bench")
+	return clonesPath
+}
+
+// serveHandler serves the handler on a local port, and mounts its root over a client connected to it
+func serveHandler(b *testing.B, handler nfs.Handler) (target *nfsc.Target, unmount func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		_ = nfs.Serve(listener, handler, logger.DebugLogger(), logger.InfoLogger())
+	}()
+	client, err := rpc.DialTCP(listener.Addr().Network(), nil, listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	mounter := &nfsc.Mount{Client: client}
+	target, err = mounter.Mount("/", rpc.AuthNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return target, func() {
+		_ = mounter.Unmount()
+		_ = client.Close()
+		_ = listener.Close()
+	}
+}
+
+// readDirPlus lists a directory by its handle, with larger pages than the client asks for, which the server rejects
+func readDirPlus(b *testing.B, target *nfsc.Target, handle []byte) (entries []*nfsc.EntryPlus) {
+	type readDirPlusArgs struct {
+		rpc.Header
+		Handle     []byte
+		Cookie     uint64
+		CookieVerf uint64
+		DirCount   uint32
+		MaxCount   uint32
+	}
+	type dirListOk struct {
+		DirAttrs   nfsc.PostOpAttr
+		CookieVerf uint64
+	}
+	type dirListEntry struct {
+		IsSet bool           `xdr:"union"`
+		Entry nfsc.EntryPlus `xdr:"unioncase=1"`
+	}
+
+	cookie, cookieVerf, eof := uint64(0), uint64(0), false
+	for !eof {
+		res, err := target.Call(&readDirPlusArgs{
+			Header: rpc.Header{
+				Rpcvers: 2,
+				Prog:    nfsc.Nfs3Prog,
+				Vers:    nfsc.Nfs3Vers,
+				Proc:    nfsc.NFSProc3ReadDirPlus,
+				Cred:    rpc.AuthNull,
+				Verf:    rpc.AuthNull,
+			},
+			Handle:     handle,
+			Cookie:     cookie,
+			CookieVerf: cookieVerf,
+			DirCount:   64 * 1024,
+			MaxCount:   1024 * 1024,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		status, err := xdr.ReadUint32(res)
+		if err == nil {
+			err = nfsc.NFS3Error(status)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		dirList := &dirListOk{}
+		err = xdr.Read(res, dirList)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			entry := &dirListEntry{}
+			err = xdr.Read(res, entry)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !entry.IsSet {
+				break
+			}
+			cookie = entry.Entry.Cookie
+			entries = append(entries, &entry.Entry)
+		}
+		err = xdr.Read(res, &eof)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cookieVerf = dirList.CookieVerf
+	}
+	return entries
+}
+
+// walkTarget walks a directory over NFS as a client would, listing every directory by its handle and reading every file
+func walkTarget(b *testing.B, target *nfsc.Target, dirPath string, dirHandle []byte) (filesCount int) {
+	for _, entry := range readDirPlus(b, target, dirHandle) {
+		if entry.FileName == "." || entry.FileName == ".." {
+			continue
+		}
+		entryPath := path.Join(dirPath, entry.FileName)
+		if entry.IsDir() {
+			filesCount += walkTarget(b, target, entryPath, entry.Handle.FH)
+			continue
+		}
+		file, err := target.Open(entryPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = file.Close()
+		filesCount++
+	}
+	return filesCount
+}
+
+// walk walks the bench directory of the served clones, from looking it up
+func walk(b *testing.B, target *nfsc.Target) (filesCount int) {
+	walkPath := "/" + path.Join(testutils.LOCAL_REPO_NAME, "master", "bench")
+	_, handle, err := target.Lookup(walkPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return walkTarget(b, target, walkPath, handle)
+}
+
+// updateHandler resolves handles as the handler did before it had a cache, pending handles and read-only lookups,
+// looking every handle up in a write transaction, as a baseline for the benchmarks
+type updateHandler struct {
+	*Handler
+}
+
+func (handler *updateHandler) lookup(key []byte, addIfMissing bool) (value []byte, err error) {
+	err = handler.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			value, err = item.ValueCopy(nil)
+			return err
+		}
+		if !addIfMissing {
+			return nil
+		}
+		value, err = newFileHandle()
+		if err != nil {
+			return err
+		}
+		err = txn.Set(key, value)
+		if err == nil {
+			err = txn.Set(value, key)
+		}
+		if err == nil {
+			err = txn.Set(accessKey(value), encodeAccessTime(time.Now().Unix()))
+		}
+		return err
+	})
+	return
+}
+
+func (handler *updateHandler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	handle, err := handler.lookup([]byte(handler.storedPath(fs, path)), true)
+	if err != nil {
+		return nil
+	}
+	handler.tracker.touch(handle)
+	return handle
+}
+
+func (handler *updateHandler) FromHandle(handle []byte) (fs billy.Filesystem, path []string, err error) {
+	fullPath, err := handler.lookup(handle, false)
+	if err != nil || fullPath == nil {
+		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
+	}
+	handler.tracker.touch(handle)
+	return handler.parseStoredPath(string(fullPath))
+}
+
+// FromHandleOfCall resolves handles of calls by FromHandle, as the benchmarks serve without an access policy
+func (handler *updateHandler) FromHandleOfCall(
+	_ context.Context,
+	_ net.Conn,
+	_ rpc.Auth,
+	handle []byte,
+) (fs billy.Filesystem, path []string, err error) {
+	return handler.FromHandle(handle)
+}
+
+// newBenchHandler opens a handler of the given cache size, or the baseline handler if byUpdate
+func newBenchHandler(b *testing.B, fs billy.Filesystem, cacheSize int, byUpdate bool) (handler nfs.Handler, close func()) {
+	dataPath, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	gitreefsHandler, err := NewHandler(fs, nil, dataPath, cacheSize, DefaultHandleExpiry, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	close = func() {
+		_ = gitreefsHandler.Close()
+		_ = os.RemoveAll(dataPath)
+	}
+	if byUpdate {
+		return &updateHandler{Handler: gitreefsHandler}, close
+	}
+	return gitreefsHandler, close
+}
+
+// flush writes the handles pending in a handler to its store, so measured runs read them as a restarted server would
+func flush(b *testing.B, handler nfs.Handler) {
+	gitreefsHandler, isGitreefsHandler := handler.(*Handler)
+	if !isGitreefsHandler {
+		return
+	}
+	err := gitreefsHandler.flushPending()
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func benchmarkWalkFileSystem(b *testing.B, cacheSize int, byUpdate bool) {
+	logger.InitLoggers("logs/handler_bench_test-%v-%v.log", "ERROR", "-")
+	clonesPath := setupBenchClones()
+	defer os.RemoveAll(clonesPath)
+
+	fs, err := bfs.NewGitFileSystem(clonesPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler, closeHandler := newBenchHandler(b, fs, cacheSize, byUpdate)
+	defer closeHandler()
+	target, unmount := serveHandler(b, handler)
+	defer unmount()
+
+	// the first walk mints all handles and loads the tree, the measured walks are repeated ones as in the walk benchmark
+	filesCount := walk(b, target)
+	if filesCount != benchDirsCount*benchFilesCount {
+		b.Fatalf("walked %v files, expected %v", filesCount, benchDirsCount*benchFilesCount)
+	}
+	flush(b, handler)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walk(b, target)
+	}
+}
+
+// The walk benchmarks measure whole walks over NFS, which mostly read git objects and file contents,
+// so handles are only part of their time; the handles benchmarks below measure resolving handles alone.
+
+func BenchmarkWalkFileSystemCached(b *testing.B) {
+	benchmarkWalkFileSystem(b, DefaultHandleCacheSize, false)
+}
+
+func BenchmarkWalkFileSystemUncached(b *testing.B) {
+	benchmarkWalkFileSystem(b, 0, false)
+}
+
+func BenchmarkWalkFileSystemByUpdate(b *testing.B) {
+	benchmarkWalkFileSystem(b, 0, true)
+}
+
+// benchPaths are the paths of the files in the bench commit, as a walk would get handles of
+func benchPaths() (paths [][]string) {
+	for dir := 0; dir < benchDirsCount; dir++ {
+		for file := 0; file < benchFilesCount; file++ {
+			paths = append(paths, []string{
+				testutils.LOCAL_REPO_NAME, "master", "bench", fmt.Sprintf("dir%v", dir), fmt.Sprintf("file%v", file),
+			})
+		}
+	}
+	return paths
+}
+
+// benchmarkHandles measures getting the handle of a known path and resolving it back, as listing and then using
+// an entry does, without serving or reading anything
+func benchmarkHandles(b *testing.B, cacheSize int, byUpdate bool) {
+	logger.InitLoggers("logs/handler_bench_test-%v-%v.log", "ERROR", "-")
+	handler, closeHandler := newBenchHandler(b, nil, cacheSize, byUpdate)
+	defer closeHandler()
+
+	paths := benchPaths()
+	for _, path := range paths {
+		if handler.ToHandle(nil, path) == nil {
+			b.Fatalf("failed minting a handle of %v", path)
+		}
+	}
+	flush(b, handler)
+
+	// the server resolves handles of concurrent calls in parallel
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.Intn(len(paths)); pb.Next(); i++ {
+			handle := handler.ToHandle(nil, paths[i%len(paths)])
+			_, _, err := handler.FromHandle(handle)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkHandlesCached(b *testing.B) {
+	benchmarkHandles(b, DefaultHandleCacheSize, false)
+}
+
+func BenchmarkHandlesUncached(b *testing.B) {
+	benchmarkHandles(b, 0, false)
+}
+
+func BenchmarkHandlesByUpdate(b *testing.B) {
+	benchmarkHandles(b, 0, true)
+}
@@ -0,0 +1,446 @@
+package nfs
+
+import (
+	"context"
+	"github.com/dgraph-io/badger"
+	"github.com/go-git/go-billy/v5"
+	"github.com/stretchr/testify/suite"
+	"github.com/willscott/go-nfs"
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"gitreefs/control"
+	"gitreefs/core/logger"
+	"gitreefs/core/virtualfs/bfs"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+type handlerTestSuite struct {
+	suite.Suite
+	clonesPath string
+	commits    []string
+	fs         *bfs.GitFileSystem
+	dataPath   string
+	handler    *Handler
+}
+
+func TestHandlerTestSuite(t *testing.T) {
+	logger.InitLoggers("logs/handler_test-%v-%v.log", "ERROR", "-")
+	suite.Run(t, new(handlerTestSuite))
+}
+
+func (handlerSuite *handlerTestSuite) SetupTest() {
+	var err error
+	handlerSuite.clonesPath, handlerSuite.commits = testutils.SetupLocalClones()
+	handlerSuite.fs, err = bfs.NewGitFileSystem(handlerSuite.clonesPath)
+	if err != nil {
+		panic(err)
+	}
+	handlerSuite.dataPath, err = ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	handlerSuite.handler, err = NewHandler(handlerSuite.fs, nil, handlerSuite.dataPath, DefaultHandleCacheSize, time.Hour, 0)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (handlerSuite *handlerTestSuite) TearDownTest() {
+	handlerSuite.handler.Close()
+	os.RemoveAll(handlerSuite.dataPath)
+	os.RemoveAll(handlerSuite.clonesPath)
+}
+
+func (handlerSuite *handlerTestSuite) flush() {
+	handlerSuite.Nil(handlerSuite.handler.flushPending())
+	handlerSuite.Nil(handlerSuite.handler.flushAccess())
+}
+
+func (handlerSuite *handlerTestSuite) setAccessTime(handle []byte, accessTime time.Time) {
+	err := handlerSuite.handler.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(accessKey(handle), encodeAccessTime(accessTime.Unix()))
+	})
+	handlerSuite.Nil(err)
+}
+
+func (handlerSuite *handlerTestSuite) TestHandleRoundTrip() {
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "README.md"})
+	handlerSuite.NotEmpty(handle)
+	handlerSuite.Equal(handle, handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "README.md"}))
+
+	_, path, err := handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{"repo", "master", "README.md"}, path)
+
+	rootHandle := handlerSuite.handler.ToHandle(nil, []string{})
+	_, path, err = handlerSuite.handler.FromHandle(rootHandle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{""}, path)
+}
+
+func (handlerSuite *handlerTestSuite) TestMaintainExpiresUnusedHandles() {
+	rootHandle := handlerSuite.handler.ToHandle(nil, []string{})
+	unusedHandle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "old"})
+	usedHandle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "new"})
+	handlerSuite.flush()
+
+	longAgo := time.Now().Add(-48 * time.Hour)
+	handlerSuite.setAccessTime(rootHandle, longAgo)
+	handlerSuite.setAccessTime(unusedHandle, longAgo)
+
+	report, err := handlerSuite.handler.MaintainHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(3, report.Handles)
+	handlerSuite.EqualValues(1, report.Expired)
+
+	_, _, err = handlerSuite.handler.FromHandle(unusedHandle)
+	handlerSuite.NotNil(err)
+	_, _, err = handlerSuite.handler.FromHandle(usedHandle)
+	handlerSuite.Nil(err)
+	_, _, err = handlerSuite.handler.FromHandle(rootHandle)
+	handlerSuite.Nil(err)
+
+	// an expired path gets a fresh handle on its next lookup
+	renewedHandle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "old"})
+	handlerSuite.NotEmpty(renewedHandle)
+	handlerSuite.NotEqual(unusedHandle, renewedHandle)
+}
+
+func (handlerSuite *handlerTestSuite) TestMaintainKeepsRecentlyAccessedHandles() {
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.flush()
+	handlerSuite.setAccessTime(handle, time.Now().Add(-48*time.Hour))
+
+	// an access that wasn't flushed yet still counts
+	_, _, err := handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+
+	report, err := handlerSuite.handler.MaintainHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(0, report.Expired)
+	_, _, err = handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+}
+
+func (handlerSuite *handlerTestSuite) TestMaintainTracksHandlesWithoutAccessTime() {
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.flush()
+	err := handlerSuite.handler.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(accessKey(handle))
+	})
+	handlerSuite.Nil(err)
+
+	report, err := handlerSuite.handler.MaintainHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(1, report.Handles)
+	handlerSuite.EqualValues(0, report.Expired)
+
+	report, err = handlerSuite.handler.ReportHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.False(report.OldestAccess.IsZero())
+}
+
+func (handlerSuite *handlerTestSuite) TestNoExpiry() {
+	handlerSuite.handler.expiry = 0
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.flush()
+	handlerSuite.setAccessTime(handle, time.Now().Add(-365*24*time.Hour))
+
+	report, err := handlerSuite.handler.CompactHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(1, report.Handles)
+	handlerSuite.EqualValues(0, report.Expired)
+	_, _, err = handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+}
+
+func (handlerSuite *handlerTestSuite) TestHandlesSurviveRestart() {
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.Nil(handlerSuite.handler.Close())
+
+	var err error
+	handlerSuite.handler, err = NewHandler(handlerSuite.fs, nil, handlerSuite.dataPath, DefaultHandleCacheSize, time.Hour, 0)
+	handlerSuite.Nil(err)
+	_, path, err := handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{"repo", "master", "file"}, path)
+	handlerSuite.Equal(handle, handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"}))
+}
+
+func (handlerSuite *handlerTestSuite) TestReadOnlyReport() {
+	handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.Nil(handlerSuite.handler.Close())
+
+	var err error
+	handlerSuite.handler, err = NewReadOnlyHandler(handlerSuite.dataPath, time.Hour)
+	handlerSuite.Nil(err)
+	report, err := handlerSuite.handler.ReportHandles()
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(1, report.Handles)
+	_, err = handlerSuite.handler.CompactHandles()
+	handlerSuite.NotNil(err)
+}
+
+func (handlerSuite *handlerTestSuite) TestHandlesThroughControlEndpoint() {
+	handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	server, err := control.Serve(":0", "", nil)
+	handlerSuite.Nil(err)
+	defer server.Close()
+	server.Handle(HandlesPath, handlerSuite.handler.serveHandles)
+	client := control.NewClient(server.Address(), "")
+
+	report := &HandlesReport{}
+	handlerSuite.Nil(client.Call(http.MethodGet, HandlesPath, nil, http.StatusOK, report))
+	handlerSuite.EqualValues(1, report.Handles)
+	report = &HandlesReport{}
+	handlerSuite.Nil(client.Call(http.MethodPost, HandlesPath, nil, http.StatusOK, report))
+	handlerSuite.EqualValues(1, report.Handles)
+	handlerSuite.NotNil(client.Call(http.MethodDelete, HandlesPath, nil, http.StatusOK, report))
+}
+
+func (handlerSuite *handlerTestSuite) TestHandlesWithoutCache() {
+	handlerSuite.handler.cache = nil
+	handle := handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+	handlerSuite.flush()
+	handlerSuite.Equal(handle, handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"}))
+	_, path, err := handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{"repo", "master", "file"}, path)
+}
+
+func (handlerSuite *handlerTestSuite) TestConcurrentMintingAgreesOnHandle() {
+	handles := make(chan []byte, 20)
+	for i := 0; i < cap(handles); i++ {
+		go func() {
+			handles <- handlerSuite.handler.ToHandle(nil, []string{"repo", "master", "file"})
+		}()
+	}
+	first := <-handles
+	for i := 1; i < cap(handles); i++ {
+		handlerSuite.Equal(first, <-handles)
+	}
+}
+
+func (handlerSuite *handlerTestSuite) mount(exportPath string) (nfs.MountStatus, billy.Filesystem) {
+	status, fs, _ := handlerSuite.handler.Mount(context.Background(), nil, nfs.MountRequest{Dirpath: []byte(exportPath)})
+	return status, fs
+}
+
+func (handlerSuite *handlerTestSuite) TestMountMainExport() {
+	status, fs := handlerSuite.mount("/")
+	handlerSuite.Equal(nfs.MountStatusOk, status)
+	handlerSuite.Equal(handlerSuite.fs, fs)
+}
+
+func (handlerSuite *handlerTestSuite) TestMountCommitishExport() {
+	for _, exportPath := range []string{"/local/master", "/local/" + handlerSuite.commits[0], "local/master/src/"} {
+		status, fs := handlerSuite.mount(exportPath)
+		handlerSuite.Equal(nfs.MountStatusOk, status, "failed to mount %v", exportPath)
+		handlerSuite.NotNil(fs)
+	}
+
+	_, fs := handlerSuite.mount("/local/master")
+	rootHandle := handlerSuite.handler.ToHandle(fs, []string{})
+	fileHandle := handlerSuite.handler.ToHandle(fs, []string{"src", "main.go"})
+
+	// the same path in the main export is a different file
+	handlerSuite.NotEqual(fileHandle, handlerSuite.handler.ToHandle(handlerSuite.fs, []string{"src", "main.go"}))
+
+	resolvedFs, path, err := handlerSuite.handler.FromHandle(rootHandle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal("/local/master", resolvedFs.Root())
+	handlerSuite.Equal([]string{""}, path)
+
+	resolvedFs, path, err = handlerSuite.handler.FromHandle(fileHandle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{"src", "main.go"}, path)
+	info, err := resolvedFs.Stat(resolvedFs.Join(path...))
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(len(testutils.LocalFiles["src/main.go"]), info.Size())
+}
+
+func (handlerSuite *handlerTestSuite) TestExportHandlesSurviveRestart() {
+	_, fs := handlerSuite.mount("/local/master/src")
+	handle := handlerSuite.handler.ToHandle(fs, []string{"pkg", "util.go"})
+	handlerSuite.Nil(handlerSuite.handler.Close())
+
+	var err error
+	handlerSuite.handler, err = NewHandler(handlerSuite.fs, nil, handlerSuite.dataPath, DefaultHandleCacheSize, time.Hour, 0)
+	handlerSuite.Nil(err)
+	resolvedFs, path, err := handlerSuite.handler.FromHandle(handle)
+	handlerSuite.Nil(err)
+	handlerSuite.Equal("/local/master/src", resolvedFs.Root())
+	_, err = resolvedFs.Stat(resolvedFs.Join(path...))
+	handlerSuite.Nil(err)
+}
+
+func (handlerSuite *handlerTestSuite) TestMountInvalidExports() {
+	status, _ := handlerSuite.mount("/wat")
+	handlerSuite.Equal(nfs.MountStatusErrNoEnt, status)
+	status, _ = handlerSuite.mount("/local/wat")
+	handlerSuite.Equal(nfs.MountStatusErrNoEnt, status)
+	status, _ = handlerSuite.mount("/local/master/README.md")
+	handlerSuite.Equal(nfs.MountStatusErrNotDir, status)
+}
+
+func (handlerSuite *handlerTestSuite) TestMountWithinExportRoot() {
+	exportRoot, err := handlerSuite.fs.Chroot("local/master")
+	handlerSuite.Nil(err)
+	handlerSuite.handler.fs = exportRoot
+
+	status, fs := handlerSuite.mount("/src")
+	handlerSuite.Equal(nfs.MountStatusOk, status)
+	handlerSuite.Equal("/local/master/src", fs.Root())
+
+	// export paths can't climb above the export root
+	status, fs = handlerSuite.mount("/../..")
+	handlerSuite.Equal(nfs.MountStatusOk, status)
+	handlerSuite.Equal(exportRoot, fs)
+	status, _ = handlerSuite.mount("/../../local")
+	handlerSuite.Equal(nfs.MountStatusErrNoEnt, status)
+
+	_, _, err = handlerSuite.handler.parseStoredPath("/other" + exportSeparator + "/")
+	handlerSuite.NotNil(err)
+}
+
+func (handlerSuite *handlerTestSuite) mountAs(exportPath string, conn net.Conn, auth rpc.Auth) nfs.MountStatus {
+	req := nfs.MountRequest{Dirpath: []byte(exportPath)}
+	req.Cred = auth
+	status, _, _ := handlerSuite.handler.Mount(context.Background(), conn, req)
+	return status
+}
+
+func (handlerSuite *handlerTestSuite) TestMountAccessPolicy() {
+	policy, err := ParseAccessPolicy([]byte(testPolicy))
+	handlerSuite.Nil(err)
+	handlerSuite.handler.policy = policy
+
+	handlerSuite.Equal(nfs.MountStatusOk, handlerSuite.mountAs("/", newFakeConn("127.0.0.1"), rpc.AuthNull))
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/", newFakeConn("10.0.0.1"), rpc.AuthNull))
+
+	handlerSuite.Equal(nfs.MountStatusOk, handlerSuite.mountAs("/local/master", newFakeConn("10.0.0.1"), unixAuth(1000, 1000)))
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/local/master", newFakeConn("10.0.0.1"), unixAuth(1001, 1001)))
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/local/master", newFakeConn("10.0.0.1"), rpc.AuthNull))
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/local/master", newFakeConn("127.0.0.1"), unixAuth(1000, 1000)))
+
+	// policy is checked before looking the export up, so denied clients can't probe for repositories
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/local/wat", newFakeConn("127.0.0.1"), rpc.AuthNull))
+	handlerSuite.Equal(nfs.MountStatusErrNoEnt, handlerSuite.mountAs("/local/wat", newFakeConn("10.0.0.1"), unixAuth(1000, 100)))
+
+	handlerSuite.Equal(nfs.MountStatusErrAcces, handlerSuite.mountAs("/", newFakeConn("127.0.0.1"), rpc.Auth{Flavor: uint32(nfs.AuthFlavorUnix)}))
+}
+
+func (handlerSuite *handlerTestSuite) fromHandleAs(handle []byte, conn net.Conn, auth rpc.Auth) (path []string, err error) {
+	_, path, err = handlerSuite.handler.FromHandleOfCall(context.Background(), conn, auth, handle)
+	return
+}
+
+func isAccessDenied(err error) bool {
+	statusErr, isStatusErr := err.(*nfs.NFSStatusError)
+	return isStatusErr && statusErr.NFSStatus == nfs.NFSStatusAccess
+}
+
+func (handlerSuite *handlerTestSuite) TestFromHandleOfCallAccessPolicy() {
+	policy, err := ParseAccessPolicy([]byte(testPolicy))
+	handlerSuite.Nil(err)
+	handlerSuite.handler.policy = policy
+	local := newFakeConn("127.0.0.1")
+	remote := newFakeConn("10.0.0.1")
+
+	rootHandle := handlerSuite.handler.ToHandle(nil, []string{})
+	_, err = handlerSuite.fromHandleAs(rootHandle, local, rpc.AuthNull)
+	handlerSuite.Nil(err)
+	_, err = handlerSuite.fromHandleAs(rootHandle, remote, unixAuth(1000, 100))
+	handlerSuite.True(isAccessDenied(err))
+
+	readmeHandle := handlerSuite.handler.ToHandle(nil, []string{"local", "master", "README.md"})
+	_, err = handlerSuite.fromHandleAs(readmeHandle, local, unixAuth(1000, 100))
+	handlerSuite.True(isAccessDenied(err))
+	path, err := handlerSuite.fromHandleAs(readmeHandle, remote, unixAuth(1000, 100))
+	handlerSuite.Nil(err)
+	handlerSuite.Equal([]string{"local", "master", "README.md"}, path)
+	// credentials are checked on every call, not only when mounting
+	_, err = handlerSuite.fromHandleAs(readmeHandle, remote, unixAuth(1001, 1001))
+	handlerSuite.True(isAccessDenied(err))
+	_, err = handlerSuite.fromHandleAs(readmeHandle, remote, rpc.AuthNull)
+	handlerSuite.True(isAccessDenied(err))
+
+	// handles within an export are checked by their path relative to the main export
+	export, err := handlerSuite.handler.export("/local/master")
+	handlerSuite.Nil(err)
+	exportHandle := handlerSuite.handler.ToHandle(export, []string{"README.md"})
+	_, err = handlerSuite.fromHandleAs(exportHandle, local, rpc.AuthNull)
+	handlerSuite.True(isAccessDenied(err))
+	_, err = handlerSuite.fromHandleAs(exportHandle, remote, unixAuth(1001, 100))
+	handlerSuite.Nil(err)
+
+	// unknown handles are still stale rather than denied
+	_, err = handlerSuite.fromHandleAs([]byte("wat"), local, rpc.AuthNull)
+	handlerSuite.NotNil(err)
+	handlerSuite.False(isAccessDenied(err))
+}
+
+// serveTestPolicy serves the handler by a policy on a local port, returning a client connected to it
+func (handlerSuite *handlerTestSuite) serveTestPolicy(policy string) (client *rpc.Client, close func()) {
+	accessPolicy, err := ParseAccessPolicy([]byte(policy))
+	handlerSuite.Nil(err)
+	handlerSuite.handler.policy = accessPolicy
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	handlerSuite.Nil(err)
+	go func() {
+		_ = nfs.Serve(listener, handlerSuite.handler, logger.DebugLogger(), logger.InfoLogger())
+	}()
+	client, err = rpc.DialTCP(listener.Addr().Network(), nil, listener.Addr().String())
+	handlerSuite.Nil(err)
+	return client, func() {
+		_ = client.Close()
+		_ = listener.Close()
+	}
+}
+
+func (handlerSuite *handlerTestSuite) TestServePolicy() {
+	client, closeClient := handlerSuite.serveTestPolicy(
+		`{"exports": [{"path": "/local", "clients": ["127.0.0.1"], "uids": [1000]}]}`)
+	defer closeClient()
+
+	mounter := &nfsc.Mount{Client: client}
+	auth := rpc.NewAuthUnix("client", 1000, 1000).Auth()
+	target, err := mounter.Mount("/local/master", auth)
+	handlerSuite.Nil(err)
+	info, _, err := target.Lookup("README.md")
+	handlerSuite.Nil(err)
+	handlerSuite.EqualValues(len(testutils.LocalFiles["README.md"]), info.Size())
+
+	_, err = mounter.Mount("/local/master", rpc.NewAuthUnix("client", 1001, 1001).Auth())
+	handlerSuite.NotNil(err)
+	_, err = mounter.Mount("/", auth)
+	handlerSuite.NotNil(err)
+
+	// handles the client got some other way, e.g. before the policy changed, are denied as well
+	rootHandle := handlerSuite.handler.ToHandle(nil, []string{})
+	_, err = nfsc.NewTargetWithClient(client, auth, rootHandle, "/")
+	handlerSuite.Equal(&nfsc.Error{ErrorNum: nfsc.NFS3ErrAcces, ErrorString: "NFS3ERR_ACCES"}, err)
+	// and so are calls with other credentials than the mount's
+	exportHandle := handlerSuite.handler.ToHandle(nil, []string{"local", "master"})
+	_, err = nfsc.NewTargetWithClient(client, rpc.NewAuthUnix("client", 1001, 1001).Auth(), exportHandle, "/")
+	handlerSuite.Equal(&nfsc.Error{ErrorNum: nfsc.NFS3ErrAcces, ErrorString: "NFS3ERR_ACCES"}, err)
+}
+
+func (handlerSuite *handlerTestSuite) TestServePolicyDeniesAddresses() {
+	client, closeClient := handlerSuite.serveTestPolicy(`{"exports": [{"path": "/", "clients": ["10.0.0.0/8"]}]}`)
+	defer closeClient()
+
+	// clients no export allows are still served, and denied with MNT3ERR_ACCES / NFS3ERR_ACCES
+	mounter := &nfsc.Mount{Client: client}
+	_, err := mounter.Mount("/", rpc.AuthNull)
+	handlerSuite.EqualError(err, "MNT3ERR_ACCES")
+	rootHandle := handlerSuite.handler.ToHandle(nil, []string{})
+	_, err = nfsc.NewTargetWithClient(client, rpc.AuthNull, rootHandle, "/")
+	handlerSuite.Equal(&nfsc.Error{ErrorNum: nfsc.NFS3ErrAcces, ErrorString: "NFS3ERR_ACCES"}, err)
+}
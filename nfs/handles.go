@@ -0,0 +1,340 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/google/uuid"
+	"gitreefs/control"
+	"gitreefs/core/logger"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// access records are kept under a prefix that can never start a path or a handle
+	accessKeyPrefix      = "\x00access:"
+	valueLogDiscardRatio = 0.5
+	// HandlesPath is the path of the control endpoint reporting on the handles store of a running server by GET,
+	// and compacting it by POST
+	HandlesPath                = "/nfs-handles"
+	DefaultHandleExpiry        = 30 * 24 * time.Hour
+	DefaultMaintenanceInterval = 10 * time.Minute
+)
+
+type HandlesReport struct {
+	Handles      int       `json:"handles"`
+	Expired      int       `json:"expired"`
+	OldestAccess time.Time `json:"oldest_access"`
+	LsmBytes     int64     `json:"lsm_bytes"`
+	VlogBytes    int64     `json:"vlog_bytes"`
+}
+
+func (report *HandlesReport) Print(writer io.Writer) {
+	fmt.Fprintf(writer, "handles:        %v\n", report.Handles)
+	fmt.Fprintf(writer, "expired:        %v\n", report.Expired)
+	if !report.OldestAccess.IsZero() {
+		fmt.Fprintf(writer, "oldest access:  %v\n", report.OldestAccess.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(writer, "lsm size:       %v bytes\n", report.LsmBytes)
+	fmt.Fprintf(writer, "value log size: %v bytes\n", report.VlogBytes)
+}
+
+// accessTracker records handle usage in memory, so lookups never need a write transaction just for bookkeeping
+type accessTracker struct {
+	mutex    *sync.Mutex
+	accessed map[string]int64
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{
+		mutex:    &sync.Mutex{},
+		accessed: make(map[string]int64),
+	}
+}
+
+func (tracker *accessTracker) touch(handle []byte) {
+	now := time.Now().Unix()
+	tracker.mutex.Lock()
+	tracker.accessed[string(handle)] = now
+	tracker.mutex.Unlock()
+}
+
+func (tracker *accessTracker) drain() (accessed map[string]int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	accessed = tracker.accessed
+	tracker.accessed = make(map[string]int64)
+	return
+}
+
+func accessKey(handle []byte) []byte {
+	return append([]byte(accessKeyPrefix), handle...)
+}
+
+func encodeAccessTime(unixSeconds int64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(unixSeconds))
+	return value
+}
+
+func decodeAccessTime(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+func isHandleKey(key []byte) bool {
+	if bytes.HasPrefix(key, []byte(accessKeyPrefix)) {
+		return false
+	}
+	parsed, err := uuid.FromBytes(key)
+	return err == nil && parsed.Version() == 4 && parsed.Variant() == uuid.RFC4122
+}
+
+func (handler *Handler) flushAccess() (err error) {
+	accessed := handler.tracker.drain()
+	if len(accessed) == 0 {
+		return
+	}
+	batch := handler.db.NewWriteBatch()
+	defer batch.Cancel()
+	for handle, unixSeconds := range accessed {
+		err = batch.Set(accessKey([]byte(handle)), encodeAccessTime(unixSeconds))
+		if err != nil {
+			return
+		}
+	}
+	return batch.Flush()
+}
+
+// scanHandles goes over all handles, reporting on them and collecting the ones not accessed since the cutoff
+func (handler *Handler) scanHandles(cutoff time.Time) (report *HandlesReport, expired [][]byte, missingAccess [][]byte, err error) {
+	report = &HandlesReport{}
+	report.LsmBytes, report.VlogBytes = handler.db.Size()
+	oldest := int64(0)
+	err = handler.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.PrefetchValues = false
+		iter := txn.NewIterator(iterOpts)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			if !isHandleKey(key) {
+				continue
+			}
+			report.Handles++
+			accessItem, err := txn.Get(accessKey(key))
+			if err == badger.ErrKeyNotFound {
+				missingAccess = append(missingAccess, key)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var accessTime int64
+			err = accessItem.Value(func(value []byte) error {
+				accessTime = decodeAccessTime(value)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if oldest == 0 || accessTime < oldest {
+				oldest = accessTime
+			}
+			if accessTime < cutoff.Unix() {
+				expired = append(expired, key)
+			}
+		}
+		return nil
+	})
+	if oldest > 0 {
+		report.OldestAccess = time.Unix(oldest, 0)
+	}
+	report.Expired = len(expired)
+	return
+}
+
+func (handler *Handler) expireHandle(handle []byte, cutoff time.Time) (removed bool, err error) {
+	var fullPath []byte
+	err = handler.db.Update(func(txn *badger.Txn) error {
+		// re-check within the transaction, a concurrent flush of a fresh access conflicts and aborts the removal
+		accessItem, err := txn.Get(accessKey(handle))
+		if err != nil {
+			return err
+		}
+		var accessTime int64
+		err = accessItem.Value(func(value []byte) error {
+			accessTime = decodeAccessTime(value)
+			return nil
+		})
+		if err != nil || accessTime >= cutoff.Unix() {
+			return err
+		}
+		pathItem, err := txn.Get(handle)
+		if err != nil {
+			return err
+		}
+		fullPath, err = pathItem.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if isExportRoot(string(fullPath)) {
+			// root handles are handed to every mount and are never expired
+			return nil
+		}
+		for _, key := range [][]byte{fullPath, handle, accessKey(handle)} {
+			err = txn.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		removed = true
+		return nil
+	})
+	if err == nil && removed {
+		handler.cache.remove(string(fullPath))
+	}
+	return
+}
+
+// MaintainHandles expires handles that weren't used within the expiry period and reclaims value log space.
+// It is safe to run while the server is serving requests.
+func (handler *Handler) MaintainHandles() (report *HandlesReport, err error) {
+	err = handler.flushPending()
+	if err != nil {
+		return nil, fmt.Errorf("flushing new handles: %w", err)
+	}
+	err = handler.flushAccess()
+	if err != nil {
+		return nil, fmt.Errorf("flushing handle access times: %w", err)
+	}
+
+	cutoff := time.Now().Add(-handler.expiry)
+	var expired, missingAccess [][]byte
+	report, expired, missingAccess, err = handler.scanHandles(cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("scanning handles: %w", err)
+	}
+
+	// handles which predate access tracking start their expiry period now
+	for _, handle := range missingAccess {
+		handler.tracker.touch(handle)
+	}
+	err = handler.flushAccess()
+	if err != nil {
+		return nil, fmt.Errorf("flushing handle access times: %w", err)
+	}
+
+	if handler.expiry > 0 {
+		removed := 0
+		for _, handle := range expired {
+			wasRemoved, expireErr := handler.expireHandle(handle, cutoff)
+			if expireErr != nil {
+				logger.Debug("handler.MaintainHandles: skipped expiring handle %v: %v", handle, expireErr)
+				continue
+			}
+			if wasRemoved {
+				removed++
+			}
+		}
+		report.Expired = removed
+		logger.Info("handler.MaintainHandles: expired %v out of %v handles", removed, report.Handles)
+	} else {
+		report.Expired = 0
+	}
+
+	for {
+		// each successful run rewrites a single value log file, keep going until nothing is left to reclaim
+		gcErr := handler.db.RunValueLogGC(valueLogDiscardRatio)
+		if gcErr != nil {
+			if gcErr != badger.ErrNoRewrite {
+				logger.Error("handler.MaintainHandles: value log gc failed: %v", gcErr)
+			}
+			break
+		}
+	}
+	report.LsmBytes, report.VlogBytes = handler.db.Size()
+	return
+}
+
+// CompactHandles maintains the handles and then compacts the LSM tree into a single level.
+func (handler *Handler) CompactHandles() (report *HandlesReport, err error) {
+	if handler.readOnly {
+		return nil, errors.New("a read-only handles store can't be compacted")
+	}
+	report, err = handler.MaintainHandles()
+	if err != nil {
+		return
+	}
+	err = handler.db.Flatten(1)
+	if err != nil {
+		return nil, fmt.Errorf("flattening handles store: %w", err)
+	}
+	report.LsmBytes, report.VlogBytes = handler.db.Size()
+	return
+}
+
+// ReportHandles reports the state of the handles store, without changing it.
+// Handles minted or accessed since the last flush are flushed first, unless the handler is read-only.
+func (handler *Handler) ReportHandles() (report *HandlesReport, err error) {
+	if !handler.readOnly {
+		err = handler.flushPending()
+		if err != nil {
+			return nil, fmt.Errorf("flushing new handles: %w", err)
+		}
+		err = handler.flushAccess()
+		if err != nil {
+			return nil, fmt.Errorf("flushing handle access times: %w", err)
+		}
+	}
+	cutoff := time.Now().Add(-handler.expiry)
+	report, _, _, err = handler.scanHandles(cutoff)
+	if handler.expiry <= 0 && report != nil {
+		report.Expired = 0
+	}
+	return
+}
+
+func (handler *Handler) maintainPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-handler.stop:
+			return
+		case <-ticker.C:
+			_, err := handler.MaintainHandles()
+			if err != nil {
+				logger.Error("handler.maintainPeriodically: %v", err)
+			}
+		}
+	}
+}
+
+// serveHandles serves the HandlesPath of the control endpoint
+func (handler *Handler) serveHandles(writer http.ResponseWriter, request *http.Request) {
+	var report *HandlesReport
+	var err error
+	switch request.Method {
+	case http.MethodGet:
+		report, err = handler.ReportHandles()
+	case http.MethodPost:
+		logger.Info("Compacting handles by the control endpoint")
+		report, err = handler.CompactHandles()
+	default:
+		http.Error(writer, "handles are reported by GET and compacted by POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	control.WriteJson(writer, http.StatusOK, report)
+}
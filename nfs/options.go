@@ -1,55 +1,57 @@
-package main
+package nfs
 
 import (
 	"fmt"
 	"github.com/urfave/cli"
 	"gitreefs/core/common"
-	"os"
-	"path"
+	"strconv"
+	"time"
 )
 
 type options struct {
-	logFile    string
-	logLevel   string
-	clonesPath string
-	storagePath string
-	port       string
+	*common.SharedOptions
+	storagePath         string
+	host                string
+	port                string
+	exportRoot          string
+	accessPolicy        string
+	handleCacheSize     int
+	handleExpiry        time.Duration
+	maintenanceInterval time.Duration
 }
 
 var _ common.Options = &options{}
 
-func (opts *options) LogFile() string {
-	return opts.logFile
-}
-
-func (opts *options) LogLevel() string {
-	return opts.logLevel
-}
-
-func (app *NfsApp) ParseOptions(ctx *cli.Context) (common.Options, error) {
+func (app *App) ParseOptions(ctx *cli.Context) (common.Options, error) {
 
 	var err error
 	opts := &options{
-		logFile:   ctx.String("log-file"),
-		logLevel:  ctx.String("log-level"),
-		port:      "2049",
+		SharedOptions:       common.ParseSharedOptions(ctx),
+		storagePath:         ctx.String("storage-path"),
+		host:                ctx.String("host"),
+		port:                strconv.Itoa(ctx.Int("port")),
+		exportRoot:          ctx.String("export-root"),
+		accessPolicy:        ctx.String("access-policy"),
+		handleCacheSize:     ctx.Int("handles-cache-size"),
+		handleExpiry:        time.Duration(ctx.Int("handle-expiry-days")) * 24 * time.Hour,
+		maintenanceInterval: ctx.Duration("handles-maintenance-interval"),
 	}
 
 	args := ctx.Args()
 	argsLen := len(args)
-	if argsLen < 2 {
-		exeName := path.Base(os.Args[0])
-		return nil, fmt.Errorf("%s takes two to three arguments. Run `%s --help` for more info", exeName, exeName)
+	if argsLen >= 2 {
+		opts.ClonesPath = args[0]
+		opts.storagePath = args[1]
 	}
-
-	opts.clonesPath = args[0]
-	opts.storagePath = args[1]
-
 	if argsLen >= 3 {
 		opts.port = args[2]
 	}
+	if argsLen == 1 || argsLen > 3 || len(opts.ClonesPath) == 0 || len(opts.storagePath) == 0 {
+		return nil, fmt.Errorf("%s takes two to three arguments, unless set by options. Run `%s %s --help` for more info",
+			ctx.Command.Name, ctx.App.Name, ctx.Command.Name)
+	}
 
-	err = common.ValidateDirectory(opts.clonesPath, false)
+	err = common.ValidateDirectory(opts.ClonesPath, false)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,123 @@
+package nfs
+
+import (
+	"gitreefs/core/logger"
+	"sync"
+	"time"
+)
+
+const (
+	pendingFlushInterval = time.Second
+	pendingFlushSize     = 1000
+)
+
+// pendingHandles holds newly minted handles until they are written to the store in a single batch
+type pendingHandles struct {
+	mutex        *sync.Mutex
+	handleByPath map[string][]byte
+	pathByHandle map[string]string
+}
+
+func newPendingHandles() *pendingHandles {
+	return &pendingHandles{
+		mutex:        &sync.Mutex{},
+		handleByPath: make(map[string][]byte),
+		pathByHandle: make(map[string]string),
+	}
+}
+
+func (pending *pendingHandles) handle(path string) (handle []byte, found bool) {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	handle, found = pending.handleByPath[path]
+	return
+}
+
+func (pending *pendingHandles) path(handle []byte) (path string, found bool) {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	path, found = pending.pathByHandle[string(handle)]
+	return
+}
+
+// mint creates a handle for a path that has none, unless one was created for it concurrently
+func (handler *Handler) mint(fullPath string) (handle []byte, err error) {
+	pending := handler.pending
+	pending.mutex.Lock()
+
+	handle, found := pending.handleByPath[fullPath]
+	if found {
+		pending.mutex.Unlock()
+		return
+	}
+	// a concurrent flush could have just moved it from pending into the store
+	handle, err = handler.get([]byte(fullPath))
+	if err != nil || handle != nil {
+		pending.mutex.Unlock()
+		return
+	}
+
+	handle, err = newFileHandle()
+	if err != nil {
+		pending.mutex.Unlock()
+		return
+	}
+	pending.handleByPath[fullPath] = handle
+	pending.pathByHandle[string(handle)] = fullPath
+	shouldFlush := len(pending.handleByPath) >= pendingFlushSize
+	pending.mutex.Unlock()
+
+	handler.cache.add(fullPath, handle)
+	if shouldFlush {
+		err = handler.flushPending()
+	}
+	return
+}
+
+func (handler *Handler) flushPending() (err error) {
+	// hold the lock while writing, so a handle is always either pending or in the store
+	handler.pending.mutex.Lock()
+	defer handler.pending.mutex.Unlock()
+	if len(handler.pending.handleByPath) == 0 {
+		return
+	}
+
+	batch := handler.db.NewWriteBatch()
+	defer batch.Cancel()
+	accessTime := encodeAccessTime(time.Now().Unix())
+	for fullPath, handle := range handler.pending.handleByPath {
+		err = batch.Set([]byte(fullPath), handle)
+		if err == nil {
+			err = batch.Set(handle, []byte(fullPath))
+		}
+		if err == nil {
+			err = batch.Set(accessKey(handle), accessTime)
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = batch.Flush()
+	if err != nil {
+		return
+	}
+	handler.pending.handleByPath = make(map[string][]byte)
+	handler.pending.pathByHandle = make(map[string]string)
+	return
+}
+
+func (handler *Handler) flushPeriodically() {
+	ticker := time.NewTicker(pendingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-handler.stop:
+			return
+		case <-ticker.C:
+			err := handler.flushPending()
+			if err != nil {
+				logger.Error("handler.flushPeriodically: %v", err)
+			}
+		}
+	}
+}
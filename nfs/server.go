@@ -1,30 +1,68 @@
-package main
+package nfs
 
 import (
 	"fmt"
+	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs"
+	"gitreefs/control"
+	"gitreefs/core/git"
 	"gitreefs/core/logger"
 	"gitreefs/core/virtualfs/bfs"
 	"net"
 )
 
-func Serve(clonesPath string, host string, port string, storagePath string) error {
-	listener, err := net.Listen("tcp", host+":"+port)
+func Serve(opts *options) error {
+	clonesPath := opts.ClonesPath
+	err := git.Configure(opts.SharedOptions)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %v: %v", port, err)
+		return err
+	}
+	listener, err := net.Listen("tcp", opts.host+":"+opts.port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %v: %v", opts.port, err)
 	}
 
 	logger.Info("nfs server running at %s and mirroring git clones at %v", listener.Addr(), clonesPath)
 
-	fileSystem, err := bfs.NewGitFileSystem(clonesPath)
+	gitFileSystem, err := bfs.NewGitFileSystem(clonesPath)
 	if err != nil {
 		return fmt.Errorf("failed to create fuseserver on %v: %v", clonesPath, err)
 	}
 
-	handler, err := NewHandler(fileSystem, storagePath)
+	controlServer, err := control.ServeShared(opts.SharedOptions, gitFileSystem.Nodes())
+	if err != nil {
+		return err
+	}
+	if controlServer != nil {
+		defer controlServer.Close()
+	}
+
+	var fileSystem billy.Filesystem = gitFileSystem
+	if len(opts.exportRoot) > 0 {
+		fileSystem, err = gitFileSystem.Chroot(opts.exportRoot)
+		if err != nil {
+			return fmt.Errorf("failed to export root %v: %v", opts.exportRoot, err)
+		}
+		logger.Info("nfs server exporting %v as its root", opts.exportRoot)
+	}
+
+	var policy *AccessPolicy
+	if len(opts.accessPolicy) > 0 {
+		policy, err = LoadAccessPolicy(opts.accessPolicy)
+		if err != nil {
+			return err
+		}
+		logger.Info("nfs server allowing clients by the access policy at %v", opts.accessPolicy)
+	}
+
+	handler, err := NewHandler(fileSystem, policy, opts.storagePath, opts.handleCacheSize, opts.handleExpiry, opts.maintenanceInterval)
 	if err != nil {
 		return fmt.Errorf("failed to create handler on %v: %v", clonesPath, err)
 	}
+	defer handler.Close()
+	if controlServer != nil {
+		controlServer.Handle(HandlesPath, handler.serveHandles)
+	}
 
 	return nfs.Serve(listener, handler, logger.DebugLogger(), logger.InfoLogger())
 }
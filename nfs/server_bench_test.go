@@ -1,6 +1,6 @@
 // +build bench
 
-package main
+package nfs
 
 import (
 	"github.com/stretchr/testify/suite"
@@ -41,7 +41,7 @@ func (nfsSuite *nfsBenchmarkTestSuite) SetupTest() {
 
 	logger.Info("Serving")
 	go func() {
-		err = Serve(nfsSuite.clonesPath, "localhost", "2049", "data")
+		err = Serve(testOptions(nfsSuite.clonesPath, "data"))
 		panic(err)
 	}()
 }
@@ -1,7 +1,8 @@
-package main
+package nfs
 
 import (
 	"github.com/stretchr/testify/suite"
+	"gitreefs/core/common"
 	"gitreefs/core/logger"
 	testutils "gitreefs/test_utils"
 	"io/ioutil"
@@ -21,6 +22,18 @@ func TestNfsTestSuite(t *testing.T) {
 	suite.Run(t, new(nfsTestSuite))
 }
 
+func testOptions(clonesPath string, storagePath string) *options {
+	return &options{
+		SharedOptions:       common.NewSharedOptions(clonesPath, "INFO"),
+		storagePath:         storagePath,
+		host:                "localhost",
+		port:                "2049",
+		handleCacheSize:     DefaultHandleCacheSize,
+		handleExpiry:        DefaultHandleExpiry,
+		maintenanceInterval: DefaultMaintenanceInterval,
+	}
+}
+
 func (nfsSuite *nfsTestSuite) SetupTest() {
 
 	var err error
@@ -38,7 +51,7 @@ func (nfsSuite *nfsTestSuite) SetupTest() {
 
 	logger.Info("Serving")
 	go func() {
-		err = Serve(nfsSuite.clonesPath, "localhost", "2049", "data")
+		err = Serve(testOptions(nfsSuite.clonesPath, "data"))
 		panic(err)
 	}()
 
@@ -1,5 +0,0 @@
-package main
-
-const (
-	Version = "0.1"
-)
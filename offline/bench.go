@@ -0,0 +1,72 @@
+package offline
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+type benchTimes struct {
+	open     time.Duration
+	listTree time.Duration
+	read     time.Duration
+}
+
+func benchOnce(opts *options) (times *benchTimes, report *verifyReport, err error) {
+	times = &benchTimes{}
+	addr, err := parseAddress(opts.args[0])
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	provider, err := openProvider(opts.ClonesPath, addr.repositoryName)
+	if err != nil {
+		return
+	}
+	// every iteration opens the repository anew, so it's closed before the next one
+	defer provider.Close()
+	times.open = time.Since(start)
+
+	start = time.Now()
+	result, err := listTree(provider, addr)
+	if err != nil {
+		return
+	}
+	times.listTree = time.Since(start)
+
+	start = time.Now()
+	report = verifyTree(result, func(string, error) {})
+	times.read = time.Since(start)
+	return
+}
+
+func heapMB() float64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return float64(stats.Alloc) / (1024 * 1024)
+}
+
+func runBench(opts *options) error {
+	if opts.iterations < 1 {
+		return fmt.Errorf("bench requires at least one iteration")
+	}
+
+	total := &benchTimes{}
+	for i := 1; i <= opts.iterations; i++ {
+		times, report, err := benchOnce(opts)
+		if err != nil {
+			return err
+		}
+		total.open += times.open
+		total.listTree += times.listTree
+		total.read += times.read
+		fmt.Fprintf(opts.writer, "iteration %v: open %v, list tree %v, read %v files (%v bytes) %v, heap %.1f MB\n",
+			i, times.open, times.listTree, report.files, report.bytes, times.read, heapMB())
+	}
+
+	iterations := time.Duration(opts.iterations)
+	fmt.Fprintf(opts.writer, "average: open %v, list tree %v, read %v\n",
+		total.open/iterations, total.listTree/iterations, total.read/iterations)
+	return nil
+}
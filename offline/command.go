@@ -0,0 +1,140 @@
+package offline
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"gitreefs/core/logger"
+	"io"
+	"os"
+)
+
+// command reads git trees straight from the clones, without mounting them
+type command struct {
+	name      string
+	usage     string
+	argsUsage string
+	minArgs   int
+	maxArgs   int
+	flags     []cli.Flag
+	run       func(opts *options) error
+}
+
+var _ common.App = &command{}
+
+type options struct {
+	*common.SharedOptions
+	args       []string
+	writer     io.Writer
+	iterations int
+}
+
+const addressUsage = `ARGS:
+    address   <repository>/<commitish>[/path], as laid out in the virtual fs`
+
+// Commands are the commands for debugging the clones on hosts without FUSE or NFS
+func Commands() []cli.Command {
+	return []cli.Command{
+		common.Command(&command{
+			name:      "ls",
+			usage:     "List a directory of a commitish, or the repositories without an address",
+			argsUsage: "[address]",
+			minArgs:   0,
+			maxArgs:   1,
+			run:       runLs,
+		}),
+		common.Command(&command{
+			name:      "cat",
+			usage:     "Print the contents of a file of a commitish",
+			argsUsage: "address",
+			minArgs:   1,
+			maxArgs:   1,
+			run:       runCat,
+		}),
+		common.Command(&command{
+			name:      "stat",
+			usage:     "Print the commit, type and size of a path of a commitish",
+			argsUsage: "address",
+			minArgs:   1,
+			maxArgs:   1,
+			run:       runStat,
+		}),
+		common.Command(&command{
+			name:      "export",
+			usage:     "Write a commitish, or a directory within it, to an empty directory",
+			argsUsage: "address target-path",
+			minArgs:   2,
+			maxArgs:   2,
+			run:       runExport,
+		}),
+		common.Command(&command{
+			name:      "verify",
+			usage:     "Read all files of a commitish, or a directory within it, reporting those that can't be served",
+			argsUsage: "address",
+			minArgs:   1,
+			maxArgs:   1,
+			run:       runVerify,
+		}),
+		common.Command(&command{
+			name:      "bench",
+			usage:     "Time opening a repository, listing a commitish and reading all of its files",
+			argsUsage: "address",
+			minArgs:   1,
+			maxArgs:   1,
+			flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "iterations",
+					Value: 3,
+					Usage: "Number of times to repeat the benchmark.",
+				},
+			},
+			run: runBench,
+		}),
+	}
+}
+
+func (cmd *command) DeclareCommand() cli.Command {
+	command := cli.Command{
+		Name:        cmd.name,
+		Usage:       cmd.usage,
+		ArgsUsage:   cmd.argsUsage,
+		Description: addressUsage,
+		Flags:       append(common.SharedFlags("ERROR"), cmd.flags...),
+	}
+	common.DeclareConfig(&command, "")
+	return command
+}
+
+func (cmd *command) ParseOptions(ctx *cli.Context) (common.Options, error) {
+	if ctx.NArg() < cmd.minArgs || ctx.NArg() > cmd.maxArgs {
+		return nil, fmt.Errorf("%s takes %v. Run `%s %s --help` for more info",
+			cmd.name, cmd.argsUsage, ctx.App.Name, cmd.name)
+	}
+
+	sharedOptions := common.ParseSharedOptions(ctx)
+	if len(sharedOptions.ClonesPath) == 0 {
+		return nil, fmt.Errorf("%s requires --clones-path", cmd.name)
+	}
+	err := common.ValidateDirectory(sharedOptions.ClonesPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &options{
+		SharedOptions: sharedOptions,
+		args:          ctx.Args(),
+		writer:        ctx.App.Writer,
+		iterations:    ctx.Int("iterations"),
+	}, nil
+}
+
+func (cmd *command) RunUntilStopped(opts common.Options) error {
+	// the output of the command goes to stdout, so logs must not
+	logger.SetConsoleOutput(os.Stderr)
+	err := git.Configure(opts.(*options).SharedOptions)
+	if err != nil {
+		return err
+	}
+	return cmd.run(opts.(*options))
+}
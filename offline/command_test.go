@@ -0,0 +1,178 @@
+package offline
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+	testutils "gitreefs/test_utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func runCommand(t *testing.T, clonesPath string, args ...string) (string, error) {
+	output := &bytes.Buffer{}
+	app := &cli.App{
+		Name:     "gitreefs",
+		Writer:   output,
+		Commands: Commands(),
+	}
+	logsPath, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(logsPath)
+
+	command := append([]string{"gitreefs", args[0], "--clones-path", clonesPath, "--log-file", logsPath + "/offline-%v-%v.log"}, args[1:]...)
+	err = app.Run(command)
+	return output.String(), err
+}
+
+func TestLs(t *testing.T) {
+	clonesPath, commits := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	output, err := runCommand(t, clonesPath, "ls")
+	assert.Nil(t, err)
+	assert.Equal(t, "local/\n", output)
+
+	output, err = runCommand(t, clonesPath, "ls", "local/master")
+	assert.Nil(t, err)
+	assert.Equal(t, "f            8  README.md\nd            -  docs/\nd            -  src/\n", output)
+
+	output, err = runCommand(t, clonesPath, "ls", "local/"+commits[0][:7]+"/src/pkg")
+	assert.Nil(t, err)
+	assert.Equal(t, "f            0  empty.txt\nf           12  util.go\n", output)
+
+	output, err = runCommand(t, clonesPath, "ls", "/local/v1/src/main.go")
+	assert.Nil(t, err)
+	assert.Equal(t, "f           30  main.go\n", output)
+
+	for _, address := range []string{"local", "local/v1/docs", "local/wat", "wat/master", "local/../../master"} {
+		_, err = runCommand(t, clonesPath, "ls", address)
+		assert.NotNil(t, err, "listed %v", address)
+	}
+}
+
+func TestCat(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	for filePath, contents := range testutils.LocalFiles {
+		output, err := runCommand(t, clonesPath, "cat", "local/v1/"+filePath)
+		assert.Nil(t, err)
+		assert.Equal(t, contents, output, filePath)
+	}
+
+	_, err := runCommand(t, clonesPath, "cat", "local/v1/src")
+	assert.NotNil(t, err)
+	_, err = runCommand(t, clonesPath, "cat", "local/v1/docs/guide.md")
+	assert.NotNil(t, err)
+	_, err = runCommand(t, clonesPath, "cat")
+	assert.NotNil(t, err)
+}
+
+func TestStat(t *testing.T) {
+	clonesPath, commits := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	output, err := runCommand(t, clonesPath, "stat", "local/master/src/main.go")
+	assert.Nil(t, err)
+	assert.Contains(t, output, "commit:     "+commits[1]+"\n")
+	assert.Contains(t, output, "type:       file\nsize:       30\n")
+
+	output, err = runCommand(t, clonesPath, "stat", "local/v1")
+	assert.Nil(t, err)
+	assert.Contains(t, output, "commit:     "+commits[0]+"\n")
+	assert.Contains(t, output, "path:       /\ntype:       directory\nentries:    2\n")
+}
+
+func TestExport(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	targetPath := path.Join(clonesPath, "..", path.Base(clonesPath)+"-export")
+	defer os.RemoveAll(targetPath)
+
+	output, err := runCommand(t, clonesPath, "export", "local/v1", targetPath)
+	assert.Nil(t, err)
+	assert.Contains(t, output, "exported 4 files")
+	for filePath, contents := range testutils.LocalFiles {
+		exported, err := ioutil.ReadFile(path.Join(targetPath, filePath))
+		assert.Nil(t, err)
+		assert.Equal(t, contents, string(exported), filePath)
+	}
+
+	// the target must be empty
+	_, err = runCommand(t, clonesPath, "export", "local/v1", targetPath)
+	assert.NotNil(t, err)
+
+	subPath := path.Join(targetPath, "pkg")
+	_, err = runCommand(t, clonesPath, "export", "local/v1/src/pkg", subPath)
+	assert.Nil(t, err)
+	exported, err := ioutil.ReadFile(path.Join(subPath, "util.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.LocalFiles["src/pkg/util.go"], string(exported))
+
+	_, err = runCommand(t, clonesPath, "export", "local/v1/README.md", path.Join(targetPath, "readme"))
+	assert.NotNil(t, err)
+}
+
+func TestOpenTreeReadsListedCommit(t *testing.T) {
+	clonesPath, commits := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+	result, err := openTree(clonesPath, "local/master")
+	assert.Nil(t, err)
+	defer result.provider.Close()
+	assert.Equal(t, commits[1], result.canonical)
+
+	// files are read from the listed commit even after its ref moves
+	testutils.Commit(path.Join(clonesPath, testutils.LOCAL_REPO_NAME), map[string]string{"README.md": "# moved\n"}, "moved")
+	contents, err := result.provider.FileContents(result.canonical, "README.md")
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.LocalFiles["README.md"], contents)
+}
+
+func TestVerify(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	output, err := runCommand(t, clonesPath, "verify", "local/master")
+	assert.Nil(t, err)
+	assert.Equal(t, "verified 5 files (56 bytes) in 4 directories of local/master, 0 skipped as larger than 6 MB, 0 failed\n", output)
+
+	output, err = runCommand(t, clonesPath, "verify", "--max-file-size-mb", "0", "local/master/src")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(output, "verified 0 files (0 bytes) in 2 directories of local/master/src, 3 skipped"), output)
+}
+
+func TestBench(t *testing.T) {
+	clonesPath, _ := testutils.SetupLocalClones()
+	defer os.RemoveAll(clonesPath)
+
+	output, err := runCommand(t, clonesPath, "bench", "--iterations", "2", "local/master")
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "iteration 1: open "), lines[0])
+	assert.Contains(t, lines[1], "read 5 files (56 bytes)")
+	assert.True(t, strings.HasPrefix(lines[2], "average: "), lines[2])
+
+	_, err = runCommand(t, clonesPath, "bench", "--iterations", "0", "local/master")
+	assert.NotNil(t, err)
+}
+
+func TestParseAddress(t *testing.T) {
+	addr, err := parseAddress("/repo/master/src/../pkg/")
+	assert.Nil(t, err)
+	assert.Equal(t, &address{repositoryName: "repo", commitish: "master", path: "pkg"}, addr)
+	assert.Equal(t, "repo/master/pkg", addr.String())
+
+	addr, err = parseAddress("../../repo/master")
+	assert.Nil(t, err)
+	assert.Equal(t, &address{repositoryName: "repo", commitish: "master", path: ""}, addr)
+
+	for _, fullPath := range []string{"", "/", "repo", "repo/.."} {
+		_, err = parseAddress(fullPath)
+		assert.NotNil(t, err, "parsed %v", fullPath)
+	}
+}
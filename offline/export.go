@@ -0,0 +1,56 @@
+package offline
+
+import (
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runExport(opts *options) error {
+	result, err := openTree(opts.ClonesPath, opts.args[0])
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	if !result.entry.IsDir() {
+		return fmt.Errorf("%v is a file, only directories can be exported", result.address)
+	}
+
+	targetPath := opts.args[1]
+	err = common.ValidateDirectory(targetPath, true)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadDir(targetPath)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("target directory %v is not empty", targetPath)
+	}
+
+	var files, bytes int64
+	err = walk(result.address.path, result.entry, func(entryPath string, entry *git.Entry) error {
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(entryPath, result.address.path), "/")
+		fullPath := filepath.Join(targetPath, filepath.FromSlash(relativePath))
+		if entry.IsDir() {
+			return os.MkdirAll(fullPath, 0777)
+		}
+		contents, err := result.provider.FileContents(result.canonical, entryPath)
+		if err != nil {
+			return fmt.Errorf("failed to export %v: %w", entryPath, err)
+		}
+		files++
+		bytes += int64(len(contents))
+		return ioutil.WriteFile(fullPath, []byte(contents), 0666)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.writer, "exported %v files (%v bytes) of %v to %v\n", files, bytes, result.address, targetPath)
+	return nil
+}
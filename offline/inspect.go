@@ -0,0 +1,108 @@
+package offline
+
+import (
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func printEntry(writer io.Writer, name string, entry *git.Entry) {
+	if entry.IsDir() {
+		fmt.Fprintf(writer, "d %12v  %v/\n", "-", name)
+		return
+	}
+	if entry.IsMissing() {
+		fmt.Fprintf(writer, "f %12v  %v\n", "?", name)
+		return
+	}
+	fmt.Fprintf(writer, "f %12v  %v\n", entry.Size(), name)
+}
+
+func runLs(opts *options) error {
+	if len(opts.args) == 0 {
+		return listRepositories(opts)
+	}
+
+	result, err := openTree(opts.ClonesPath, opts.args[0])
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	if !result.entry.IsDir() {
+		printEntry(opts.writer, git.ExtractBaseName(result.address.path), result.entry)
+		return nil
+	}
+	for i, name := range result.entry.Names() {
+		printEntry(opts.writer, name, result.entry.ChildAt(i))
+	}
+	return nil
+}
+
+func listRepositories(opts *options) error {
+	infos, err := ioutil.ReadDir(opts.ClonesPath)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		_, err = common.ResolveClonePath(opts.ClonesPath, info.Name())
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(opts.writer, "%v/\n", info.Name())
+	}
+	return nil
+}
+
+func runCat(opts *options) error {
+	result, err := openTree(opts.ClonesPath, opts.args[0])
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	if result.entry.IsDir() {
+		return fmt.Errorf("%v is a directory", result.address)
+	}
+	contents, err := result.provider.FileContents(result.canonical, result.address.path)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(opts.writer, contents)
+	return err
+}
+
+func runStat(opts *options) error {
+	result, err := openTree(opts.ClonesPath, opts.args[0])
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	sha, err := result.provider.ResolveCommit(result.address.commitish)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{
+		"repository: " + result.address.repositoryName,
+		"commitish:  " + result.address.commitish,
+		"commit:     " + sha,
+		"path:       /" + result.address.path,
+	}
+	if result.entry.IsDir() {
+		lines = append(lines,
+			"type:       directory",
+			fmt.Sprintf("entries:    %v", result.entry.Len()))
+	} else {
+		lines = append(lines,
+			"type:       file")
+		if result.entry.IsMissing() {
+			lines = append(lines, "size:       unknown, missing from the partial clone")
+		} else {
+			lines = append(lines, fmt.Sprintf("size:       %v", result.entry.Size()))
+		}
+	}
+	_, err = fmt.Fprintln(opts.writer, strings.Join(lines, "\n"))
+	return err
+}
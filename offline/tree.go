@@ -0,0 +1,122 @@
+package offline
+
+import (
+	"fmt"
+	"gitreefs/core/common"
+	"gitreefs/core/git"
+	"os"
+	"path"
+	"strings"
+)
+
+// address is a path of the virtual fs, within a commitish of a repository
+type address struct {
+	repositoryName string
+	commitish      string
+	path           string
+}
+
+func parseAddress(fullPath string) (*address, error) {
+	components := strings.Split(strings.Trim(path.Clean("/"+fullPath), "/"), "/")
+	if len(components) < 2 {
+		return nil, fmt.Errorf("expected <repository>/<commitish>[/path], got '%v'", fullPath)
+	}
+	return &address{
+		repositoryName: components[0],
+		commitish:      components[1],
+		path:           strings.Join(components[2:], "/"),
+	}, nil
+}
+
+func (addr *address) String() string {
+	return path.Join(addr.repositoryName, addr.commitish, addr.path)
+}
+
+// tree is the listed tree of an address's commitish, with the entry at the address
+type tree struct {
+	address  *address
+	provider *git.RepositoryProvider
+	// canonical is the commitish resolved once when listing, which files are read by, so they're all of the listed
+	// commit even if its ref moves meanwhile
+	canonical string
+	root      *git.Entry
+	entry     *git.Entry
+}
+
+func openProvider(clonesPath string, repositoryName string) (provider *git.RepositoryProvider, err error) {
+	err = git.EnsureClone(clonesPath, repositoryName)
+	if err != nil {
+		return nil, fmt.Errorf("repository %v could not be cloned: %w", repositoryName, err)
+	}
+	clonePath, err := common.ResolveClonePath(clonesPath, repositoryName)
+	if err != nil {
+		return nil, fmt.Errorf("repository %v not found: %w", repositoryName, err)
+	}
+	provider, err = git.NewRepositoryProvider(clonePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %v: %w", repositoryName, err)
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("repository %v not found: %w", repositoryName, os.ErrNotExist)
+	}
+	return
+}
+
+// openTree opens the repository of an address and lists its tree, which is closed by the caller
+func openTree(clonesPath string, fullPath string) (*tree, error) {
+	addr, err := parseAddress(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := openProvider(clonesPath, addr.repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	result, err := listTree(provider, addr)
+	if err != nil {
+		provider.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// Close closes the provider the tree was listed by, stopping its processes and closing its pack files
+func (result *tree) Close() error {
+	return result.provider.Close()
+}
+
+func listTree(provider *git.RepositoryProvider, addr *address) (result *tree, err error) {
+	result = &tree{
+		address:  addr,
+		provider: provider,
+	}
+	result.canonical, err = provider.Canonicalize(addr.commitish)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %v/%v: %w", addr.repositoryName, addr.commitish, err)
+	}
+	result.root, err = provider.ListTree(result.canonical)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v/%v: %w", addr.repositoryName, addr.commitish, err)
+	}
+	var found bool
+	result.entry, found = result.root.Find(addr.path)
+	if !found {
+		return nil, fmt.Errorf("%v: %w", addr, os.ErrNotExist)
+	}
+	return
+}
+
+// walk visits an entry and all entries under it by the order of their names, with their paths within the commitish
+func walk(entryPath string, entry *git.Entry, visit func(entryPath string, entry *git.Entry) error) error {
+	err := visit(entryPath, entry)
+	if err != nil || !entry.IsDir() {
+		return err
+	}
+	for i, name := range entry.Names() {
+		err = walk(path.Join(entryPath, name), entry.ChildAt(i), visit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
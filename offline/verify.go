@@ -0,0 +1,61 @@
+package offline
+
+import (
+	"fmt"
+	"gitreefs/core/git"
+)
+
+// verifyReport counts the files that can be served, those skipped by the size limit and those that failed
+type verifyReport struct {
+	dirs    int64
+	files   int64
+	bytes   int64
+	skipped int64
+	failed  int64
+}
+
+func verifyTree(result *tree, onFailure func(entryPath string, err error)) (report *verifyReport) {
+	report = &verifyReport{}
+	walk(result.address.path, result.entry, func(entryPath string, entry *git.Entry) error {
+		if entry.IsDir() {
+			report.dirs++
+			return nil
+		}
+		if entry.Size() >= git.MaxFileSizeBytes {
+			report.skipped++
+			return nil
+		}
+		contents, err := result.provider.FileContents(result.canonical, entryPath)
+		// the size of a file missing from a partial clone is known only once it's read
+		if err == nil && !entry.IsMissing() && int64(len(contents)) != entry.Size() {
+			err = fmt.Errorf("read %v bytes out of %v", len(contents), entry.Size())
+		}
+		if err != nil {
+			report.failed++
+			onFailure(entryPath, err)
+			return nil
+		}
+		report.files++
+		report.bytes += int64(len(contents))
+		return nil
+	})
+	return
+}
+
+func runVerify(opts *options) error {
+	result, err := openTree(opts.ClonesPath, opts.args[0])
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	report := verifyTree(result, func(entryPath string, err error) {
+		fmt.Fprintf(opts.writer, "FAILED %v: %v\n", entryPath, err)
+	})
+	fmt.Fprintf(opts.writer, "verified %v files (%v bytes) in %v directories of %v, %v skipped as larger than %v MB, %v failed\n",
+		report.files, report.bytes, report.dirs, result.address, report.skipped, opts.MaxFileSizeMB, report.failed)
+	if report.failed > 0 {
+		return fmt.Errorf("%v files of %v failed verification", report.failed, result.address)
+	}
+	return nil
+}
@@ -57,6 +57,17 @@ func PrintMemoryUsage() {
 	logger.Info("\tHeap = %v GB ", float64(stats.Alloc)/(1024*1024*1024))
 }
 
+// HeapGrowth returns the bytes an op leaves allocated on the heap, after collecting garbage before and after it
+func HeapGrowth(op Op) (bytes int64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	op()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+}
+
 func walk(atPath string, readFile bool) {
 	filepath.Walk(atPath, func(path string, info os.FileInfo, err error) error {
 		if readFile && info != nil && !info.IsDir() {
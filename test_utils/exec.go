@@ -32,5 +32,9 @@ func ExecCommandWithDir(dir string, name string, arg ...string) {
 }
 
 func NfsMount(mountPoint string) {
-	ExecCommand("mount", "-o", "port=2049,mountport=2049,nfsvers=3,noacl,tcp", "-t", "nfs", "localhost:/", mountPoint)
+	NfsMountExport(mountPoint, "/")
+}
+
+func NfsMountExport(mountPoint string, exportPath string) {
+	ExecCommand("mount", "-o", "port=2049,mountport=2049,nfsvers=3,noacl,tcp", "-t", "nfs", "localhost:"+exportPath, mountPoint)
 }
@@ -0,0 +1,91 @@
+package testutils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	LOCAL_REPO_NAME = "local"
+)
+
+// LocalFiles are the files of the first commit of the local repository, the second commit adds LocalSecondCommitFiles
+var LocalFiles = map[string]string{
+	"README.md":         "# local\n",
+	"src/main.go":       "package main\n\nfunc main() {\n}\n",
+	"src/pkg/util.go":   "package pkg\n",
+	"src/pkg/empty.txt": "",
+}
+
+var LocalSecondCommitFiles = map[string]string{
+	"docs/guide.md": "guide\n",
+}
+
+func writeFiles(dirPath string, files map[string]string) {
+	for filePath, contents := range files {
+		fullPath := path.Join(dirPath, filePath)
+		err := os.MkdirAll(path.Dir(fullPath), 0777)
+		if err != nil {
+			panic(err)
+		}
+		err = ioutil.WriteFile(fullPath, []byte(contents), 0666)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func execGit(dir string, arg ...string) {
+	ExecCommandWithDir(dir, "git", append([]string{"-c", "user.name=gitreefs", "-c", "user.email=gitreefs@localhost"}, arg...)...)
+}
+
+func RevParse(dir string, revision string) string {
+	cmd := exec.Command("git", "rev-parse", revision)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Commit commits files to a clone, returning the sha of the commit
+func Commit(clonePath string, files map[string]string, message string) (sha string) {
+	writeFiles(clonePath, files)
+	execGit(clonePath, "add", "--all")
+	execGit(clonePath, "commit", "--quiet", "-m", message)
+	return RevParse(clonePath, "HEAD")
+}
+
+// SetupLocalClones creates a directory of clones with a single local repository, without any network access.
+// The repository has two commits on master, with the first one tagged as v1.
+func SetupLocalClones() (clonesPath string, commits []string) {
+	clonesPath, err := ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	clonePath := path.Join(clonesPath, LOCAL_REPO_NAME)
+	err = os.MkdirAll(clonePath, 0777)
+	if err != nil {
+		panic(err)
+	}
+
+	execGit(clonePath, "init", "--quiet")
+	execGit(clonePath, "symbolic-ref", "HEAD", "refs/heads/master")
+
+	writeFiles(clonePath, LocalFiles)
+	execGit(clonePath, "add", "--all")
+	execGit(clonePath, "commit", "--quiet", "-m", "first")
+	execGit(clonePath, "tag", "v1")
+	commits = append(commits, RevParse(clonePath, "HEAD"))
+
+	writeFiles(clonePath, LocalSecondCommitFiles)
+	execGit(clonePath, "add", "--all")
+	execGit(clonePath, "commit", "--quiet", "-m", "second")
+	commits = append(commits, RevParse(clonePath, "HEAD"))
+
+	return clonesPath, commits
+}
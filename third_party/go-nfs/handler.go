@@ -0,0 +1,58 @@
+package nfs
+
+import (
+	"context"
+	"net"
+
+	billy "github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// Handler represents the interface of the file system / vfs being exposed over NFS
+type Handler interface {
+	// Required methods
+
+	Mount(context.Context, net.Conn, MountRequest) (MountStatus, billy.Filesystem, []AuthFlavor)
+
+	// Change can return 'nil' if filesystem is read-only
+	Change(billy.Filesystem) billy.Change
+
+	// Optional methods - generic helpers or trivial implementations can be sufficient depending on use case.
+
+	// Fill in information about a file system's free space.
+	FSStat(context.Context, billy.Filesystem, *FSStat) error
+
+	// represent file objects as opaque references
+	// Can be safely implemented via helpers/cachinghandler.
+	ToHandle(fs billy.Filesystem, path []string) []byte
+	FromHandle(fh []byte) (billy.Filesystem, []string, error)
+	// How many handles can be safely maintained by the handler.
+	HandleLimit() int
+}
+
+// CallHandler is optionally implemented by handlers which check the client of every call using a file handle,
+// not only when mounting. FromHandleOfCall is then used instead of FromHandle, given the connection and
+// the credentials of the call. Errors it returns which are NFSStatusErrors are reported with their status,
+// any other error as a stale file handle.
+type CallHandler interface {
+	FromHandleOfCall(ctx context.Context, conn net.Conn, cred rpc.Auth, fh []byte) (billy.Filesystem, []string, error)
+}
+
+// fromHandle resolves a file handle of a call, returning an NFSStatusError if it can't
+func fromHandle(ctx context.Context, w *response, userHandle Handler, fh []byte) (billy.Filesystem, []string, error) {
+	var fs billy.Filesystem
+	var path []string
+	var err error
+	if callHandler, ok := userHandle.(CallHandler); ok {
+		fs, path, err = callHandler.FromHandleOfCall(ctx, w.conn.Conn, w.req.Header.Cred, fh)
+	} else {
+		fs, path, err = userHandle.FromHandle(fh)
+	}
+	if err != nil {
+		if statusErr, ok := err.(*NFSStatusError); ok {
+			return nil, nil, statusErr
+		}
+		return nil, nil, &NFSStatusError{NFSStatusStale, err}
+	}
+	return fs, path, nil
+}